@@ -0,0 +1,447 @@
+// Package client talks to the HTTP API of a peerpoker node. Requests and
+// responses use the same types as the node itself, so a bot written against
+// it breaks at compile time rather than at the table when the API changes.
+//
+//	c, err := client.New("http://localhost:8080", client.WithToken(token))
+//	table, err := c.Table(ctx)
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RedPaladin7/peerpoker/p2p"
+)
+
+const (
+	defaultTimeout = 10 * time.Second
+	defaultRetries = 3
+	defaultBackoff = 200 * time.Millisecond
+)
+
+type Client struct {
+	baseURL 	*url.URL
+	token 		string
+	http 		*http.Client
+	retries 	int
+	backoff 	time.Duration
+}
+
+type Option func(*Client)
+
+// WithToken sets the API token the node printed when it started.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithHTTPClient replaces the HTTP client, for example to change timeouts.
+// Event streams should not have a client-wide timeout.
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) { c.http = h }
+}
+
+// WithRetries sets how many times a read is retried after a network error or
+// a 502, 503 or 504, waiting backoff before the first retry and twice as long
+// before each one after. Actions are only retried when they carry a request
+// ID, since the first attempt may have reached the table.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid node URL %s: %s", baseURL, err)
+	}
+	c := &Client{
+		baseURL: 	u,
+		http: 		&http.Client{Timeout: defaultTimeout},
+		retries: 	defaultRetries,
+		backoff: 	defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// IsCode reports whether err is an API error with the given code.
+func IsCode(err error, code p2p.ErrorCode) bool {
+	var apiErr *p2p.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+func (c *Client) url(path string, query url.Values) string {
+	u := c.baseURL.JoinPath(path)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path, query), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// send makes one request and returns the response if it succeeded. A failed
+// request comes back as a *p2p.APIError.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, readAPIError(resp)
+}
+
+func readAPIError(resp *http.Response) *p2p.APIError {
+	data, _ := io.ReadAll(resp.Body)
+	apiErr := &p2p.APIError{}
+	if err := json.Unmarshal(data, apiErr); err != nil || apiErr.Code == "" {
+		apiErr = &p2p.APIError{Code: p2p.CodeInternal, Message: string(bytes.TrimSpace(data))}
+	}
+	apiErr.Status = resp.StatusCode
+	return apiErr
+}
+
+// NewRequestID returns a random request ID for Act.
+func NewRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// idempotent reports whether a request is safe to send again: reads, and
+// actions with a request ID, which the node takes only once.
+func idempotent(method string, body any) bool {
+	if method == http.MethodGet {
+		return true
+	}
+	req, ok := body.(p2p.ActionRequest)
+	return ok && req.RequestID != ""
+}
+
+func retryable(err error) bool {
+	var apiErr *p2p.APIError
+	if !errors.As(err, &apiErr) {
+		// the node could not be reached
+		return true
+	}
+	switch apiErr.Status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// do sends a request and decodes the JSON answer into out. Idempotent
+// requests are retried, anything else is sent once.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out any) (http.Header, error) {
+	attempts := 1
+	if idempotent(method, body) {
+		attempts += c.retries
+	}
+	wait := c.backoff
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			wait *= 2
+		}
+		var resp *http.Response
+		resp, err = c.send(ctx, method, path, query, body)
+		if err == nil {
+			defer resp.Body.Close()
+			if out == nil {
+				return resp.Header, nil
+			}
+			return resp.Header, json.NewDecoder(resp.Body).Decode(out)
+		}
+		if ctx.Err() != nil || !retryable(err) {
+			break
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) get(ctx context.Context, path string, query url.Values, out any) error {
+	_, err := c.do(ctx, http.MethodGet, path, query, nil, out)
+	return err
+}
+
+func (c *Client) action(ctx context.Context, method, path string, body any) (*p2p.ActionResponse, error) {
+	resp := &p2p.ActionResponse{}
+	if _, err := c.do(ctx, method, path, nil, body, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) Health(ctx context.Context) (*p2p.HealthResponse, error) {
+	resp := &p2p.HealthResponse{}
+	return resp, c.get(ctx, "/api/health", nil, resp)
+}
+
+func (c *Client) Table(ctx context.Context) (*p2p.TableStateResponse, error) {
+	resp := &p2p.TableStateResponse{}
+	return resp, c.get(ctx, "/api/table", nil, resp)
+}
+
+func (c *Client) Players(ctx context.Context) (*p2p.PlayerResponse, error) {
+	resp := &p2p.PlayerResponse{}
+	return resp, c.get(ctx, "/api/players", nil, resp)
+}
+
+func (c *Client) Seats(ctx context.Context) (*p2p.SeatsResponse, error) {
+	resp := &p2p.SeatsResponse{}
+	return resp, c.get(ctx, "/api/seats", nil, resp)
+}
+
+func (c *Client) Ledger(ctx context.Context) (*p2p.LedgerResponse, error) {
+	resp := &p2p.LedgerResponse{}
+	return resp, c.get(ctx, "/api/ledger", nil, resp)
+}
+
+func (c *Client) Stats(ctx context.Context, player string) (*p2p.StatsResponse, error) {
+	resp := &p2p.StatsResponse{}
+	return resp, c.get(ctx, "/api/stats/"+url.PathEscape(player), nil, resp)
+}
+
+// History lists recorded hands, newest first.
+func (c *Client) History(ctx context.Context, offset, limit int) (*p2p.HandHistoryListResponse, error) {
+	query := url.Values{}
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+	resp := &p2p.HandHistoryListResponse{}
+	return resp, c.get(ctx, "/api/history", query, resp)
+}
+
+func (c *Client) Hand(ctx context.Context, id int64) (*p2p.HandHistory, error) {
+	resp := &p2p.HandHistory{}
+	return resp, c.get(ctx, fmt.Sprintf("/api/history/%d", id), nil, resp)
+}
+
+// HandOHH returns a recorded hand in the Open Hand History format.
+func (c *Client) HandOHH(ctx context.Context, id int64) (*p2p.OpenHandHistory, error) {
+	query := url.Values{}
+	query.Set("format", "ohh")
+	resp := &p2p.OpenHandHistory{}
+	return resp, c.get(ctx, fmt.Sprintf("/api/history/%d", id), query, resp)
+}
+
+// HandPokerStars returns a recorded hand as PokerStars hand history text.
+func (c *Client) HandPokerStars(ctx context.Context, id int64) (string, error) {
+	query := url.Values{}
+	query.Set("format", "pokerstars")
+	resp, err := c.send(ctx, http.MethodGet, fmt.Sprintf("/api/history/%d", id), query, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	text, err := io.ReadAll(resp.Body)
+	return string(text), err
+}
+
+// Replay returns the table of a recorded hand at a step, along with the
+// hand's last step.
+func (c *Client) Replay(ctx context.Context, id int64, step int) (*p2p.TableStateResponse, int, error) {
+	query := url.Values{}
+	query.Set("step", strconv.Itoa(step))
+	resp := &p2p.TableStateResponse{}
+	header, err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/replay/%d", id), query, nil, resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	last, err := strconv.Atoi(header.Get("X-Replay-Last-Step"))
+	if err != nil {
+		return nil, 0, fmt.Errorf("node sent no last replay step")
+	}
+	return resp, last, nil
+}
+
+func (c *Client) Connect(ctx context.Context, addr string) (*p2p.ConnectResponse, error) {
+	resp := &p2p.ConnectResponse{}
+	if _, err := c.do(ctx, http.MethodPost, "/api/connect", nil, p2p.ConnectRequest{Addr: addr}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) Ready(ctx context.Context) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodPost, "/api/ready", nil)
+}
+
+// Act takes a betting action. With a request ID it is retried like a read,
+// and a retry that reaches the node after the first attempt did gets the
+// first result back, marked Replayed. HandNumber, Street and Turn, from the
+// table the action was chosen on, make the node reject it once the table has
+// moved on.
+func (c *Client) Act(ctx context.Context, action p2p.PlayerAction, req p2p.ActionRequest) (*p2p.ActionResponse, error) {
+	switch action {
+	case p2p.PlayerActionFold, p2p.PlayerActionCheck, p2p.PlayerActionCall, p2p.PlayerActionBet, p2p.PlayerActionRaise:
+	default:
+		return nil, fmt.Errorf("%s is not a betting action", action)
+	}
+	return c.action(ctx, http.MethodPost, "/api/"+strings.ToLower(action.String()), req)
+}
+
+func (c *Client) Fold(ctx context.Context) (*p2p.ActionResponse, error) {
+	return c.Act(ctx, p2p.PlayerActionFold, p2p.ActionRequest{})
+}
+
+func (c *Client) Check(ctx context.Context) (*p2p.ActionResponse, error) {
+	return c.Act(ctx, p2p.PlayerActionCheck, p2p.ActionRequest{})
+}
+
+func (c *Client) Call(ctx context.Context) (*p2p.ActionResponse, error) {
+	return c.Act(ctx, p2p.PlayerActionCall, p2p.ActionRequest{})
+}
+
+func (c *Client) Bet(ctx context.Context, value int) (*p2p.ActionResponse, error) {
+	return c.Act(ctx, p2p.PlayerActionBet, p2p.ActionRequest{Value: value})
+}
+
+// Raise raises to value, our total bet on the street.
+func (c *Client) Raise(ctx context.Context, value int) (*p2p.ActionResponse, error) {
+	return c.Act(ctx, p2p.PlayerActionRaise, p2p.ActionRequest{Value: value})
+}
+
+// Straddle announces a straddle for the next hand, kind is UTG or BUTTON.
+func (c *Client) Straddle(ctx context.Context, kind string) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodPost, "/api/straddle", p2p.StraddleRequest{Type: kind})
+}
+
+func (c *Client) RunIt(ctx context.Context, runs int) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodPost, "/api/runit", p2p.RunItRequest{Runs: runs})
+}
+
+// Show shows some of our hole cards, both if cards is empty.
+func (c *Client) Show(ctx context.Context, cards ...int) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodPost, "/api/show", p2p.ShowCardsRequest{Cards: cards})
+}
+
+func (c *Client) Muck(ctx context.Context) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodPost, "/api/muck", nil)
+}
+
+func (c *Client) RabbitHunt(ctx context.Context) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodPost, "/api/rabbit", nil)
+}
+
+func (c *Client) TakeSeat(ctx context.Context, seat int) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodPost, "/api/seat", p2p.SeatRequest{Seat: seat})
+}
+
+func (c *Client) LeaveSeat(ctx context.Context) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodDelete, "/api/seat", nil)
+}
+
+func (c *Client) JoinWaitList(ctx context.Context) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodPost, "/api/waitlist", nil)
+}
+
+func (c *Client) Rebuy(ctx context.Context, value int, txHash string) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodPost, "/api/rebuy", p2p.BuyInRequest{Value: value, TxHash: txHash})
+}
+
+func (c *Client) TopUp(ctx context.Context, value int, txHash string) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodPost, "/api/topup", p2p.BuyInRequest{Value: value, TxHash: txHash})
+}
+
+func (c *Client) AddOn(ctx context.Context, txHash string) (*p2p.ActionResponse, error) {
+	return c.action(ctx, http.MethodPost, "/api/addon", p2p.BuyInRequest{TxHash: txHash})
+}
+
+// Admin returns the table's control state. Admin calls need the admin token.
+func (c *Client) Admin(ctx context.Context) (*p2p.AdminResponse, error) {
+	resp := &p2p.AdminResponse{}
+	return resp, c.get(ctx, "/api/admin", nil, resp)
+}
+
+// control issues a control command. It is applied at once on the host and
+// otherwise waits for other players to approve it, see Applied.
+func (c *Client) control(ctx context.Context, method, path string, body any) (*p2p.ControlResponse, error) {
+	resp := &p2p.ControlResponse{}
+	if _, err := c.do(ctx, method, path, nil, body, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) Pause(ctx context.Context) (*p2p.ControlResponse, error) {
+	return c.control(ctx, http.MethodPost, "/api/admin/pause", nil)
+}
+
+func (c *Client) Resume(ctx context.Context) (*p2p.ControlResponse, error) {
+	return c.control(ctx, http.MethodPost, "/api/admin/resume", nil)
+}
+
+// AbortHand calls off the hand being played, refunds its bets and pauses
+// the table.
+func (c *Client) AbortHand(ctx context.Context) (*p2p.ControlResponse, error) {
+	return c.control(ctx, http.MethodPost, "/api/admin/abort", nil)
+}
+
+func (c *Client) Kick(ctx context.Context, identity string) (*p2p.ControlResponse, error) {
+	return c.control(ctx, http.MethodPost, "/api/admin/kick", p2p.ControlTargetRequest{Identity: identity})
+}
+
+func (c *Client) Ban(ctx context.Context, identity string) (*p2p.ControlResponse, error) {
+	return c.control(ctx, http.MethodPost, "/api/admin/ban", p2p.ControlTargetRequest{Identity: identity})
+}
+
+func (c *Client) Unban(ctx context.Context, identity string) (*p2p.ControlResponse, error) {
+	return c.control(ctx, http.MethodPost, "/api/admin/unban", p2p.ControlTargetRequest{Identity: identity})
+}
+
+// ChangeConfig changes the fields of the table config that change sets, from
+// the next hand if one is being played.
+func (c *Client) ChangeConfig(ctx context.Context, change p2p.TableConfigChange) (*p2p.ControlResponse, error) {
+	return c.control(ctx, http.MethodPut, "/api/admin/config", change)
+}
+
+// ApproveControl signs a control command another player issued.
+func (c *Client) ApproveControl(ctx context.Context, id string) (*p2p.ControlResponse, error) {
+	return c.control(ctx, http.MethodPost, "/api/admin/approve", p2p.ApproveControlRequest{ID: id})
+}
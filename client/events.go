@@ -0,0 +1,152 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RedPaladin7/peerpoker/p2p"
+)
+
+const defaultReconnectDelay = 3 * time.Second
+
+// Event is a table event from the node's event stream. Data is left raw so
+// it can be decoded into the payload type that goes with Type.
+type Event struct {
+	// ID resumes the stream after this event
+	ID 		string 				`json:"-"`
+	Seq 	uint64 				`json:"seq"`
+	Type 	p2p.TableEventType 	`json:"type"`
+	Time 	time.Time 			`json:"time"`
+	Data 	json.RawMessage 	`json:"data"`
+}
+
+// Decode decodes the payload, for example into a p2p.ActionEvent when Type
+// is p2p.TableEventActionTaken.
+func (e Event) Decode(v any) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// Subscribe follows the node's table events and calls handle for each one
+// until ctx is done or handle returns an error. The first event is a full
+// state frame. When the connection drops it reconnects and resumes after the
+// last event handled; if the node no longer has the events in between it
+// starts over with a new state frame.
+func (c *Client) Subscribe(ctx context.Context, handle func(Event) error) error {
+	lastID := ""
+	delay := defaultReconnectDelay
+	for {
+		err := c.stream(ctx, lastID, &delay, func(event Event) error {
+			if err := handle(event); err != nil {
+				return err
+			}
+			lastID = event.ID
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handlerError marks an error from the subscriber's handler, which ends the
+// subscription instead of causing a reconnect.
+type handlerError struct {
+	err error
+}
+
+func (e handlerError) Error() string { return e.err.Error() }
+
+// stream reads one connection of the event stream. It returns nil when the
+// connection ended and a reconnect is worth trying.
+func (c *Client) stream(ctx context.Context, lastID string, delay *time.Duration, handle func(Event) error) error {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/events", nil, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	// the stream outlives any client-wide timeout
+	streamClient := *c.http
+	streamClient.Timeout = 0
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		if err := readAPIError(resp); !retryable(err) {
+			return err
+		}
+		return nil
+	}
+
+	err = readEvents(bufio.NewScanner(resp.Body), delay, func(event Event) error {
+		if err := handle(event); err != nil {
+			return handlerError{err}
+		}
+		return nil
+	})
+	if herr, ok := err.(handlerError); ok {
+		return herr.err
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// readEvents parses Server-Sent Events until the stream ends.
+func readEvents(scanner *bufio.Scanner, delay *time.Duration, handle func(Event) error) error {
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	id := ""
+	data := []string{}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(data) > 0 {
+				event := Event{ID: id}
+				if err := json.Unmarshal([]byte(strings.Join(data, "\n")), &event); err != nil {
+					return fmt.Errorf("bad event from node: %s", err)
+				}
+				if err := handle(event); err != nil {
+					return err
+				}
+			}
+			data = data[:0]
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			// heartbeat
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			id = value
+		case "data":
+			data = append(data, value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil {
+				*delay = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return scanner.Err()
+}
@@ -5,7 +5,7 @@ import { usePlayerAction } from '@/hooks/usePlayerAction';
 
 export default function GameTable() {
   const { table, players, loading, connected, refreshState } = useGameState();
-  const { executeAction, loading: actionLoading, error: actionError } = usePlayerAction(refreshState);
+  const { executeAction, loading: actionLoading, error: actionError } = usePlayerAction(refreshState, table);
 
   if (loading && !table) {
     return (
@@ -1,5 +1,5 @@
 import { apiClient } from "@/lib/api_client";
-import { PlayersResponse, TableStateResponse } from "@/types/api";
+import { PlayersResponse, StateEvent, TableEvent, TableStateResponse } from "@/types/api";
 import { useCallback, useEffect, useState } from "react";
 
 interface GameState {
@@ -26,12 +26,15 @@ export function useGameState(
         connected: false
     })
     const [interval, setInterval] = useState(pollingInterval)
+    // polling is only a fallback for when the event stream is down
+    const [streaming, setStreaming] = useState(false)
 
     useEffect(()=>{
         if (typeof window !== "undefined") {
             const params = new URLSearchParams(window.location.search)
             const port = params.get("port") || "8080"
             apiClient.setBaseURL(`http://localhost:${port}`)
+            apiClient.setToken(params.get("token") || process.env.NEXT_PUBLIC_API_TOKEN || null)
             console.log(`Fronted connected to Go API on port: ${port}`)
         }
     }, [])
@@ -75,12 +78,69 @@ export function useGameState(
     }, [fetchGameState])
 
     useEffect(()=> {
-        if (!state.connected || interval <= 0) {
+        let socket: WebSocket | null = null
+        let source: EventSource | null = null
+        let retry: number | undefined
+        let refetch: number | undefined
+        let stopped = false
+
+        const onEvent = (event: TableEvent) => {
+            setStreaming(true)
+            if (event.type === "state") {
+                const data = event.data as StateEvent
+                setState({
+                    table: data.table,
+                    players: data.players,
+                    loading: false,
+                    error: null,
+                    connected: true
+                })
+                return
+            }
+            // events say what changed; one fetch covers a burst of them
+            if (refetch === undefined) {
+                refetch = window.setTimeout(() => {
+                    refetch = undefined
+                    fetchGameState()
+                }, 50)
+            }
+        }
+
+        const connect = () => {
+            let opened = false
+            socket = apiClient.openEventStream((event) => {
+                opened = true
+                onEvent(event)
+            }, () => {
+                setStreaming(false)
+                if (stopped) {
+                    return
+                }
+                if (!opened) {
+                    // WebSockets do not get through, Server-Sent Events may
+                    source = apiClient.openEventSource(onEvent, () => setStreaming(false))
+                    return
+                }
+                retry = window.setTimeout(connect, 3000)
+            })
+        }
+        connect()
+        return () => {
+            stopped = true
+            window.clearTimeout(retry)
+            window.clearTimeout(refetch)
+            socket?.close()
+            source?.close()
+        }
+    }, [fetchGameState])
+
+    useEffect(()=> {
+        if (streaming || !state.connected || interval <= 0) {
             return 
         }
         const timer = window.setInterval(fetchGameState, interval)
         return () => window.clearInterval(timer)
-    }, [interval, state.connected, fetchGameState])
+    }, [interval, streaming, state.connected, fetchGameState])
 
     return {
         ...state,
@@ -1,5 +1,5 @@
 import { apiClient } from "@/lib/api_client";
-import { PlayerAction } from "@/types/api";
+import { ActionOptions, PlayerAction, TableStateResponse } from "@/types/api";
 import { useCallback, useState } from "react";
 
 interface usePlayerActionReturn {
@@ -9,8 +9,11 @@ interface usePlayerActionReturn {
     lastAction: PlayerAction | null
 }
 
+// Actions are pinned to the table they were chosen on, so a click that lands
+// after the hand moved on is rejected instead of applied to the wrong spot.
 export function usePlayerAction(
-    onSuccess?: () => void
+    onSuccess?: () => void,
+    table?: TableStateResponse | null
 ): usePlayerActionReturn {
     const [loading, setLoading] = useState(false)
     const [error, setError] = useState<string | null>(null)
@@ -21,31 +24,37 @@ export function usePlayerAction(
             setLoading(true)
             setError(null)
             try {
+                const opts: ActionOptions = table ? {
+                    request_id: crypto.randomUUID(),
+                    hand_number: table.hand_number,
+                    street: table.status,
+                    turn: table.turn,
+                } : {}
                 let response 
                 switch (action){
                     case "READY":
                         response = await apiClient.ready()
                         break
                     case "FOLD":
-                        response = await apiClient.fold()
+                        response = await apiClient.fold(opts)
                         break
                     case "CALL":
-                        response = await apiClient.call()
+                        response = await apiClient.call(opts)
                         break
                     case "CHECK":
-                        response = await apiClient.check()
+                        response = await apiClient.check(opts)
                         break
                     case "BET":
                         if (value == undefined) {
                             throw new Error("Value is required for BET action")
                         }
-                        response = await apiClient.bet(value)
+                        response = await apiClient.bet(value, opts)
                         break
                     case "RAISE":
                         if (value == undefined) {
                             throw new Error("Value is required for RAISE action")
                         }
-                        response = await apiClient.raise(value)
+                        response = await apiClient.raise(value, opts)
                         break
                     default:
                         throw new Error("Invalid action")
@@ -63,7 +72,7 @@ export function usePlayerAction(
                 setLoading(false)
             }
         },
-        [onSuccess]
+        [onSuccess, table]
     )
 
     return {
@@ -1,8 +1,41 @@
-import { ActionRequest, ActionResponse, HealthResponse, PlayersResponse, TableStateResponse } from "@/types/api";
+import { ActionOptions, ActionRequest, ActionResponse, APIErrorResponse, ErrorCode, HandHistoryListResponse, HealthResponse, PlayersResponse, SeatRequest, SeatsResponse, StatsResponse, TableEvent, TableEventType, TableStateResponse } from "@/types/api";
 import axios, { AxiosInstance } from "axios";
 
+const TABLE_EVENT_TYPES: TableEventType[] = [
+    "state",
+    "player_joined",
+    "player_left",
+    "hand_started",
+    "action_taken",
+    "street_changed",
+    "cards_revealed",
+    "pot_awarded",
+    "turn_changed",
+    "hand_complete",
+    "player_ready",
+    "seat_changed",
+    "buy_in",
+    "status_changed",
+    "control",
+]
+
+// APIRequestError is a request the node turned down. Branch on code, the
+// message is for people.
+export class APIRequestError extends Error {
+    constructor(
+        public status: number,
+        public code: ErrorCode,
+        message: string,
+        public details?: APIErrorResponse["details"],
+    ) {
+        super(message)
+        this.name = "APIRequestError"
+    }
+}
+
 class PokerAPIClient {
     private client: AxiosInstance
+    private token: string | null = null
 
     constructor(baseURL: string = "http://localhost:8080") {
         this.client = axios.create({
@@ -17,6 +50,10 @@ class PokerAPIClient {
             (response) => response, 
             (error) => {
                 console.error("API Error: ", error.response?.data || error.message)
+                const body = error.response?.data as APIErrorResponse | undefined
+                if (body?.code) {
+                    return Promise.reject(new APIRequestError(error.response.status, body.code, body.error, body.details))
+                }
                 return Promise.reject(error)
             }
         )
@@ -42,40 +79,144 @@ class PokerAPIClient {
         return response.data
     }
 
-    async fold(): Promise<ActionResponse> {
-        const response = await this.client.post<ActionResponse>("/api/fold")
+    async fold(opts: ActionOptions = {}): Promise<ActionResponse> {
+        const response = await this.client.post<ActionResponse>("/api/fold", opts as ActionRequest)
         return response.data
     }
 
-    async check(): Promise<ActionResponse> {
-        const response = await this.client.post<ActionResponse>("/api/check")
+    async check(opts: ActionOptions = {}): Promise<ActionResponse> {
+        const response = await this.client.post<ActionResponse>("/api/check", opts as ActionRequest)
         return response.data
     }
     
-    async call(): Promise<ActionResponse> {
-        const response = await this.client.post<ActionResponse>("/api/call")
+    async call(opts: ActionOptions = {}): Promise<ActionResponse> {
+        const response = await this.client.post<ActionResponse>("/api/call", opts as ActionRequest)
         return response.data
     }
 
-    async bet(value: number): Promise<ActionResponse> {
+    async bet(value: number, opts: ActionOptions = {}): Promise<ActionResponse> {
         const response = await this.client.post<ActionResponse>(
             "/api/bet",
-            {value} as ActionRequest
+            {...opts, value} as ActionRequest
         )
         return response.data
     }
 
-    async raise(value: number): Promise<ActionResponse> {
+    async raise(value: number, opts: ActionOptions = {}): Promise<ActionResponse> {
         const response = await this.client.post<ActionResponse>(
             "/api/raise",
-            {value} as ActionRequest
+            {...opts, value} as ActionRequest
+        )
+        return response.data
+    }
+
+    async getSeats(): Promise<SeatsResponse> {
+        const response = await this.client.get<SeatsResponse>("/api/seats")
+        return response.data
+    }
+
+    async takeSeat(seat: number): Promise<ActionResponse> {
+        const response = await this.client.post<ActionResponse>(
+            "/api/seat",
+            {seat} as SeatRequest
+        )
+        return response.data
+    }
+
+    async leaveSeat(): Promise<ActionResponse> {
+        const response = await this.client.delete<ActionResponse>("/api/seat")
+        return response.data
+    }
+
+    async joinWaitList(): Promise<ActionResponse> {
+        const response = await this.client.post<ActionResponse>("/api/waitlist")
+        return response.data
+    }
+
+    async getHandHistory(offset: number = 0, limit: number = 20): Promise<HandHistoryListResponse> {
+        const response = await this.client.get<HandHistoryListResponse>(
+            "/api/history",
+            {params: {offset, limit}}
+        )
+        return response.data
+    }
+
+    async getReplay(handID: number, step: number): Promise<{state: TableStateResponse, lastStep: number}> {
+        const response = await this.client.get<TableStateResponse>(
+            `/api/replay/${handID}`,
+            {params: {step}}
         )
+        return {
+            state: response.data,
+            lastStep: Number(response.headers["x-replay-last-step"]),
+        }
+    }
+
+    async getStats(player: string): Promise<StatsResponse> {
+        const response = await this.client.get<StatsResponse>(`/api/stats/${encodeURIComponent(player)}`)
         return response.data
     }
 
+    // openEventStream connects to /api/ws. The first event is always a full
+    // "state" frame, every later one is a change as it happens.
+    openEventStream(onEvent: (event: TableEvent) => void, onClose: () => void): WebSocket {
+        const url = this.streamURL("/api/ws")
+        url.protocol = url.protocol === "https:" ? "wss:" : "ws:"
+        const socket = new WebSocket(url.toString())
+        socket.onmessage = (message) => {
+            try {
+                onEvent(JSON.parse(message.data) as TableEvent)
+            } catch (error) {
+                console.error("Bad event from server:", error)
+            }
+        }
+        socket.onclose = () => onClose()
+        return socket
+    }
+
+    // openEventSource is the Server-Sent Events twin of openEventStream, for
+    // networks that break WebSockets. The browser reconnects by itself and
+    // resumes from the last event it saw.
+    openEventSource(onEvent: (event: TableEvent) => void, onError: () => void): EventSource {
+        const source = new EventSource(this.streamURL("/api/events").toString())
+        const handle = (message: MessageEvent) => {
+            try {
+                onEvent(JSON.parse(message.data) as TableEvent)
+            } catch (error) {
+                console.error("Bad event from server:", error)
+            }
+        }
+        for (const type of TABLE_EVENT_TYPES) {
+            source.addEventListener(type, handle)
+        }
+        source.onerror = () => onError()
+        return source
+    }
+
+    // streamURL builds a push channel URL. Browsers cannot set headers on
+    // WebSockets and EventSources, so the token goes in the query instead.
+    private streamURL(path: string): URL {
+        const url = new URL(path, this.client.defaults.baseURL)
+        if (this.token) {
+            url.searchParams.set("token", this.token)
+        }
+        return url
+    }
+
     setBaseURL(baseURL: string): void {
         this.client.defaults.baseURL = baseURL
     }
+
+    // setToken sets the API token the node printed on startup. A spectator
+    // token can watch the table but not act.
+    setToken(token: string | null): void {
+        this.token = token
+        if (token) {
+            this.client.defaults.headers.common["Authorization"] = `Bearer ${token}`
+        } else {
+            delete this.client.defaults.headers.common["Authorization"]
+        }
+    }
 }
 
 export const apiClient = new PokerAPIClient()
@@ -1,3 +1,6 @@
+// These types follow frontend/openapi.json, which the node generates from its
+// handlers (make openapi). make contract fails once the two diverge.
+
 export interface CardResponse {
     suit: string 
     value: number 
@@ -6,6 +9,8 @@ export interface CardResponse {
 
 export interface TableStateResponse {
     status: string 
+    hand_number: number
+    turn: number
     my_hand: CardResponse[]
     community_cards: CardResponse[]
     pot: number 
@@ -19,7 +24,26 @@ export interface TableStateResponse {
     dealer_id: number 
     small_blind: number 
     big_blind: number 
-    time_bank?: number
+    straddle?: number
+    run_it_pending: boolean
+    can_show_or_muck: boolean
+    shown_hands?: Record<string, CardResponse[]>
+    runs?: RunResponse[]
+    rabbit_votes_needed?: number
+    rabbit_hunt?: RabbitHuntResponse
+    paused: boolean
+}
+
+export interface RunResponse {
+    board: CardResponse[]
+    payouts: Record<string, number>
+}
+
+// Cards that would have come after the hand ended. Never part of the hand.
+export interface RabbitHuntResponse {
+    hand_number: number
+    board: CardResponse[]
+    rabbit_cards: CardResponse[]
 }
 
 export interface PlayerStateResponse {
@@ -34,6 +58,9 @@ export interface PlayerStateResponse {
   is_small_blind: boolean;
   is_big_blind: boolean;
   is_current_turn: boolean;
+  is_sitting_out: boolean;
+  owes_blinds: boolean;
+  identity?: string;
 }
 
 export interface PlayersResponse {
@@ -51,10 +78,254 @@ export interface ActionResponse {
   status: string;
   value?: number;
   player: string;
+  seat?: number;
+  type?: string;
+  request_id?: string;
+  replayed?: boolean;
+}
+
+export interface ConnectRequest {
+  addr: string;
+}
+
+export interface ConnectResponse {
+  success: boolean;
+  message: string;
+  peers: number;
 }
 
 export interface ActionRequest {
   value?: number;
+  request_id?: string;
+  hand_number?: number;
+  street?: string;
+  turn?: number;
+}
+
+// Pins an action to the spot it was decided in, and makes retrying it safe.
+export type ActionOptions = Omit<ActionRequest, "value">
+
+export interface SeatRequest {
+  seat: number;
+}
+
+export interface SeatResponse {
+  seat: number;
+  listen_addr?: string;
+  is_empty: boolean;
+  is_sitting_out: boolean;
+  stack: number;
+}
+
+export interface SeatsResponse {
+  seats: SeatResponse[];
+  max_seats: number;
+  waiting_list: string[];
+}
+
+export interface HandSummaryResponse {
+  id: number;
+  hand_number: number;
+  started_at: string;
+  pot: number;
+  board: CardResponse[];
+  collected: Record<string, number>;
+}
+
+export interface HandHistoryListResponse {
+  hands: HandSummaryResponse[];
+  total: number;
+  offset: number;
+  limit: number;
+}
+
+export interface StatsResponse {
+  player: string;
+  hands: number;
+  vpip: number;
+  pfr: number;
+  aggression_factor: number;
+  three_bet: number;
+  went_to_showdown: number;
+  won_at_showdown: number;
+  net_chips: number;
+  bb_per_100: number;
+}
+
+export type ErrorCode =
+  | "invalid_json"
+  | "invalid_request"
+  | "unauthorized"
+  | "forbidden"
+  | "not_found"
+  | "not_your_turn"
+  | "illegal_action"
+  | "seat_taken"
+  | "not_seated"
+  | "hand_in_progress"
+  | "amount_too_small"
+  | "amount_too_large"
+  | "invalid_seat"
+  | "not_allowed"
+  | "stale_action"
+  | "request_id_reused"
+  | "peer_unreachable"
+  | "peer_timeout"
+  | "internal";
+
+export interface APIErrorResponse {
+  error: string;
+  code: ErrorCode;
+  details?: {
+    amount: number;
+    min: number;
+    max: number;
+  };
+}
+
+export type TableEventType =
+  | "state"
+  | "player_joined"
+  | "player_left"
+  | "hand_started"
+  | "action_taken"
+  | "street_changed"
+  | "cards_revealed"
+  | "pot_awarded"
+  | "turn_changed"
+  | "hand_complete"
+  | "player_ready"
+  | "seat_changed"
+  | "buy_in"
+  | "status_changed"
+  | "control";
+
+export interface TableEvent<T = unknown> {
+  seq: number;
+  type: TableEventType;
+  time: string;
+  data: T;
+}
+
+export interface StateEvent {
+  table: TableStateResponse;
+  players: PlayersResponse;
+}
+
+export interface PlayerEvent {
+  player: string;
+  seat: number;
+}
+
+export interface HandStartedEvent {
+  hand_number: number;
+  dealer_id: number;
+}
+
+export interface ActionEvent {
+  player: string;
+  seat: number;
+  action: string;
+  bet: number;
+  stack: number;
+  pot: number;
+  all_in: boolean;
+}
+
+export interface StreetEvent {
+  street: GameStatus;
+}
+
+export interface CardsRevealedEvent {
+  kind: "hole" | "board" | "shown";
+  player?: string;
+  cards: CardResponse[];
+}
+
+export interface PotAwardedEvent {
+  player: string;
+  amount: number;
+  pot: string;
+}
+
+export interface TurnEvent {
+  player: string;
+  seat: number;
+}
+
+export interface HandCompleteEvent {
+  hand_number: number;
+}
+
+export interface SeatEvent {
+  player: string;
+  seat: number;
+  seated: boolean;
+}
+
+export interface BuyInEvent {
+  player: string;
+  kind: string;
+  amount: number;
+  stack: number;
+}
+
+export interface StatusEvent {
+  from: GameStatus;
+  status: GameStatus;
+}
+
+export type ControlKind = "pause" | "resume" | "kick" | "ban" | "unban" | "config" | "abort";
+
+// Fields left out keep their value.
+export interface TableConfigChange {
+  small_blind?: number;
+  big_blind?: number;
+  min_buy_in?: number;
+  max_buy_in?: number;
+  max_runs?: number;
+  allow_straddle?: boolean;
+  allow_button_straddle?: boolean;
+  allow_rabbit_hunt?: boolean;
+}
+
+export type TableConfigResponse = Required<TableConfigChange>;
+
+export interface ControlEvent {
+  id: string;
+  kind: ControlKind;
+  target?: string;
+  player?: string;
+  issued_by: string;
+  config?: TableConfigChange;
+  signers: string[];
+  needed: number;
+  applied: boolean;
+  pending?: boolean;
+  refunds?: Record<string, number>;
+}
+
+export interface ControlResponse {
+  id: string;
+  kind: ControlKind;
+  target?: string;
+  config?: TableConfigChange;
+  issued_by: string;
+  applied: boolean;
+  signers: string[];
+  needed: number;
+  expires_at: string;
+}
+
+export interface AdminResponse {
+  identity: string;
+  host?: string;
+  is_host: boolean;
+  paused: boolean;
+  banned: string[];
+  config: TableConfigResponse;
+  pending_config?: TableConfigChange;
+  proposals: ControlResponse[];
 }
 
 export type PlayerAction = 
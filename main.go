@@ -1,11 +1,16 @@
 package main
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/RedPaladin7/peerpoker/p2p"
 	"github.com/sirupsen/logrus"
@@ -18,11 +23,54 @@ const (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "export failed: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "openapi" {
+		if err := runOpenAPI(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "openapi failed: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeys(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "keys failed: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var (
 		p2pPort = flag.String("p2p-port", defaultP2PPort, "P2P network port")
 		apiPort = flag.String("api-port", defaultAPIPort, "HTTP API port")
+		grpcPort = flag.String("grpc-port", "", "gRPC API port (empty disables gRPC)")
 		connectTo = flag.String("connect", "", "Connect to existing peer (e.g., localhost: 3000)")
+		hostKey = flag.String("host-key", "", "Hex identity key of the table host to follow, as the host logs it at startup")
 		maxPlayers = flag.Int("max-players", 6, "Maximum number of players")
+		smallBlind = flag.Int("small-blind", p2p.SmallBlind, "Small blind")
+		bigBlind = flag.Int("big-blind", p2p.BigBlind, "Big blind")
+		startingStack = flag.Int("starting-stack", 1000, "Chips each player starts with")
+		minBuyIn = flag.Int("min-buyin", 400, "Minimum rebuy amount")
+		maxBuyIn = flag.Int("max-buyin", 2000, "Maximum stack after a rebuy or top-up")
+		addOnChips = flag.Int("addon-chips", 0, "Tournament add-on size (0 disables add-ons)")
+		addOnHands = flag.Int("addon-hands", 0, "Number of hands the add-on window stays open")
+		straddle = flag.Bool("straddle", false, "Allow live UTG straddles")
+		buttonStraddle = flag.Bool("button-straddle", false, "Allow Mississippi (button) straddles")
+		maxRuns = flag.Int("max-runs", 1, "Maximum times an all-in board can be run (1 disables run-it-twice)")
+		rabbitHunt = flag.Bool("rabbit-hunt", false, "Allow players to agree to see the undealt board after a hand")
+		dataDir = flag.String("data-dir", "", "Directory game data is stored in (default data-<p2p-port>)")
+		inMemory = flag.Bool("in-memory", false, "Keep all game data in memory instead of the data directory")
+		passphrase = flag.String("passphrase", "", "Passphrase that encrypts the keystore (default $PEERPOKER_PASSPHRASE)")
+		insecureKeystore = flag.Bool("insecure-keystore", false, "Allow running without a passphrase, keeping keys on disk in plaintext")
+		adminToken = flag.String("admin-token", "", "API token for table control commands (default $PEERPOKER_ADMIN_TOKEN, generated if unset)")
+		apiToken = flag.String("api-token", "", "API token that may act for this node (default $PEERPOKER_API_TOKEN, generated if unset)")
+		spectatorToken = flag.String("spectator-token", "", "Read-only API token for spectators (default $PEERPOKER_SPECTATOR_TOKEN, generated if unset)")
+		corsOrigins = flag.String("cors-origins", strings.Join(p2p.DefaultCORSOrigins, ","), "Comma-separated browser origins allowed to call the API (* allows any)")
 		logLevel = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
 		version = flag.Bool("version", false, "Print version and exit")
 	)
@@ -45,17 +93,99 @@ func main() {
 
 	p2pAddr := fmt.Sprintf("localhost:%s", *p2pPort)
 	apiAddr := fmt.Sprintf("localhost:%s", *apiPort)
+	grpcAddr := ""
+	if *grpcPort != "" {
+		grpcAddr = fmt.Sprintf("localhost:%s", *grpcPort)
+	}
+
+	if *dataDir == "" {
+		*dataDir = fmt.Sprintf("data-%s", *p2pPort)
+	}
+	if *inMemory {
+		*dataDir = ""
+	}
+
+	var pinnedHostKey []byte
+	if *hostKey != "" {
+		if pinnedHostKey, err = hex.DecodeString(*hostKey); err != nil {
+			logrus.Fatalf("Invalid host key: %s", err)
+		}
+	}
+
+	if *passphrase == "" {
+		*passphrase = os.Getenv("PEERPOKER_PASSPHRASE")
+	}
+	if *passphrase == "" && *dataDir != "" {
+		if !*insecureKeystore {
+			logrus.Fatal("No passphrase set, pass -passphrase or $PEERPOKER_PASSPHRASE (or -insecure-keystore to keep keys in plaintext)")
+		}
+		logrus.Warn("No passphrase set, the keystore will hold keys in plaintext")
+	}
+
+	if *adminToken == "" {
+		*adminToken = os.Getenv("PEERPOKER_ADMIN_TOKEN")
+	}
+	if *apiToken == "" {
+		*apiToken = os.Getenv("PEERPOKER_API_TOKEN")
+	}
+	if *spectatorToken == "" {
+		*spectatorToken = os.Getenv("PEERPOKER_SPECTATOR_TOKEN")
+	}
+	origins := []string{}
+	for _, origin := range strings.Split(*corsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
 
 	cfg := p2p.ServerConfig{
 		Version: defaultVersion,
 		ListenAddr: p2pAddr,
 		APIListenAddr: apiAddr,
+		GRPCListenAddr: grpcAddr,
 		MaxPlayers: *maxPlayers,
 		GameVariant: p2p.TexasHoldem,
+		DataDir: *dataDir,
+		Passphrase: *passphrase,
+		Host: *connectTo == "",
+		HostKey: pinnedHostKey,
+		Seed: *connectTo,
+		Auth: p2p.AuthConfig{
+			AdminToken: *adminToken,
+			PlayerToken: *apiToken,
+			SpectatorToken: *spectatorToken,
+			CORSOrigins: origins,
+		},
+		Table: p2p.TableConfig{
+			SmallBlind: *smallBlind,
+			BigBlind: *bigBlind,
+			StartingStack: *startingStack,
+			MinBuyIn: *minBuyIn,
+			MaxBuyIn: *maxBuyIn,
+			AddOnChips: *addOnChips,
+			AddOnHands: *addOnHands,
+			AllowStraddle: *straddle,
+			AllowButtonStraddle: *buttonStraddle,
+			MaxRuns: *maxRuns,
+			AllowRabbitHunt: *rabbitHunt,
+		},
 	}
 
 	server := p2p.NewServer(cfg)
 
+	// tokens given by flag or environment are already known to the operator,
+	// generated ones are printed once to stderr and kept out of the log
+	generated := []struct{ name, given, token string }{
+		{"Admin token:    ", *adminToken, server.Auth.AdminToken},
+		{"Player token:   ", *apiToken, server.Auth.PlayerToken},
+		{"Spectator token:", *spectatorToken, server.Auth.SpectatorToken},
+	}
+	for _, t := range generated {
+		if t.given == "" {
+			fmt.Fprintf(os.Stderr, "%s %s\n", t.name, t.token)
+		}
+	}
+
 	if *connectTo != "" {
 		logrus.Infof("Connecting to peer: %s", *connectTo)
 		go func() {
@@ -73,20 +203,57 @@ func main() {
 	logrus.Infof("Version:        %s", defaultVersion)
 	logrus.Infof("P2P Address:    %s", p2pAddr)
 	logrus.Infof("API Address:    http://%s", apiAddr)
+	if grpcAddr != "" {
+		logrus.Infof("gRPC Address:   %s (service %s, JSON codec)", grpcAddr, p2p.GRPCService)
+	}
 	logrus.Infof("Game Variant:   %s", cfg.GameVariant)
 	logrus.Infof("Max Players:    %d", *maxPlayers)
+	logrus.Infof("CORS Origins:   %s", strings.Join(server.Auth.CORSOrigins, ", "))
 	logrus.Info("===========================================")
 	logrus.Info("")
+	logrus.Info("Send the player token as 'Authorization: Bearer <token>', or as ?token=<token>")
+	logrus.Info("on WebSocket and event stream URLs. /api/health needs no token.")
+	logrus.Info("")
 	logrus.Info("API Endpoints:")
 	logrus.Infof("  Health:       GET  http://%s/api/health", apiAddr)
+	logrus.Infof("  OpenAPI:      GET  http://%s/api/openapi.json", apiAddr)
 	logrus.Infof("  Table State:  GET  http://%s/api/table", apiAddr)
 	logrus.Infof("  Players:      GET  http://%s/api/players", apiAddr)
+	logrus.Infof("  Seats:        GET  http://%s/api/seats", apiAddr)
+	logrus.Infof("  Take Seat:    POST http://%s/api/seat", apiAddr)
+	logrus.Infof("  Leave Seat:   DEL  http://%s/api/seat", apiAddr)
+	logrus.Infof("  Wait List:    POST http://%s/api/waitlist", apiAddr)
+	logrus.Infof("  Rebuy:        POST http://%s/api/rebuy", apiAddr)
+	logrus.Infof("  Top Up:       POST http://%s/api/topup", apiAddr)
+	logrus.Infof("  Add-On:       POST http://%s/api/addon", apiAddr)
+	logrus.Infof("  Ledger:       GET  http://%s/api/ledger", apiAddr)
+	logrus.Infof("  History:      GET  http://%s/api/history", apiAddr)
+	logrus.Infof("  Hand:         GET  http://%s/api/history/{id}", apiAddr)
+	logrus.Infof("  Replay:       GET  http://%s/api/replay/{id}?step=N", apiAddr)
+	logrus.Infof("  Stats:        GET  http://%s/api/stats/{player}", apiAddr)
+	logrus.Infof("  Events:       WS   ws://%s/api/ws", apiAddr)
+	logrus.Infof("  Events (SSE): GET  http://%s/api/events", apiAddr)
 	logrus.Infof("  Ready:        POST http://%s/api/ready", apiAddr)
 	logrus.Infof("  Fold:         POST http://%s/api/fold", apiAddr)
 	logrus.Infof("  Check:        POST http://%s/api/check", apiAddr)
 	logrus.Infof("  Call:         POST http://%s/api/call", apiAddr)
 	logrus.Infof("  Bet:          POST http://%s/api/bet", apiAddr)
 	logrus.Infof("  Raise:        POST http://%s/api/raise", apiAddr)
+	logrus.Infof("  Straddle:     POST http://%s/api/straddle", apiAddr)
+	logrus.Infof("  Run It:       POST http://%s/api/runit", apiAddr)
+	logrus.Infof("  Show:         POST http://%s/api/show", apiAddr)
+	logrus.Infof("  Muck:         POST http://%s/api/muck", apiAddr)
+	logrus.Infof("  Rabbit Hunt:  POST http://%s/api/rabbit", apiAddr)
+	logrus.Info("Admin Endpoints (admin token):")
+	logrus.Infof("  Control:      GET  http://%s/api/admin", apiAddr)
+	logrus.Infof("  Pause:        POST http://%s/api/admin/pause", apiAddr)
+	logrus.Infof("  Resume:       POST http://%s/api/admin/resume", apiAddr)
+	logrus.Infof("  Abort Hand:   POST http://%s/api/admin/abort", apiAddr)
+	logrus.Infof("  Kick:         POST http://%s/api/admin/kick", apiAddr)
+	logrus.Infof("  Ban:          POST http://%s/api/admin/ban", apiAddr)
+	logrus.Infof("  Unban:        POST http://%s/api/admin/unban", apiAddr)
+	logrus.Infof("  Config:       PUT  http://%s/api/admin/config", apiAddr)
+	logrus.Infof("  Approve:      POST http://%s/api/admin/approve", apiAddr)
 	logrus.Info("===========================================")
 	logrus.Info("")
 
@@ -107,10 +274,251 @@ func main() {
 	logrus.Info("")
 	logrus.Info("🛑 Shutdown signal received. Cleaning up...")
 	
+	if err := server.Close(); err != nil {
+		logrus.Errorf("Failed to close storage: %s", err)
+	}
 	// TODO: Add graceful shutdown logic here
-	// - Save game state
 	// - Notify peers
 	// - Close connections
 
 	logrus.Info("✅ Server stopped successfully")
-}
\ No newline at end of file
+}
+
+// runOpenAPI prints the OpenAPI document of the HTTP API, or checks that a
+// committed copy still matches the handlers, e.g.
+//
+//	peerpoker openapi > frontend/openapi.json
+//	peerpoker openapi -check frontend/openapi.json
+func runOpenAPI(args []string) error {
+	fs := flag.NewFlagSet("openapi", flag.ExitOnError)
+	check := fs.String("check", "", "Committed document to check against the API instead of printing it")
+	fs.Parse(args)
+
+	if *check != "" {
+		committed, err := os.ReadFile(*check)
+		if err != nil {
+			return err
+		}
+		return p2p.CheckOpenAPI(committed)
+	}
+	spec, err := p2p.MarshalOpenAPISpec()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(spec))
+	return err
+}
+
+// runExport dumps the hands of a session from a node's data directory, e.g.
+//
+//	peerpoker export -p2p-port=3000 -format=pokerstars > session.txt
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	var (
+		p2pPort = fs.String("p2p-port", defaultP2PPort, "P2P port of the node whose history to export")
+		dataDir = fs.String("data-dir", "", "Data directory of the node (default data-<p2p-port>)")
+		session = fs.String("session", "", "Session to export, \"all\" for every session (default the latest)")
+		format = fs.String("format", "pokerstars", "Output format (pokerstars, ohh, json)")
+		out = fs.String("out", "", "File to write to (default stdout)")
+	)
+	fs.Parse(args)
+
+	if *dataDir == "" {
+		*dataDir = fmt.Sprintf("data-%s", *p2pPort)
+	}
+	storage, err := p2p.OpenDiskStorageReadOnly(*dataDir)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+	store, err := p2p.NewHandHistoryStore(storage)
+	if err != nil {
+		return err
+	}
+	hands := store.All()
+	if *session != "all" {
+		hands = store.Session(*session)
+	}
+	if len(hands) == 0 {
+		return fmt.Errorf("no hands found in %s", *dataDir)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch *format {
+	case "pokerstars":
+		return p2p.ExportPokerStars(w, hands)
+	case "ohh":
+		enc := json.NewEncoder(w)
+		for _, hand := range hands {
+			if err := enc.Encode(p2p.ToOpenHandHistory(hand)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(hands)
+	default:
+		return fmt.Errorf("unknown format %q, want pokerstars, ohh or json", *format)
+	}
+}
+
+// runKeys manages the node identity in a node's keystore. The node must not
+// be running while its keys are changed, e.g.
+//
+//	peerpoker keys rotate -p2p-port=3000
+//	peerpoker keys export -p2p-port=3000 -out identity.json
+func runKeys(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: peerpoker keys <create|import|export|rotate|passwd|list> [flags]")
+	}
+	command := args[0]
+	fs := flag.NewFlagSet("keys "+command, flag.ExitOnError)
+	var (
+		p2pPort = fs.String("p2p-port", defaultP2PPort, "P2P port of the node whose keys to manage")
+		dataDir = fs.String("data-dir", "", "Data directory of the node (default data-<p2p-port>)")
+		passphrase = fs.String("passphrase", "", "Keystore passphrase (default $PEERPOKER_PASSPHRASE)")
+		newPassphrase = fs.String("new-passphrase", "", "New keystore passphrase for passwd (default $PEERPOKER_NEW_PASSPHRASE)")
+		exportPassphrase = fs.String("export-passphrase", "", "Passphrase sealing an exported identity (default the keystore passphrase)")
+		in = fs.String("in", "", "Exported identity to import (default stdin)")
+		out = fs.String("out", "", "File to export the identity to (default stdout)")
+		force = fs.Bool("force", false, "Let create replace an existing identity")
+		insecureKeystore = fs.Bool("insecure-keystore", false, "Allow writing keys to the keystore in plaintext")
+	)
+	fs.Parse(args[1:])
+
+	if *dataDir == "" {
+		*dataDir = fmt.Sprintf("data-%s", *p2pPort)
+	}
+	if *passphrase == "" {
+		*passphrase = os.Getenv("PEERPOKER_PASSPHRASE")
+	}
+	if *newPassphrase == "" {
+		*newPassphrase = os.Getenv("PEERPOKER_NEW_PASSPHRASE")
+	}
+	if *exportPassphrase == "" {
+		*exportPassphrase = *passphrase
+	}
+	plaintext := *passphrase == ""
+	if command == "passwd" {
+		plaintext = *newPassphrase == ""
+	}
+	switch command {
+	case "create", "rotate", "import", "passwd":
+		if plaintext && !*insecureKeystore {
+			return fmt.Errorf("keys %s would store keys in plaintext, set a passphrase or pass -insecure-keystore", command)
+		}
+	}
+	storage, err := p2p.OpenDiskStorage(*dataDir)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+	keys := p2p.NewKeystore(storage, *passphrase)
+
+	switch command {
+	case "create":
+		existing, err := p2p.LoadIdentity(keys)
+		if err == nil && !*force {
+			return fmt.Errorf("node already has identity %s, use -force to replace it", existing.ID)
+		}
+		if err != nil && err != p2p.ErrNotFound {
+			return err
+		}
+		identity, err := p2p.RotateIdentity(keys)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created identity %s\n", identity.ID)
+	case "rotate":
+		old, err := p2p.LoadIdentity(keys)
+		if err != nil {
+			return err
+		}
+		identity, err := p2p.RotateIdentity(keys)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rotated identity %s to %s\n", old.ID, identity.ID)
+	case "export":
+		identity, err := p2p.LoadIdentity(keys)
+		if err != nil {
+			return err
+		}
+		exported, err := identity.Export(*exportPassphrase)
+		if err != nil {
+			return err
+		}
+		var w io.Writer = os.Stdout
+		if *out != "" {
+			f, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exported)
+	case "import":
+		var r io.Reader = os.Stdin
+		if *in != "" {
+			f, err := os.Open(*in)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		exported := &p2p.ExportedIdentity{}
+		if err := json.NewDecoder(r).Decode(exported); err != nil {
+			return err
+		}
+		identity, err := exported.Open(*exportPassphrase)
+		if err != nil {
+			return err
+		}
+		if err := p2p.SaveIdentity(keys, identity); err != nil {
+			return err
+		}
+		fmt.Printf("Imported identity %s\n", identity.ID)
+	case "passwd":
+		if err := keys.ChangePassphrase(*newPassphrase); err != nil {
+			return err
+		}
+		if *newPassphrase == "" {
+			fmt.Println("Keystore passphrase removed, keys are now stored in plaintext")
+		} else {
+			fmt.Println("Keystore passphrase changed")
+		}
+	case "list":
+		identity, err := p2p.LoadIdentity(keys)
+		if err != nil && err != p2p.ErrNotFound {
+			return err
+		}
+		if identity != nil {
+			fmt.Printf("Identity: %s\n", identity.ID)
+		}
+		infos, err := keys.List()
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			fmt.Printf("%-20s %-16s %s encrypted=%t\n", info.ID, info.Kind, info.CreatedAt.Format(time.RFC3339), info.Encrypted)
+		}
+	default:
+		return fmt.Errorf("unknown keys command %q", command)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package p2p
+
+import "strings"
+
+// rememberedActions is how many request IDs the game keeps the result of.
+// Retries come within seconds, so this spans many hands of them.
+const rememberedActions = 128
+
+// ActionOptions are the optional parts of an action request.
+type ActionOptions struct {
+	// RequestID makes retrying an action safe. An action with the ID of one
+	// already taken is not taken again, the first result is returned.
+	RequestID 	string
+	// HandNumber, Street and Turn are where the player decided on the action.
+	// If the table has moved on since, the action is rejected as stale. Zero
+	// values are not checked.
+	HandNumber 	int
+	Street 		string
+	Turn 		int
+}
+
+// ActionResult is an action the game took.
+type ActionResult struct {
+	Action 		PlayerAction
+	Value 		int
+	HandNumber 	int
+	Street 		GameStatus
+	Turn 		int
+	// Replayed is set when the action was taken by an earlier request with
+	// the same ID
+	Replayed 	bool
+}
+
+// actionLog remembers the results of actions sent with a request ID. Only
+// actions that were taken are remembered: one that failed changed nothing
+// and may be tried again.
+type actionLog struct {
+	results map[string]ActionResult
+	order 	[]string
+}
+
+func newActionLog() *actionLog {
+	return &actionLog{results: make(map[string]ActionResult)}
+}
+
+func (l *actionLog) get(id string) (ActionResult, bool) {
+	result, ok := l.results[id]
+	return result, ok
+}
+
+func (l *actionLog) add(id string, result ActionResult) {
+	if len(l.order) == rememberedActions {
+		delete(l.results, l.order[0])
+		l.order = l.order[1:]
+	}
+	l.results[id] = result
+	l.order = append(l.order, id)
+}
+
+// repeatedAction returns the result of the action taken with the request's ID
+// before, if there was one. The caller must hold the game lock.
+func (g *Game) repeatedAction(action PlayerAction, value int, opts ActionOptions) (ActionResult, bool, error) {
+	if opts.RequestID == "" {
+		return ActionResult{}, false, nil
+	}
+	result, ok := g.actionLog.get(opts.RequestID)
+	if !ok {
+		return ActionResult{}, false, nil
+	}
+	if result.Action != action || result.Value != value {
+		return ActionResult{}, false, newRuleError(ErrRequestIDReused,
+			"request %s already did %s %d", opts.RequestID, result.Action, result.Value)
+	}
+	result.Replayed = true
+	return result, true, nil
+}
+
+// checkExpected rejects an action meant for an earlier point of the game.
+// The caller must hold the game lock.
+func (g *Game) checkExpected(opts ActionOptions) error {
+	if opts.HandNumber != 0 && opts.HandNumber != g.handNumber {
+		return newRuleError(ErrStaleAction, "action was meant for hand %d, this is hand %d", opts.HandNumber, g.handNumber)
+	}
+	street := GameStatus(g.currentStatus.Get())
+	if opts.Street != "" && !strings.EqualFold(opts.Street, street.String()) {
+		return newRuleError(ErrStaleAction, "action was meant for the %s, the hand is at the %s", strings.ToUpper(opts.Street), street)
+	}
+	if opts.Turn != 0 && opts.Turn != g.turnNumber {
+		return newRuleError(ErrStaleAction, "action was meant for turn %d, this is turn %d", opts.Turn, g.turnNumber)
+	}
+	return nil
+}
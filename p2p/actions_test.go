@@ -0,0 +1,109 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestActionLogForgetsOldest(t *testing.T) {
+	l := newActionLog()
+	for i := 0; i <= rememberedActions; i++ {
+		l.add(fmt.Sprintf("req-%d", i), ActionResult{Action: PlayerActionCheck, Turn: i})
+	}
+	if _, ok := l.get("req-0"); ok {
+		t.Fatal("oldest request is still remembered")
+	}
+	for _, i := range []int{1, rememberedActions} {
+		if result, ok := l.get(fmt.Sprintf("req-%d", i)); !ok || result.Turn != i {
+			t.Fatalf("request %d: got %+v, %v", i, result, ok)
+		}
+	}
+}
+
+func TestRepeatedAction(t *testing.T) {
+	tests := []struct {
+		name 		string
+		requestID 	string
+		action 		PlayerAction
+		value 		int
+		wantReplay 	bool
+		wantErr 	error
+	}{
+		{name: "no request id", action: PlayerActionBet, value: 40},
+		{name: "new request id", requestID: "other", action: PlayerActionBet, value: 40},
+		{name: "same action again", requestID: "taken", action: PlayerActionBet, value: 40, wantReplay: true},
+		{name: "id reused for another amount", requestID: "taken", action: PlayerActionBet, value: 60, wantErr: ErrRequestIDReused},
+		{name: "id reused for another action", requestID: "taken", action: PlayerActionFold, value: 40, wantErr: ErrRequestIDReused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGame(":3000", TableConfig{}, drainBroadcasts())
+			g.actionLog.add("taken", ActionResult{Action: PlayerActionBet, Value: 40, HandNumber: 2})
+
+			result, replayed, err := g.repeatedAction(tt.action, tt.value, ActionOptions{RequestID: tt.requestID})
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if replayed != tt.wantReplay || result.Replayed != tt.wantReplay {
+				t.Fatalf("replayed = %v, result %+v, want %v", replayed, result, tt.wantReplay)
+			}
+			if replayed && result.HandNumber != 2 {
+				t.Fatalf("replay returned %+v instead of the first result", result)
+			}
+		})
+	}
+}
+
+func TestCheckExpected(t *testing.T) {
+	tests := []struct {
+		name 	string
+		opts 	ActionOptions
+		wantErr error
+	}{
+		{name: "nothing expected", opts: ActionOptions{}},
+		{name: "current point", opts: ActionOptions{HandNumber: 4, Street: "FLOP", Turn: 7}},
+		{name: "street in any case", opts: ActionOptions{Street: "flop"}},
+		{name: "earlier hand", opts: ActionOptions{HandNumber: 3}, wantErr: ErrStaleAction},
+		{name: "earlier street", opts: ActionOptions{Street: "PREFLOP"}, wantErr: ErrStaleAction},
+		{name: "earlier turn", opts: ActionOptions{Turn: 6}, wantErr: ErrStaleAction},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGame(":3000", TableConfig{}, drainBroadcasts())
+			g.handNumber = 4
+			g.turnNumber = 7
+			g.setStatus(GameStatusFlop)
+
+			if err := g.checkExpected(tt.opts); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTakeActionRetry(t *testing.T) {
+	g := newSeatedTable(t, 3, 0, 1, 2)
+	g.setStatus(GameStatusPreFlop)
+	g.highestBet = BigBlind
+	g.currentPlayerTurnID = 0
+	opts := ActionOptions{RequestID: "call-1"}
+
+	first, err := g.TakeAction(PlayerActionCall, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stack, pot := g.playerStates[g.listenAddr].Stack, g.currentPot
+
+	again, err := g.TakeAction(PlayerActionCall, 0, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Replayed || !again.Replayed {
+		t.Fatalf("first replayed = %v, retry replayed = %v", first.Replayed, again.Replayed)
+	}
+	if g.playerStates[g.listenAddr].Stack != stack || g.currentPot != pot {
+		t.Fatalf("retry acted again: stack %d, pot %d, want %d, %d", g.playerStates[g.listenAddr].Stack, g.currentPot, stack, pot)
+	}
+}
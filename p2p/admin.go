@@ -0,0 +1,692 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// controlTTL is how long a control command may wait for approvals. Older
+// commands are dropped and rejected when they arrive.
+const controlTTL = 2 * time.Minute
+
+// controlDomain is prefixed to a command before it is signed, so a control
+// signature cannot be passed off as a signature over anything else.
+const controlDomain = "peerpoker-control:"
+
+type ControlKind string
+
+const (
+	// ControlPause stops new hands from starting, the hand being played is
+	// finished first
+	ControlPause 	ControlKind = "pause"
+	ControlResume 	ControlKind = "resume"
+	ControlKick 	ControlKind = "kick"
+	ControlBan 		ControlKind = "ban"
+	ControlUnban 	ControlKind = "unban"
+	// ControlConfig changes the table config, between hands
+	ControlConfig 	ControlKind = "config"
+	// ControlAbort calls off the hand being played, refunds every bet in it
+	// and pauses the table
+	ControlAbort 	ControlKind = "abort"
+)
+
+// ControlCommand is a change to the table that no single player may make on
+// their own. It takes effect once it is signed by the host, or by a majority
+// of the players at the table.
+type ControlCommand struct {
+	ID 			string 				`json:"id"`
+	Kind 		ControlKind 		`json:"kind"`
+	// Target is the identity ID kicked, banned or unbanned
+	Target 		string 				`json:"target,omitempty"`
+	Config 		*TableConfigChange 	`json:"config,omitempty"`
+	// HandNumber is the hand an abort is for
+	HandNumber 	int 				`json:"hand_number,omitempty"`
+	IssuedBy 	string 				`json:"issued_by"`
+	IssuedAt 	int64 				`json:"issued_at"`
+}
+
+// ControlSignature is a player's approval of a control command.
+type ControlSignature struct {
+	Signer 	string
+	Sig 	[]byte
+}
+
+// TableConfigChange is the part of the table config a control command can
+// change. Fields left nil keep their value.
+type TableConfigChange struct {
+	SmallBlind 			*int 	`json:"small_blind,omitempty"`
+	BigBlind 			*int 	`json:"big_blind,omitempty"`
+	MinBuyIn 			*int 	`json:"min_buy_in,omitempty"`
+	MaxBuyIn 			*int 	`json:"max_buy_in,omitempty"`
+	MaxRuns 			*int 	`json:"max_runs,omitempty"`
+	AllowStraddle 		*bool 	`json:"allow_straddle,omitempty"`
+	AllowButtonStraddle *bool 	`json:"allow_button_straddle,omitempty"`
+	AllowRabbitHunt 	*bool 	`json:"allow_rabbit_hunt,omitempty"`
+}
+
+func (c *TableConfigChange) applyTo(cfg TableConfig) TableConfig {
+	if c.SmallBlind != nil {
+		cfg.SmallBlind = *c.SmallBlind
+	}
+	if c.BigBlind != nil {
+		cfg.BigBlind = *c.BigBlind
+	}
+	if c.MinBuyIn != nil {
+		cfg.MinBuyIn = *c.MinBuyIn
+	}
+	if c.MaxBuyIn != nil {
+		cfg.MaxBuyIn = *c.MaxBuyIn
+	}
+	if c.MaxRuns != nil {
+		cfg.MaxRuns = *c.MaxRuns
+	}
+	if c.AllowStraddle != nil {
+		cfg.AllowStraddle = *c.AllowStraddle
+	}
+	if c.AllowButtonStraddle != nil {
+		cfg.AllowButtonStraddle = *c.AllowButtonStraddle
+	}
+	if c.AllowRabbitHunt != nil {
+		cfg.AllowRabbitHunt = *c.AllowRabbitHunt
+	}
+	return cfg
+}
+
+// merge returns the change with the fields next sets on top of c.
+func (c *TableConfigChange) merge(next *TableConfigChange) *TableConfigChange {
+	merged := &TableConfigChange{}
+	if c != nil {
+		*merged = *c
+	}
+	if next.SmallBlind != nil {
+		merged.SmallBlind = next.SmallBlind
+	}
+	if next.BigBlind != nil {
+		merged.BigBlind = next.BigBlind
+	}
+	if next.MinBuyIn != nil {
+		merged.MinBuyIn = next.MinBuyIn
+	}
+	if next.MaxBuyIn != nil {
+		merged.MaxBuyIn = next.MaxBuyIn
+	}
+	if next.MaxRuns != nil {
+		merged.MaxRuns = next.MaxRuns
+	}
+	if next.AllowStraddle != nil {
+		merged.AllowStraddle = next.AllowStraddle
+	}
+	if next.AllowButtonStraddle != nil {
+		merged.AllowButtonStraddle = next.AllowButtonStraddle
+	}
+	if next.AllowRabbitHunt != nil {
+		merged.AllowRabbitHunt = next.AllowRabbitHunt
+	}
+	return merged
+}
+
+func validateTableConfig(cfg TableConfig) error {
+	if cfg.SmallBlind <= 0 {
+		return newAmountError(cfg.SmallBlind, 1, cfg.BigBlind, "the small blind must be at least 1")
+	}
+	if cfg.BigBlind < cfg.SmallBlind {
+		return newAmountError(cfg.BigBlind, cfg.SmallBlind, cfg.MaxBuyIn, "the big blind must be at least the small blind of %d", cfg.SmallBlind)
+	}
+	if cfg.MinBuyIn < cfg.BigBlind {
+		return newAmountError(cfg.MinBuyIn, cfg.BigBlind, cfg.MaxBuyIn, "the minimum buy-in must be at least the big blind of %d", cfg.BigBlind)
+	}
+	if cfg.MaxBuyIn < cfg.MinBuyIn {
+		return newAmountError(cfg.MaxBuyIn, cfg.MinBuyIn, cfg.MaxBuyIn, "the maximum buy-in must be at least the minimum of %d", cfg.MinBuyIn)
+	}
+	// a full table all-in before the flop is the most a deck has to deal
+	if limit := maxRunsFor(cfg.MaxSeats, 5); cfg.MaxRuns < 0 || cfg.MaxRuns > limit {
+		return newAmountError(cfg.MaxRuns, 0, limit, "max runs must be between 0 and %d for %d seats", limit, cfg.MaxSeats)
+	}
+	return nil
+}
+
+// ControlResult is where a control command stands: applied, or waiting for
+// more players to approve it. Needed is how many players have to sign it
+// unless the host does.
+type ControlResult struct {
+	Command 	ControlCommand
+	Applied 	bool
+	Signers 	[]string
+	Needed 		int
+}
+
+// controlProposal is a command waiting for approvals, with the signatures
+// collected so far by signer.
+type controlProposal struct {
+	command 	ControlCommand
+	payload 	[]byte
+	signatures 	map[string][]byte
+}
+
+func (p *controlProposal) message() MessageControl {
+	msg := MessageControl{Command: p.payload}
+	for signer, sig := range p.signatures {
+		msg.Signatures = append(msg.Signatures, ControlSignature{Signer: signer, Sig: sig})
+	}
+	sort.Slice(msg.Signatures, func(i, j int) bool {
+		return msg.Signatures[i].Signer < msg.Signatures[j].Signer
+	})
+	return msg
+}
+
+func controlSigningBytes(payload []byte) []byte {
+	return append([]byte(controlDomain), payload...)
+}
+
+func newControlID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// SetIdentity gives the game the node's identity to sign control commands
+// with. The host also becomes the table's authority, unless a recovered
+// table already has one.
+func (g *Game) SetIdentity(identity *NodeIdentity, host bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.identity = identity
+	g.playerKeys[g.listenAddr] = identity.PublicKey
+	if host && g.hostKey == nil {
+		g.hostKey = identity.PublicKey
+	}
+}
+
+// PinHostKey makes key the table's host, whatever a recovered table or our
+// peers say it is.
+func (g *Game) PinHostKey(key ed25519.PublicKey) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.hostKey != nil && !g.hostKey.Equal(key) {
+		logrus.Warnf("Table host %s is replaced by pinned host %s", identityID(g.hostKey), identityID(key))
+	}
+	g.hostKey = key
+	g.hostPinned = true
+}
+
+// SetPlayerIdentity records the identity key a peer proved it holds in the
+// handshake. An address the identity joined from before is forgotten.
+func (g *Game) SetPlayerIdentity(addr string, key ed25519.PublicKey) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for other, known := range g.playerKeys {
+		if other != addr && known.Equal(key) {
+			delete(g.playerKeys, other)
+		}
+	}
+	g.playerKeys[addr] = key
+}
+
+// FollowHost takes the host key the peer we joined the table through told
+// us about, unless the host is pinned or already known.
+func (g *Game) FollowHost(hostKey ed25519.PublicKey) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.hostPinned || g.hostKey != nil || len(hostKey) != ed25519.PublicKeySize {
+		return
+	}
+	g.hostKey = hostKey
+	logrus.Infof("Table host is %s", identityID(hostKey))
+}
+
+// SetPeerRemover sets how the game disconnects a player it kicks or bans.
+// It is called without the game lock held, since a dropped connection ends
+// up removing the player from the game.
+func (g *Game) SetPeerRemover(remove func(addr string)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.removePeer = remove
+}
+
+func (g *Game) HostKey() ed25519.PublicKey {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.hostKey
+}
+
+func (g *Game) IsBanned(id string) bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.banned[id]
+}
+
+// identityOf returns the identity ID of a player, or "" if they have not
+// handshaken with us. The caller must hold the game lock.
+func (g *Game) identityOf(addr string) string {
+	key, ok := g.playerKeys[addr]
+	if !ok {
+		return ""
+	}
+	return identityID(key)
+}
+
+// AddrOf returns the address the player with an identity joined from.
+func (g *Game) AddrOf(id string) (string, bool) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.addrOf(id)
+}
+
+// addrOf returns the address of the player with an identity. The caller must
+// hold the game lock.
+func (g *Game) addrOf(id string) (string, bool) {
+	for addr, key := range g.playerKeys {
+		if identityID(key) == id {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+// keyOf returns the public key of an identity at the table. The caller must
+// hold the game lock.
+func (g *Game) keyOf(id string) (ed25519.PublicKey, bool) {
+	if g.hostKey != nil && identityID(g.hostKey) == id {
+		return g.hostKey, true
+	}
+	if addr, ok := g.addrOf(id); ok {
+		return g.playerKeys[addr], true
+	}
+	return nil, false
+}
+
+// IssueControl signs a control command with our identity and sends it to the
+// table. It is applied straight away if we are the host, otherwise once
+// enough players approve it.
+func (g *Game) IssueControl(cmd ControlCommand) (ControlResult, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.identity == nil {
+		return ControlResult{}, fmt.Errorf("node has no identity to sign control commands with")
+	}
+	g.pruneControls()
+	cmd.ID = newControlID()
+	cmd.IssuedBy = g.identity.ID
+	cmd.IssuedAt = time.Now().Unix()
+	if cmd.Kind == ControlAbort {
+		if !g.isHandInProgress() {
+			return ControlResult{}, newRuleError(ErrIllegalAction, "there is no hand to abort")
+		}
+		cmd.HandNumber = g.handNumber
+	}
+	if (cmd.Kind == ControlKick || cmd.Kind == ControlBan) && cmd.Target == g.identity.ID {
+		return ControlResult{}, newRuleError(ErrInvalidRequest, "a node cannot %s itself", cmd.Kind)
+	}
+	if err := g.validateControl(cmd); err != nil {
+		return ControlResult{}, err
+	}
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return ControlResult{}, err
+	}
+	proposal := &controlProposal{
+		command: 	cmd,
+		payload: 	payload,
+		signatures: map[string][]byte{g.identity.ID: g.identity.Sign(controlSigningBytes(payload))},
+	}
+	g.proposals[cmd.ID] = proposal
+	logrus.Infof("Issuing control command %s: %s %s", cmd.ID, cmd.Kind, cmd.Target)
+	g.sendToPlayers(proposal.message(), g.getOtherPlayers()...)
+	return g.settleControl(proposal), nil
+}
+
+// ApproveControl adds our signature to a command waiting for approvals and
+// passes on every signature we have for it.
+func (g *Game) ApproveControl(id string) (ControlResult, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.identity == nil {
+		return ControlResult{}, fmt.Errorf("node has no identity to sign control commands with")
+	}
+	g.pruneControls()
+	proposal, ok := g.proposals[id]
+	if !ok {
+		return ControlResult{}, newRuleError(ErrNotFound, "no control command %s is waiting for approval", id)
+	}
+	if err := g.validateControl(proposal.command); err != nil {
+		return ControlResult{}, err
+	}
+	proposal.signatures[g.identity.ID] = g.identity.Sign(controlSigningBytes(proposal.payload))
+	g.sendToPlayers(proposal.message(), g.getOtherPlayers()...)
+	return g.settleControl(proposal), nil
+}
+
+// pendingControls are the commands waiting for approvals, oldest first. The
+// caller must hold the game lock.
+func (g *Game) pendingControls() []ControlResult {
+	g.pruneControls()
+	pending := []ControlResult{}
+	for _, proposal := range g.proposals {
+		signers, needed, _ := g.authority(proposal)
+		pending = append(pending, ControlResult{Command: proposal.command, Signers: signers, Needed: needed})
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Command.IssuedAt < pending[j].Command.IssuedAt
+	})
+	return pending
+}
+
+// HandleControl takes in a control command from a peer, with whatever
+// signatures it has collected, and applies it once it carries enough of
+// them.
+func (g *Game) HandleControl(from string, msg MessageControl) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.pruneControls()
+	cmd := ControlCommand{}
+	if err := json.Unmarshal(msg.Command, &cmd); err != nil {
+		return fmt.Errorf("malformed control command from %s: %s", from, err)
+	}
+	if _, ok := g.appliedControls[cmd.ID]; ok {
+		return nil
+	}
+	if age := time.Since(time.Unix(cmd.IssuedAt, 0)); age > controlTTL || age < -controlTTL {
+		return fmt.Errorf("rejected control command %s from %s: issued %s ago", cmd.ID, from, age.Round(time.Second))
+	}
+	if err := g.validateControl(cmd); err != nil {
+		return fmt.Errorf("rejected control command %s from %s: %s", cmd.ID, from, err)
+	}
+
+	proposal, ok := g.proposals[cmd.ID]
+	if !ok {
+		proposal = &controlProposal{command: cmd, payload: msg.Command, signatures: make(map[string][]byte)}
+	} else if !bytes.Equal(proposal.payload, msg.Command) {
+		return fmt.Errorf("rejected control command %s from %s: it differs from the one we have", cmd.ID, from)
+	}
+	added := 0
+	for _, sig := range msg.Signatures {
+		if _, ok := proposal.signatures[sig.Signer]; ok {
+			continue
+		}
+		key, ok := g.keyOf(sig.Signer)
+		if !ok || !ed25519.Verify(key, controlSigningBytes(msg.Command), sig.Sig) {
+			logrus.Warnf("Dropping bad signature by %s on control command %s from %s", sig.Signer, cmd.ID, from)
+			continue
+		}
+		proposal.signatures[sig.Signer] = sig.Sig
+		added++
+	}
+	if len(proposal.signatures) == 0 {
+		return fmt.Errorf("rejected control command %s from %s: no valid signatures", cmd.ID, from)
+	}
+	if !ok {
+		g.proposals[cmd.ID] = proposal
+		logrus.Infof("Control command %s from %s: %s %s", cmd.ID, cmd.IssuedBy, cmd.Kind, cmd.Target)
+	} else if added == 0 {
+		return nil
+	}
+	g.settleControl(proposal)
+	return nil
+}
+
+// validateControl checks a command makes sense for the table as it is. The
+// caller must hold the game lock.
+func (g *Game) validateControl(cmd ControlCommand) error {
+	switch cmd.Kind {
+	case ControlPause, ControlResume:
+	case ControlKick:
+		if _, ok := g.addrOf(cmd.Target); !ok {
+			return newRuleError(ErrUnknownPlayer, "no player with identity %s", cmd.Target)
+		}
+	case ControlBan, ControlUnban:
+		if cmd.Target == "" {
+			return newRuleError(ErrInvalidRequest, "%s needs the identity of a player", cmd.Kind)
+		}
+	case ControlConfig:
+		if cmd.Config == nil {
+			return newRuleError(ErrInvalidRequest, "config change has nothing to change")
+		}
+		if err := validateTableConfig(g.pendingConfig.merge(cmd.Config).applyTo(g.config)); err != nil {
+			return err
+		}
+	case ControlAbort:
+		if cmd.HandNumber <= 0 {
+			return newRuleError(ErrInvalidRequest, "abort needs the number of the hand")
+		}
+	default:
+		return newRuleError(ErrInvalidRequest, "unknown control command %q", cmd.Kind)
+	}
+	return nil
+}
+
+// authority returns who validly signed a proposal and how many signatures of
+// players still at the table it needs. The host's signature is enough on its
+// own; the player a kick or ban is aimed at does not get a say. The caller
+// must hold the game lock.
+func (g *Game) authority(proposal *controlProposal) ([]string, int, bool) {
+	signers := make([]string, 0, len(proposal.signatures))
+	for signer := range proposal.signatures {
+		signers = append(signers, signer)
+	}
+	sort.Strings(signers)
+
+	voters := make(map[string]bool)
+	for addr, state := range g.playerStates {
+		id := g.identityOf(addr)
+		if !state.IsActive || id == "" {
+			continue
+		}
+		if (proposal.command.Kind == ControlKick || proposal.command.Kind == ControlBan) && id == proposal.command.Target {
+			continue
+		}
+		voters[id] = true
+	}
+	needed := len(voters)/2 + 1
+	if g.hostKey != nil {
+		if _, ok := proposal.signatures[identityID(g.hostKey)]; ok {
+			return signers, needed, true
+		}
+	}
+	votes := 0
+	for _, signer := range signers {
+		if voters[signer] {
+			votes++
+		}
+	}
+	return signers, needed, votes >= needed
+}
+
+// settleControl applies a proposal if it has the authority to, and tells
+// API clients where it stands. The caller must hold the game lock.
+func (g *Game) settleControl(proposal *controlProposal) ControlResult {
+	signers, needed, ok := g.authority(proposal)
+	result := ControlResult{Command: proposal.command, Signers: signers, Needed: needed}
+	event := ControlEvent{
+		ID: 		proposal.command.ID,
+		Kind: 		proposal.command.Kind,
+		Target: 	proposal.command.Target,
+		IssuedBy: 	proposal.command.IssuedBy,
+		Config: 	proposal.command.Config,
+		Signers: 	signers,
+		Needed: 	needed,
+	}
+	if proposal.command.Target != "" {
+		event.Player, _ = g.addrOf(proposal.command.Target)
+	}
+	if ok {
+		g.applyControl(proposal.command, &event)
+		result.Applied = true
+	}
+	g.emit(TableEventControl, event)
+	return result
+}
+
+// applyControl carries out an authorised command. The caller must hold the
+// game lock.
+func (g *Game) applyControl(cmd ControlCommand, event *ControlEvent) {
+	delete(g.proposals, cmd.ID)
+	g.appliedControls[cmd.ID] = cmd.IssuedAt
+	event.Applied = true
+	logrus.Infof("Applying control command %s: %s %s", cmd.ID, cmd.Kind, cmd.Target)
+
+	switch cmd.Kind {
+	case ControlPause:
+		g.paused = true
+	case ControlResume:
+		g.paused = false
+		if !g.isHandInProgress() && len(g.getReadyPlayers()) >= 2 {
+			go g.StartNewHand()
+		}
+	case ControlKick:
+		g.removeFromTable(event.Player)
+	case ControlBan:
+		g.banned[cmd.Target] = true
+		g.removeFromTable(event.Player)
+	case ControlUnban:
+		delete(g.banned, cmd.Target)
+	case ControlConfig:
+		if g.isHandInProgress() {
+			g.pendingConfig = g.pendingConfig.merge(cmd.Config)
+			event.Pending = true
+			break
+		}
+		g.config = cmd.Config.applyTo(g.config)
+	case ControlAbort:
+		if cmd.HandNumber != g.handNumber || !g.isHandInProgress() {
+			logrus.Warnf("Control command %s aborts hand #%d, which is not being played", cmd.ID, cmd.HandNumber)
+			break
+		}
+		event.Refunds = g.abortHand()
+	}
+	g.logEvent(EventControl, event.Player)
+}
+
+// removeFromTable takes a kicked or banned player off the table. Every node
+// applies the command, so every node frees their seat for the waiting list
+// whether or not it is connected to them. A seat held in a hand frees up
+// when the hand ends. The caller must hold the game lock.
+func (g *Game) removeFromTable(addr string) {
+	state, ok := g.playerStates[addr]
+	if !ok {
+		return
+	}
+	state.IsActive = false
+	state.IsFolded = true
+	g.removeFromWaitingList(addr)
+	g.releaseSeat(addr)
+	g.disconnect(addr)
+}
+
+// disconnect has the server drop a player removed by a control command.
+// The caller must hold the game lock.
+func (g *Game) disconnect(addr string) {
+	if addr == "" || g.removePeer == nil {
+		return
+	}
+	go g.removePeer(addr)
+}
+
+// Config returns the table config in effect.
+func (g *Game) Config() TableConfig {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.config
+}
+
+// AdoptConfig takes the table config of the peer we join the table through,
+// so a node started with other flags plays by the table's rules. Until we
+// have played a hand our own starting stack follows the table's.
+func (g *Game) AdoptConfig(cfg TableConfig) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	cfg = cfg.withDefaults()
+	if err := validateTableConfig(cfg); err != nil {
+		logrus.Warnf("Ignoring the table config of the peer we joined through: %s", err)
+		return
+	}
+	if cfg == g.config {
+		return
+	}
+	old := g.config
+	g.config = cfg
+	logrus.Infof("Joined a table with blinds %d/%d, buy-in %d-%d", cfg.SmallBlind, cfg.BigBlind, cfg.MinBuyIn, cfg.MaxBuyIn)
+	if state := g.playerStates[g.listenAddr]; g.handNumber == 0 && state != nil && cfg.StartingStack != old.StartingStack {
+		state.Stack += cfg.StartingStack - old.StartingStack
+		g.ledger.restateInitial(g.listenAddr, cfg.StartingStack)
+	}
+	g.logEvent(EventTableConfig, g.listenAddr)
+}
+
+// applyPendingConfig puts config changes made during the last hand into
+// effect. The caller must hold the game lock.
+func (g *Game) applyPendingConfig() {
+	if g.pendingConfig == nil {
+		return
+	}
+	g.config = g.pendingConfig.applyTo(g.config)
+	g.pendingConfig = nil
+	logrus.Infof("Table config changed: blinds %d/%d, buy-in %d-%d", g.config.SmallBlind, g.config.BigBlind, g.config.MinBuyIn, g.config.MaxBuyIn)
+}
+
+// abortHand calls off the hand being played. Every chip bet in it goes back
+// to whoever bet it and the table is paused until someone resumes it. The
+// caller must hold the game lock.
+func (g *Game) abortHand() map[string]int {
+	refunds := make(map[string]int)
+	for addr, state := range g.playerStates {
+		if state.TotalBetThisHand > 0 {
+			state.Stack += state.TotalBetThisHand
+			refunds[addr] = state.TotalBetThisHand
+		}
+		state.TotalBetThisHand = 0
+		state.CurrentRoundBet = 0
+		state.IsAllIn = false
+		state.InHand = false
+	}
+	logrus.Warnf("Hand #%d aborted, refunded %v", g.handNumber, refunds)
+	g.currentPot = 0
+	g.sidePots = []SidePot{}
+	g.highestBet = 0
+	g.lastRaiseAmount = 0
+	g.resetRunOut()
+	g.resetShowdown()
+	g.history = nil
+	g.finishedHand = nil
+	g.myHand = make([]Card, 0, 2)
+	g.communityCards = make([]Card, 0, 5)
+	g.currentDeck = nil
+	g.paused = true
+	g.setStatus(GameStatusHandComplete)
+	g.logEvent(EventHandComplete, "")
+	g.emit(TableEventHandComplete, HandCompleteEvent{HandNumber: g.handNumber})
+	return refunds
+}
+
+// pruneControls forgets proposals that ran out of time, and applied commands
+// old enough that they would be rejected anyway. The caller must hold the
+// game lock.
+func (g *Game) pruneControls() {
+	now := time.Now()
+	for id, proposal := range g.proposals {
+		if now.Sub(time.Unix(proposal.command.IssuedAt, 0)) > controlTTL {
+			logrus.Infof("Control command %s expired without enough approvals", id)
+			delete(g.proposals, id)
+		}
+	}
+	for id, issuedAt := range g.appliedControls {
+		if now.Sub(time.Unix(issuedAt, 0)) > 2*controlTTL {
+			delete(g.appliedControls, id)
+		}
+	}
+}
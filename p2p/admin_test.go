@@ -0,0 +1,123 @@
+package p2p
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// newControlTable is a table of n players who all proved an identity, with
+// us in seat 0. host is the player holding the host key, or -1 for none.
+func newControlTable(t *testing.T, n, host int) (*Game, []*NodeIdentity) {
+	t.Helper()
+	g := newSeatedTable(t, n)
+	ids := make([]*NodeIdentity, n)
+	for i := range ids {
+		id, err := NewNodeIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[i] = id
+		if i == 0 {
+			g.SetIdentity(id, false)
+		} else {
+			g.SetPlayerIdentity(seatAddr(i), id.PublicKey)
+		}
+	}
+	if host >= 0 {
+		g.hostKey = ids[host].PublicKey
+	}
+	return g, ids
+}
+
+func TestControlAuthority(t *testing.T) {
+	tests := []struct {
+		name 		string
+		host 		int
+		kind 		ControlKind
+		target 		int
+		inactive 	[]int
+		signers 	[]int
+		wantNeeded 	int
+		wantOK 		bool
+	}{
+		{name: "host alone", host: 2, kind: ControlPause, signers: []int{2}, wantNeeded: 3, wantOK: true},
+		{name: "one player without the host", host: 2, kind: ControlPause, signers: []int{1}, wantNeeded: 3},
+		{name: "half is not a majority", host: -1, kind: ControlPause, signers: []int{0, 1}, wantNeeded: 3},
+		{name: "majority without a host", host: -1, kind: ControlPause, signers: []int{0, 1, 3}, wantNeeded: 3, wantOK: true},
+		{name: "kick target has no say", host: -1, kind: ControlKick, target: 3, signers: []int{0, 1}, wantNeeded: 2, wantOK: true},
+		{name: "kick target cannot block", host: -1, kind: ControlKick, target: 3, signers: []int{0, 3}, wantNeeded: 2},
+		{name: "players who left do not vote", host: -1, kind: ControlPause, inactive: []int{2, 3}, signers: []int{0, 1}, wantNeeded: 2, wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, ids := newControlTable(t, 4, tt.host)
+			for _, seat := range tt.inactive {
+				g.playerStates[seatAddr(seat)].IsActive = false
+			}
+			cmd := ControlCommand{Kind: tt.kind}
+			if tt.kind == ControlKick {
+				cmd.Target = ids[tt.target].ID
+			}
+			proposal := &controlProposal{command: cmd, signatures: make(map[string][]byte)}
+			for _, seat := range tt.signers {
+				proposal.signatures[ids[seat].ID] = []byte("signed")
+			}
+
+			_, needed, ok := g.authority(proposal)
+
+			if needed != tt.wantNeeded || ok != tt.wantOK {
+				t.Fatalf("needed %d, authorised %v, want %d, %v", needed, ok, tt.wantNeeded, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHandleControlSignatures(t *testing.T) {
+	tests := []struct {
+		name 		string
+		signers 	[]int
+		// forgedBy signs in the name of the first signer
+		forgedBy 	int
+		wantErr 	bool
+		wantPaused 	bool
+	}{
+		{name: "signed by the host", signers: []int{1}, forgedBy: -1, wantPaused: true},
+		{name: "one player of three", signers: []int{2}, forgedBy: -1},
+		{name: "host signature forged", signers: []int{1}, forgedBy: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, ids := newControlTable(t, 3, 1)
+			payload, err := json.Marshal(ControlCommand{
+				ID: 		"cmd-1",
+				Kind: 		ControlPause,
+				IssuedBy: 	ids[tt.signers[0]].ID,
+				IssuedAt: 	time.Now().Unix(),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := MessageControl{Command: payload}
+			for _, seat := range tt.signers {
+				signer := ids[seat]
+				if tt.forgedBy >= 0 {
+					signer = ids[tt.forgedBy]
+				}
+				msg.Signatures = append(msg.Signatures, ControlSignature{
+					Signer: ids[seat].ID,
+					Sig: 	signer.Sign(controlSigningBytes(payload)),
+				})
+			}
+
+			err = g.HandleControl(seatAddr(tt.signers[0]), msg)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if g.paused != tt.wantPaused {
+				t.Fatalf("paused = %v, want %v", g.paused, tt.wantPaused)
+			}
+		})
+	}
+}
@@ -4,18 +4,43 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	defaultHistoryPageSize = 20
+	maxHistoryPageSize = 100
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	wsPongTimeout = 60 * time.Second
+	sseHeartbeatInterval = 15 * time.Second
+	sseRetry = 3 * time.Second
+)
+
+// pushSubscription is how push API clients subscribe to table events. A
+// client that falls behind is cut off and starts over from a full state.
+var pushSubscription = SubscribeOptions{
+	Types: 	publicEventTypes,
+	Policy: BackpressureDisconnect,
+}
+
+
 type apiFunc func(w http.ResponseWriter, r *http.Request) error 
 
 func makeHTTPHandlerFunc(f apiFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := f(w, r); err != nil {
-			JSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+			writeError(w, err)
 		}
 	}
 }
@@ -26,11 +51,22 @@ func JSON(w http.ResponseWriter, status int, v any) error {
 	return json.NewEncoder(w).Encode(v)
 }
 
-func enableCORS(next http.Handler) http.Handler {
+// enableCORS answers browsers calling from one of the configured origins.
+// Requests without an Origin header do not come from a browser page and are
+// left to the token check alone.
+func (s *APIServer) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !s.auth.allowsOrigin(origin) {
+				writeError(w, newAPIError(http.StatusForbidden, CodeForbidden, "origin %s is not allowed", origin))
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID, Idempotency-Key")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Replay-Step, X-Replay-Last-Step")
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -43,41 +79,173 @@ type APIServer struct {
 	listenAddr string 
 	game 	   *Game
 	server 	   *Server
+	auth 	   AuthConfig
+	upgrader   websocket.Upgrader
 }
 
-func NewAPIServer(listenAddr string, game *Game, server *Server) *APIServer {
-	return &APIServer{
+func NewAPIServer(listenAddr string, game *Game, server *Server, auth AuthConfig) *APIServer {
+	s := &APIServer{
 		game: game,
 		listenAddr: listenAddr,
 		server: server,
+		auth: auth,
 	}
+	s.upgrader = websocket.Upgrader{
+		ReadBufferSize: 1024,
+		WriteBufferSize: 4096,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || s.auth.allowsOrigin(origin)
+		},
+	}
+	return s
 }
 
-func (s *APIServer) Run() {
-	r := mux.NewRouter()
-	r.Use(enableCORS)
+// apiRoute is one API endpoint. The router is built from the route table and
+// /api/openapi.json describes the same table, so the two cannot drift apart.
+type apiRoute struct {
+	Method 		string
+	Path 		string
+	Scope 		Scope
+	Handler 	apiFunc
+	Summary 	string
+	Query 		[]apiParam
+	Request 	any
+	Response 	any
+	// Stream is the content type of endpoints that keep pushing events
+	// instead of answering once
+	Stream 		string
+	// RPC names the gRPC method that mirrors the route, if there is one
+	RPC 		string
+}
+
+type apiParam struct {
+	Name 		string
+	Type 		string
+	Description string
+}
+
+func (s *APIServer) routes() []apiRoute {
+	return []apiRoute{
+		{Method: "POST", Path: "/api/connect", Scope: ScopePlayer, Handler: s.handleConnect,
+			Summary: "Connect to a peer", Request: ConnectRequest{}, Response: ConnectResponse{}, RPC: "Connect"},
 
-	r.HandleFunc("/api/connect", makeHTTPHandlerFunc(s.handleConnect)).Methods("POST", "OPTIONS")
+		{Method: "POST", Path: "/api/ready", Scope: ScopePlayer, Handler: s.handlePlayerReady,
+			Summary: "Mark ourselves ready for the next hand", Response: ActionResponse{}, RPC: "Ready"},
+		{Method: "POST", Path: "/api/fold", Scope: ScopePlayer, Handler: s.handlePlayerFold,
+			Summary: "Fold", Request: ActionRequest{}, Response: ActionResponse{}, RPC: "Fold"},
+		{Method: "POST", Path: "/api/check", Scope: ScopePlayer, Handler: s.handlePlayerCheck,
+			Summary: "Check", Request: ActionRequest{}, Response: ActionResponse{}, RPC: "Check"},
+		{Method: "POST", Path: "/api/call", Scope: ScopePlayer, Handler: s.handlePlayerCall,
+			Summary: "Call", Request: ActionRequest{}, Response: ActionResponse{}, RPC: "Call"},
+		{Method: "POST", Path: "/api/bet", Scope: ScopePlayer, Handler: s.handlePlayerBet,
+			Summary: "Bet", Request: ActionRequest{}, Response: ActionResponse{}, RPC: "Bet"},
+		{Method: "POST", Path: "/api/raise", Scope: ScopePlayer, Handler: s.handlePlayerRaise,
+			Summary: "Raise", Request: ActionRequest{}, Response: ActionResponse{}, RPC: "Raise"},
+		{Method: "POST", Path: "/api/straddle", Scope: ScopePlayer, Handler: s.handleStraddle,
+			Summary: "Announce a straddle for the next hand", Request: StraddleRequest{}, Response: ActionResponse{}, RPC: "Straddle"},
+		{Method: "POST", Path: "/api/runit", Scope: ScopePlayer, Handler: s.handleRunIt,
+			Summary: "Vote how many times to run an all-in board", Request: RunItRequest{}, Response: ActionResponse{}, RPC: "RunIt"},
+		{Method: "POST", Path: "/api/show", Scope: ScopePlayer, Handler: s.handleShowCards,
+			Summary: "Show our hole cards", Request: ShowCardsRequest{}, Response: ActionResponse{}, RPC: "Show"},
+		{Method: "POST", Path: "/api/muck", Scope: ScopePlayer, Handler: s.handleMuck,
+			Summary: "Muck our hand at showdown", Response: ActionResponse{}, RPC: "Muck"},
+		{Method: "POST", Path: "/api/rabbit", Scope: ScopePlayer, Handler: s.handleRabbitHunt,
+			Summary: "Ask to rabbit hunt the last hand", Response: ActionResponse{}, RPC: "RabbitHunt"},
 
-	r.HandleFunc("/api/ready", makeHTTPHandlerFunc(s.handlePlayerReady)).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/fold", makeHTTPHandlerFunc(s.handlePlayerFold)).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/check", makeHTTPHandlerFunc(s.handlePlayerCheck)).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/call", makeHTTPHandlerFunc(s.handlePlayerCall)).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/bet", makeHTTPHandlerFunc(s.handlePlayerBet)).Methods("POST", "OPTIONS")
-	r.HandleFunc("/api/raise", makeHTTPHandlerFunc(s.handlePlayerRaise)).Methods("POST", "OPTIONS")
+		{Method: "GET", Path: "/api/table", Scope: ScopeSpectator, Handler: s.handleGetTable,
+			Summary: "Table state, without our hole cards for spectators", Response: TableStateResponse{}, RPC: "GetTable"},
+		{Method: "GET", Path: "/api/players", Scope: ScopeSpectator, Handler: s.handleGetPlayers,
+			Summary: "Players at the table", Response: PlayerResponse{}, RPC: "GetPlayers"},
 
-	r.HandleFunc("/api/table", makeHTTPHandlerFunc(s.handleGetTable)).Methods("GET", "OPTIONS")
-	r.HandleFunc("/api/players", makeHTTPHandlerFunc(s.handleGetPlayers)).Methods("GET", "OPTIONS")
+		{Method: "GET", Path: "/api/seats", Scope: ScopeSpectator, Handler: s.handleGetSeats,
+			Summary: "Seats and the waiting list", Response: SeatsResponse{}, RPC: "GetSeats"},
+		{Method: "POST", Path: "/api/seat", Scope: ScopePlayer, Handler: s.handleTakeSeat,
+			Summary: "Take a seat", Request: SeatRequest{}, Response: ActionResponse{}, RPC: "TakeSeat"},
+		{Method: "DELETE", Path: "/api/seat", Scope: ScopePlayer, Handler: s.handleLeaveSeat,
+			Summary: "Leave our seat", Response: ActionResponse{}, RPC: "LeaveSeat"},
+		{Method: "POST", Path: "/api/waitlist", Scope: ScopePlayer, Handler: s.handleJoinWaitList,
+			Summary: "Join the waiting list", Response: ActionResponse{}, RPC: "JoinWaitList"},
 
-	r.HandleFunc("/api/health", makeHTTPHandlerFunc(s.handleHealth)).Methods("GET", "OPTIONS")
+		{Method: "POST", Path: "/api/rebuy", Scope: ScopePlayer, Handler: s.handleRebuy,
+			Summary: "Rebuy once our stack is empty", Request: BuyInRequest{}, Response: ActionResponse{}, RPC: "Rebuy"},
+		{Method: "POST", Path: "/api/topup", Scope: ScopePlayer, Handler: s.handleTopUp,
+			Summary: "Top up our stack", Request: BuyInRequest{}, Response: ActionResponse{}, RPC: "TopUp"},
+		{Method: "POST", Path: "/api/addon", Scope: ScopePlayer, Handler: s.handleAddOn,
+			Summary: "Take the tournament add-on", Response: ActionResponse{}, RPC: "AddOn"},
+		{Method: "GET", Path: "/api/ledger", Scope: ScopeSpectator, Handler: s.handleGetLedger,
+			Summary: "Chip ledger of every buy-in", Response: LedgerResponse{}, RPC: "GetLedger"},
+		{Method: "GET", Path: "/api/history", Scope: ScopePlayer, Handler: s.handleGetHandHistories,
+			Summary: "Recorded hands, newest first", Response: HandHistoryListResponse{},
+			Query: []apiParam{
+				{Name: "offset", Type: "integer", Description: "Hands to skip"},
+				{Name: "limit", Type: "integer", Description: fmt.Sprintf("Hands to return, at most %d", maxHistoryPageSize)},
+			}},
+		{Method: "GET", Path: "/api/history/{id}", Scope: ScopePlayer, Handler: s.handleGetHandHistory,
+			Summary: "One recorded hand, as JSON, PokerStars text or Open Hand History", Response: HandHistory{},
+			Query: []apiParam{{Name: "format", Type: "string", Description: "json (default), pokerstars or ohh"}}},
+		{Method: "GET", Path: "/api/replay/{id}", Scope: ScopePlayer, Handler: s.handleReplay,
+			Summary: "The table of a recorded hand at a step", Response: TableStateResponse{},
+			Query: []apiParam{{Name: "step", Type: "integer", Description: "Step to show, see the X-Replay-Last-Step header"}}},
+		{Method: "GET", Path: "/api/stats/{player}", Scope: ScopeSpectator, Handler: s.handleGetStats,
+			Summary: "Statistics of a player", Response: StatsResponse{}},
 
+		{Method: "GET", Path: "/api/ws", Scope: ScopeSpectator, Handler: s.handleWebSocket,
+			Summary: "Table events over a WebSocket, starting with a state frame", Response: TableEvent{}, Stream: "websocket"},
+		{Method: "GET", Path: "/api/events", Scope: ScopeSpectator, Handler: s.handleEvents,
+			Summary: "Table events as Server-Sent Events, resumable with Last-Event-ID", Response: TableEvent{}, Stream: "text/event-stream"},
 
+		{Method: "GET", Path: "/api/admin", Scope: ScopeAdmin, Handler: s.handleGetAdmin,
+			Summary: "Table control state and commands waiting for approval", Response: AdminResponse{}, RPC: "GetAdmin"},
+		{Method: "POST", Path: "/api/admin/pause", Scope: ScopeAdmin, Handler: s.handlePause,
+			Summary: "Stop new hands from starting", Response: ControlResponse{}, RPC: "Pause"},
+		{Method: "POST", Path: "/api/admin/resume", Scope: ScopeAdmin, Handler: s.handleResume,
+			Summary: "Let hands start again", Response: ControlResponse{}, RPC: "Resume"},
+		{Method: "POST", Path: "/api/admin/abort", Scope: ScopeAdmin, Handler: s.handleAbortHand,
+			Summary: "Abort the hand being played, refund its bets and pause the table", Response: ControlResponse{}, RPC: "AbortHand"},
+		{Method: "POST", Path: "/api/admin/kick", Scope: ScopeAdmin, Handler: s.handleKick,
+			Summary: "Remove a player from the table", Request: ControlTargetRequest{}, Response: ControlResponse{}, RPC: "Kick"},
+		{Method: "POST", Path: "/api/admin/ban", Scope: ScopeAdmin, Handler: s.handleBan,
+			Summary: "Remove a player and keep their identity out", Request: ControlTargetRequest{}, Response: ControlResponse{}, RPC: "Ban"},
+		{Method: "POST", Path: "/api/admin/unban", Scope: ScopeAdmin, Handler: s.handleUnban,
+			Summary: "Let a banned identity back in", Request: ControlTargetRequest{}, Response: ControlResponse{}, RPC: "Unban"},
+		{Method: "PUT", Path: "/api/admin/config", Scope: ScopeAdmin, Handler: s.handleChangeConfig,
+			Summary: "Change the table config, from the next hand if one is being played", Request: TableConfigChange{}, Response: ControlResponse{}, RPC: "ChangeConfig"},
+		{Method: "POST", Path: "/api/admin/approve", Scope: ScopeAdmin, Handler: s.handleApproveControl,
+			Summary: "Sign a control command another player issued", Request: ApproveControlRequest{}, Response: ControlResponse{}, RPC: "ApproveControl"},
 
+		{Method: "GET", Path: "/api/health", Handler: s.handleHealth,
+			Summary: "Health check", Response: HealthResponse{}, RPC: "Health"},
+		{Method: "GET", Path: "/api/openapi.json", Handler: s.handleOpenAPI,
+			Summary: "This document"},
+	}
+}
+
+func (s *APIServer) router() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(s.enableCORS)
+	for _, route := range s.routes() {
+		r.HandleFunc(route.Path, s.routeHandler(route)).Methods(route.Method)
+	}
+	// preflight requests are answered by enableCORS
+	r.Methods("OPTIONS").HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	return r
+}
+
+// routeHandler is the handler of a route behind its token check.
+func (s *APIServer) routeHandler(route apiRoute) http.HandlerFunc {
+	if route.Scope == ScopeNone {
+		return makeHTTPHandlerFunc(route.Handler)
+	}
+	return s.requireScope(route.Scope, route.Handler)
+}
+
+func (s *APIServer) Run() {
 	logrus.WithFields(logrus.Fields{
 		"addr": s.listenAddr,
 	}).Info("API Server starting...")
 
-	http.ListenAndServe(s.listenAddr, r)
+	http.ListenAndServe(s.listenAddr, s.router())
 }
 
 type ConnectRequest struct {
@@ -87,11 +255,32 @@ type ConnectRequest struct {
 type ConnectResponse struct {
 	Success 	bool 	`json:"success"`
 	Message 	string 	`json:"message"`
-	Peers 		int 
+	Peers 		int 	`json:"peers"`
+}
+
+type HealthResponse struct {
+	Status 		string `json:"status"`
+	GameStatus 	string `json:"game_status"`
+}
+
+// ActionResponse confirms a request that changed the table. Value, Seat,
+// Type and RequestID echo the parts of the request that had them. Replayed
+// is set when an earlier request with the same ID made the change.
+type ActionResponse struct {
+	Status 		string 	`json:"status"`
+	Player 		string 	`json:"player"`
+	Value 		int 	`json:"value,omitempty"`
+	Seat 		*int 	`json:"seat,omitempty"`
+	Type 		string 	`json:"type,omitempty"`
+	RequestID 	string 	`json:"request_id,omitempty"`
+	Replayed 	bool 	`json:"replayed,omitempty"`
 }
 
 type TableStateResponse struct {
 	Status 			string 				`json:"status"`
+	HandNumber 		int 				`json:"hand_number"`
+	// Turn counts the turns of the hand, actions can be pinned to it
+	Turn 			int 				`json:"turn"`
 	MyHand 			[]CardResponse 		`json:"my_hand"`
 	CommunityCards 	[]CardResponse 		`json:"community_cards"`
 	Pot 			int 				`json:"pot"`
@@ -105,7 +294,28 @@ type TableStateResponse struct {
 	DealerID 		int 				`json:"dealer_id"`
 	SmallBlind 		int 				`json:"small_blind"`
 	BigBlind 		int 				`json:"big_blind"`
-	TimeBank 		int 				`json:"time_bank,omitempty"`
+	Straddle 		int 				`json:"straddle,omitempty"`
+	RunItPending 	bool 				`json:"run_it_pending"`
+	CanShowOrMuck 	bool 				`json:"can_show_or_muck"`
+	ShownHands 		map[string][]CardResponse `json:"shown_hands,omitempty"`
+	Runs 			[]RunResponse 		`json:"runs,omitempty"`
+	RabbitVotesNeeded int 				`json:"rabbit_votes_needed,omitempty"`
+	RabbitHunt 		*RabbitHuntResponse `json:"rabbit_hunt,omitempty"`
+	// Paused is set while a control command keeps new hands from starting
+	Paused 			bool 				`json:"paused"`
+}
+
+type RunResponse struct {
+	Board 	[]CardResponse 	`json:"board"`
+	Payouts map[string]int 	`json:"payouts"`
+}
+
+// RabbitHuntResponse shows the cards that would have come in a finished
+// hand. They are not part of that hand or its result.
+type RabbitHuntResponse struct {
+	HandNumber 	int 			`json:"hand_number"`
+	Board 		[]CardResponse 	`json:"board"`
+	RabbitCards []CardResponse 	`json:"rabbit_cards"`
 }
 
 type CardResponse struct {
@@ -126,6 +336,11 @@ type PlayerStateResponse struct {
 	IsSmallBlind 	bool 		`json:"is_small_blind"`
 	IsBigBlind 		bool 		`json:"is_big_blind"`
 	IsCurrentTurn 	bool 		`json:"is_current_turn"`
+	IsSittingOut 	bool 		`json:"is_sitting_out"`
+	OwesBlinds 		bool 		`json:"owes_blinds"`
+	// Identity is the ID of the player's identity key, which admin commands
+	// name players by
+	Identity 		string 		`json:"identity,omitempty"`
 }
 
 type PlayerResponse struct {
@@ -134,17 +349,141 @@ type PlayerResponse struct {
 	ActivePlayers 	int 					`json:"active_players"`
 }
 
+// ActionRequest is the body of an action. Everything is optional except the
+// value of bets and raises, see ActionOptions.
 type ActionRequest struct {
-	Value	int		`json:"value,omitempty"`
+	Value		int		`json:"value,omitempty"`
+	RequestID 	string 	`json:"request_id,omitempty"`
+	HandNumber 	int 	`json:"hand_number,omitempty"`
+	Street 		string 	`json:"street,omitempty"`
+	Turn 		int 	`json:"turn,omitempty"`
+}
+
+type ShowCardsRequest struct {
+	Cards 	[]int 	`json:"cards,omitempty"`
+}
+
+type RunItRequest struct {
+	Runs 	int 	`json:"runs"`
+}
+
+type StraddleRequest struct {
+	Type 	string 	`json:"type"`
+}
+
+type BuyInRequest struct {
+	Value 	int 	`json:"value,omitempty"`
+	TxHash 	string 	`json:"tx_hash,omitempty"`
+}
+
+type LedgerResponse struct {
+	Entries 		[]LedgerEntry 	`json:"entries"`
+	TotalBoughtIn 	map[string]int 	`json:"total_bought_in"`
+	MinBuyIn 		int 			`json:"min_buy_in"`
+	MaxBuyIn 		int 			`json:"max_buy_in"`
+}
+
+type HandSummaryResponse struct {
+	ID 			int64 			`json:"id"`
+	HandNumber 	int 			`json:"hand_number"`
+	StartedAt 	time.Time 		`json:"started_at"`
+	Pot 		int 			`json:"pot"`
+	Board 		[]CardResponse 	`json:"board"`
+	Collected 	map[string]int 	`json:"collected"`
+}
+
+type HandHistoryListResponse struct {
+	Hands 	[]HandSummaryResponse 	`json:"hands"`
+	Total 	int 					`json:"total"`
+	Offset 	int 					`json:"offset"`
+	Limit 	int 					`json:"limit"`
+}
+
+type StatsResponse struct {
+	Player 				string 		`json:"player"`
+	Hands 				int 		`json:"hands"`
+	VPIP 				float64 	`json:"vpip"`
+	PFR 				float64 	`json:"pfr"`
+	AggressionFactor 	float64 	`json:"aggression_factor"`
+	ThreeBet 			float64 	`json:"three_bet"`
+	WentToShowdown 		float64 	`json:"went_to_showdown"`
+	WonAtShowdown 		float64 	`json:"won_at_showdown"`
+	NetChips 			int 		`json:"net_chips"`
+	BigBlindsPer100 	float64 	`json:"bb_per_100"`
+}
+
+type SeatRequest struct {
+	Seat 	int 	`json:"seat"`
+}
+
+type SeatResponse struct {
+	Seat 			int 	`json:"seat"`
+	ListenAddr 		string 	`json:"listen_addr,omitempty"`
+	IsEmpty 		bool 	`json:"is_empty"`
+	IsSittingOut 	bool 	`json:"is_sitting_out"`
+	Stack 			int 	`json:"stack"`
+}
+
+type SeatsResponse struct {
+	Seats 		[]SeatResponse 	`json:"seats"`
+	MaxSeats 	int 			`json:"max_seats"`
+	WaitingList []string 		`json:"waiting_list"`
+}
+
+// ControlTargetRequest names the identity a kick, ban or unban is aimed at,
+// as listed in /api/players.
+type ControlTargetRequest struct {
+	Identity 	string 	`json:"identity"`
+}
+
+type ApproveControlRequest struct {
+	ID 		string 	`json:"id"`
+}
+
+// ControlResponse is where a control command stands. One that is not
+// applied yet needs Needed signatures of players at the table, or the
+// host's, before ExpiresAt.
+type ControlResponse struct {
+	ID 			string 				`json:"id"`
+	Kind 		string 				`json:"kind"`
+	Target 		string 				`json:"target,omitempty"`
+	Config 		*TableConfigChange 	`json:"config,omitempty"`
+	IssuedBy 	string 				`json:"issued_by"`
+	Applied 	bool 				`json:"applied"`
+	Signers 	[]string 			`json:"signers"`
+	Needed 		int 				`json:"needed"`
+	ExpiresAt 	time.Time 			`json:"expires_at"`
+}
+
+type TableConfigResponse struct {
+	SmallBlind 			int 	`json:"small_blind"`
+	BigBlind 			int 	`json:"big_blind"`
+	MinBuyIn 			int 	`json:"min_buy_in"`
+	MaxBuyIn 			int 	`json:"max_buy_in"`
+	MaxRuns 			int 	`json:"max_runs"`
+	AllowStraddle 		bool 	`json:"allow_straddle"`
+	AllowButtonStraddle bool 	`json:"allow_button_straddle"`
+	AllowRabbitHunt 	bool 	`json:"allow_rabbit_hunt"`
+}
+
+type AdminResponse struct {
+	Identity 		string 				`json:"identity"`
+	Host 			string 				`json:"host,omitempty"`
+	IsHost 			bool 				`json:"is_host"`
+	Paused 			bool 				`json:"paused"`
+	Banned 			[]string 			`json:"banned"`
+	Config 			TableConfigResponse `json:"config"`
+	PendingConfig 	*TableConfigChange 	`json:"pending_config,omitempty"`
+	Proposals 		[]ControlResponse 	`json:"proposals"`
 }
 
 func (s *APIServer) handleConnect(w http.ResponseWriter, r *http.Request) error {
 	var req ConnectRequest 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return err
+		return invalidJSON(err)
 	}
 	if req.Addr == "" {
-		return fmt.Errorf("peer address cannot be empty")
+		return newRuleError(ErrInvalidRequest, "peer address cannot be empty")
 	}
 	logrus.Infof("API: Attempting to connect to peer: %s", req.Addr)
 
@@ -167,7 +506,7 @@ func (s *APIServer) handleConnect(w http.ResponseWriter, r *http.Request) error
 	case err := <-resultCh:
 		if err != nil {
 			logrus.Errorf("Failed to connect to peer %s: %s", req.Addr, err)
-			return fmt.Errorf("Connection failed: %s", err)
+			return newAPIError(http.StatusBadGateway, CodePeerUnreachable, "Connection failed: %s", err)
 		}
 		time.Sleep(500 * time.Millisecond)
 
@@ -177,31 +516,196 @@ func (s *APIServer) handleConnect(w http.ResponseWriter, r *http.Request) error
 			Peers: len(s.server.Peers()),
 		})
 	case <-time.After(5*time.Second):
-		return fmt.Errorf("connection timeour after 5 seconds")
+		return newAPIError(http.StatusGatewayTimeout, CodePeerTimeout, "connection timed out after 5 seconds")
 	}
 }
 
 func (s *APIServer) handleHealth(w http.ResponseWriter, r *http.Request) error {
-	return JSON(w, http.StatusOK, map[string]string{
-		"status": "healthy",
-		"game_status": s.game.GetStatus().String(),
+	return JSON(w, http.StatusOK, HealthResponse{
+		Status: 	"healthy",
+		GameStatus: s.game.GetStatus().String(),
+	})
+}
+
+// handleWebSocket pushes table events to the client as they happen. The
+// first frame is the full table state, so a client needs nothing else to
+// get going, and is numbered with the last event it already reflects.
+func (s *APIServer) handleWebSocket(w http.ResponseWriter, r *http.Request) error {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has already answered the request
+		logrus.Warnf("WebSocket upgrade from %s failed: %s", r.RemoteAddr, err)
+		return nil
+	}
+	defer conn.Close()
+
+	// events are only published under the game lock, so none can slip in
+	// between the state frame and the subscription
+	s.game.lock.RLock()
+	events, seq := s.game.events.Subscribe(pushSubscription)
+	state := s.game.stateEvent(seq)
+	s.game.lock.RUnlock()
+	defer events.Close()
+
+	// the client sends nothing we need, reading only notices it leaving
+	done := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
 	})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	scope := requestScope(r)
+	write := func(event TableEvent) error {
+		event, ok := visibleTo(scope, event)
+		if !ok {
+			return nil
+		}
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(event)
+	}
+	if err := write(state); err != nil {
+		return nil
+	}
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case event, ok := <-events.C:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client fell behind"),
+					time.Now().Add(wsWriteTimeout))
+				return nil
+			}
+			if err := write(event); err != nil {
+				return nil
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return nil
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// handleEvents streams the same events as /api/ws as Server-Sent Events, for
+// clients behind proxies that break WebSockets. A client sending the
+// Last-Event-ID it saw gets the events it missed, as long as they are still
+// buffered, and a full state frame otherwise. Comments go out as heartbeats
+// so idle connections are not cut.
+func (s *APIServer) handleEvents(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("streaming is not supported on this connection")
+	}
+	bus := s.game.events
+	events, backlog := s.resume(r.Header.Get("Last-Event-ID"))
+	defer events.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// stops nginx and the like from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	scope := requestScope(r)
+	send := func(event TableEvent) error {
+		event, ok := visibleTo(scope, event)
+		if !ok {
+			return nil
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", bus.EventID(event.Seq), event.Type, data)
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds()); err != nil {
+		return nil
+	}
+	for _, event := range backlog {
+		if err := send(event); err != nil {
+			return nil
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, ok := <-events.C:
+			if !ok {
+				// the client fell behind, it reconnects and resumes
+				return nil
+			}
+			if err := send(event); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+		case <-r.Context().Done():
+			return nil
+		}
+		flusher.Flush()
+	}
+}
+
+// resume subscribes a push client to table events. It returns the events
+// the client missed since lastEventID, or a state frame to start over from if
+// they are no longer buffered or the client has not seen any.
+func (s *APIServer) resume(lastEventID string) (*Subscription, []TableEvent) {
+	bus := s.game.events
+	s.game.lock.RLock()
+	defer s.game.lock.RUnlock()
+
+	if seq, ok := bus.ParseEventID(lastEventID); ok {
+		if events, backlog, resumed := bus.SubscribeSince(seq, pushSubscription); resumed {
+			return events, backlog
+		}
+	}
+	events, seq := bus.Subscribe(pushSubscription)
+	return events, []TableEvent{s.game.stateEvent(seq)}
 }
 
 func (s *APIServer) handleGetTable(w http.ResponseWriter, r *http.Request) error {
 	s.game.lock.RLock()
 	defer s.game.lock.RUnlock()
 
-	validActions := s.game.getValidActions()
+	table := s.game.tableState()
+	if requestScope(r) < ScopePlayer {
+		table = table.forSpectator()
+	}
+	return JSON(w, http.StatusOK, table)
+}
+
+// tableState builds the /api/table view of the game. The caller must hold
+// the game lock.
+func (g *Game) tableState() TableStateResponse {
+	validActions := g.getValidActions()
 	actionStrings := make([]string, len(validActions))
 	for i, action := range validActions {
 		actionStrings[i] = action.String()
 	}
 
 	myHandResp := make([]CardResponse, 0) 
-	if len(s.game.myHand) > 0 {
-		myHandResp = make([]CardResponse, len(s.game.myHand))
-		for i, card := range s.game.myHand {
+	if len(g.myHand) > 0 {
+		myHandResp = make([]CardResponse, len(g.myHand))
+		for i, card := range g.myHand {
 			myHandResp[i] = CardResponse{
 				Suit: card.Suit.String(),
 				Value: card.Value,
@@ -209,8 +713,8 @@ func (s *APIServer) handleGetTable(w http.ResponseWriter, r *http.Request) error
 			}
 		}
 	}
-	communityCardResp := make([]CardResponse, len(s.game.communityCards))
-	for i, card := range s.game.communityCards {
+	communityCardResp := make([]CardResponse, len(g.communityCards))
+	for i, card := range g.communityCards {
 		communityCardResp[i] = CardResponse{
 			Suit: card.Suit.String(),
 			Value: card.Value,
@@ -218,56 +722,102 @@ func (s *APIServer) handleGetTable(w http.ResponseWriter, r *http.Request) error
 		}
 	}
 
-	minRaise := s.game.highestBet + s.game.lastRaiseAmount
-	if s.game.highestBet == 0 {
-		minRaise = BigBlind
+	minRaise := g.highestBet + g.lastRaiseAmount
+	if g.highestBet == 0 {
+		minRaise = g.config.BigBlind
 	}
 
-	myState := s.game.playerStates[s.game.listenAddr]
+	myState := g.playerStates[g.listenAddr]
 
 	resp := TableStateResponse{
-		Status: 		s.game.GetStatus().String(),
+		Status: 		g.GetStatus().String(),
+		HandNumber: 	g.handNumber,
+		Turn: 			g.turnNumber,
 		MyHand: 		myHandResp,
 		CommunityCards: 	communityCardResp,
-		Pot: 			s.game.currentPot,
-		HighestBet: 	s.game.highestBet,
+		Pot: 			g.currentPot,
+		HighestBet: 	g.highestBet,
 		MinRaise: 		minRaise,
 		ValidActions: 	actionStrings,
-		IsMyTurn: 		myState.RotationID == s.game.currentPlayerTurnID,
+		IsMyTurn: 		myState.RotationID == g.currentPlayerTurnID,
 		MyStack: 		myState.Stack,
-		CurrentTurnID: 	s.game.currentPlayerTurnID,
+		CurrentTurnID: 	g.currentPlayerTurnID,
 		MyPlayerID: 	myState.RotationID,
-		DealerID: 		s.game.currentDealerID,
-		SmallBlind: 	SmallBlind,
-		BigBlind: 		BigBlind,
+		DealerID: 		g.currentDealerID,
+		SmallBlind: 	g.config.SmallBlind,
+		BigBlind: 		g.config.BigBlind,
+		Straddle: 		g.straddleAmount,
+		RunItPending: 	g.runItPending,
+		CanShowOrMuck: 	g.showDecisionPending,
+		Paused: 		g.paused,
+	}
+	for addr, cards := range g.shownCards {
+		if resp.ShownHands == nil {
+			resp.ShownHands = make(map[string][]CardResponse)
+		}
+		for _, card := range cards {
+			resp.ShownHands[addr] = append(resp.ShownHands[addr], CardResponse{
+				Suit: card.Suit.String(),
+				Value: card.Value,
+				Display: card.String(),
+			})
+		}
+	}
+	for _, result := range g.runResults {
+		board := make([]CardResponse, len(result.Board))
+		for i, card := range result.Board {
+			board[i] = CardResponse{
+				Suit: card.Suit.String(),
+				Value: card.Value,
+				Display: card.String(),
+			}
+		}
+		resp.Runs = append(resp.Runs, RunResponse{Board: board, Payouts: result.Payouts})
+	}
+	resp.RabbitVotesNeeded = g.rabbitVotesNeeded()
+	if hunt := g.rabbitHunt; hunt != nil {
+		rabbit := &RabbitHuntResponse{HandNumber: hunt.HandNumber}
+		for _, card := range hunt.Board {
+			rabbit.Board = append(rabbit.Board, CardResponse{
+				Suit: card.Suit.String(),
+				Value: card.Value,
+				Display: card.String(),
+			})
+		}
+		for _, card := range hunt.Cards {
+			rabbit.RabbitCards = append(rabbit.RabbitCards, CardResponse{
+				Suit: card.Suit.String(),
+				Value: card.Value,
+				Display: card.String(),
+			})
+		}
+		resp.RabbitHunt = rabbit
 	}
 
-	return JSON(w, http.StatusOK, resp)
+	return resp
 }
 
 func (s *APIServer) handleGetPlayers(w http.ResponseWriter, r *http.Request) error {
 	s.game.lock.RLock()
 	defer s.game.lock.RUnlock()
 
+	return JSON(w, http.StatusOK, s.game.playersState())
+}
+
+// playersState builds the /api/players view of the game. The caller must
+// hold the game lock.
+func (g *Game) playersState() PlayerResponse {
 	players := make([]PlayerStateResponse, 0)
 	activeCount := 0 
 
-	var sbID, bbID int
-	activeReadyPlayers := s.game.getReadyActivePlayers()
-	if len(activeReadyPlayers) == 2{
-		sbID = s.game.currentDealerID
-		bbID = s.game.getNextPlayerID(sbID)
-	} else if len(activeReadyPlayers) > 2 {
-		sbID = s.game.getNextActivePlayerID(s.game.currentDealerID)
-		bbID = s.game.getNextActivePlayerID(sbID)
-	}
+	sbID, bbID := g.smallBlindID, g.bigBlindID
 
-	for i := 0; i < s.game.nextRotationID; i++ {
-		addr, ok := s.game.rotationMap[i]
+	for i := 0; i < g.nextRotationID; i++ {
+		addr, ok := g.rotationMap[i]
 		if !ok {
 			continue
 		}
-		state, ok := s.game.playerStates[addr]
+		state, ok := g.playerStates[addr]
 		if !ok {
 			continue
 		}
@@ -282,97 +832,510 @@ func (s *APIServer) handleGetPlayers(w http.ResponseWriter, r *http.Request) err
 			IsActive: 		state.IsActive,
 			IsFolded: 		state.IsFolded,
 			IsAllIn: 		state.IsAllIn,
-			IsDealer: 		state.RotationID == s.game.currentDealerID,
+			IsDealer: 		state.RotationID == g.currentDealerID,
 			IsSmallBlind: 	state.RotationID == sbID,
 			IsBigBlind: 	state.RotationID == bbID,
-			IsCurrentTurn: 	state.RotationID == s.game.currentPlayerTurnID,
+			IsCurrentTurn: 	state.RotationID == g.currentPlayerTurnID,
+			IsSittingOut: 	g.isSittingOut(state),
+			OwesBlinds: 	state.MissedSmallBlind || state.MissedBigBlind,
+			Identity: 		g.identityOf(addr),
 		})
 	}
 
-	resp := PlayerResponse{
+	return PlayerResponse{
 		Players: players,
 		TotalPlayers: len(players),
 		ActivePlayers: activeCount,
 	}
+}
 
-	return JSON(w, http.StatusOK, resp)
+func (s *APIServer) handleGetSeats(w http.ResponseWriter, r *http.Request) error {
+	s.game.lock.RLock()
+	defer s.game.lock.RUnlock()
+
+	seats := make([]SeatResponse, s.game.config.MaxSeats)
+	for i := range seats {
+		seats[i] = SeatResponse{Seat: i, IsEmpty: true}
+		addr, ok := s.game.rotationMap[i]
+		if !ok {
+			continue
+		}
+		state := s.game.playerStates[addr]
+		seats[i] = SeatResponse{
+			Seat: 			i,
+			ListenAddr: 	addr,
+			IsSittingOut: 	s.game.isSittingOut(state),
+			Stack: 			state.Stack,
+		}
+	}
+	waitingList := make([]string, len(s.game.waitingList))
+	copy(waitingList, s.game.waitingList)
+
+	return JSON(w, http.StatusOK, SeatsResponse{
+		Seats: 			seats,
+		MaxSeats: 		s.game.config.MaxSeats,
+		WaitingList: 	waitingList,
+	})
+}
+
+func (s *APIServer) handleTakeSeat(w http.ResponseWriter, r *http.Request) error {
+	var req SeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return invalidJSON(err)
+	}
+	if err := s.game.TakeSeat(req.Seat); err != nil {
+		return err
+	}
+	return JSON(w, http.StatusOK, ActionResponse{
+		Status: 	"SEATED",
+		Player: 	s.game.listenAddr,
+		Seat: 		&req.Seat,
+	})
+}
+
+func (s *APIServer) handleLeaveSeat(w http.ResponseWriter, r *http.Request) error {
+	if err := s.game.LeaveSeat(); err != nil {
+		return err
+	}
+	return JSON(w, http.StatusOK, ActionResponse{
+		Status: 	"LEFT-SEAT",
+		Player: 	s.game.listenAddr,
+	})
+}
+
+func (s *APIServer) handleJoinWaitList(w http.ResponseWriter, r *http.Request) error {
+	if err := s.game.JoinWaitingList(); err != nil {
+		return err
+	}
+	return JSON(w, http.StatusOK, ActionResponse{
+		Status: 	"WAITING",
+		Player: 	s.game.listenAddr,
+	})
+}
+
+func (s *APIServer) handleRebuy(w http.ResponseWriter, r *http.Request) error {
+	return s.handleBuyIn(w, r, BuyInRebuy)
+}
+
+func (s *APIServer) handleTopUp(w http.ResponseWriter, r *http.Request) error {
+	return s.handleBuyIn(w, r, BuyInTopUp)
+}
+
+func (s *APIServer) handleAddOn(w http.ResponseWriter, r *http.Request) error {
+	return s.handleBuyIn(w, r, BuyInAddOn)
+}
+
+func (s *APIServer) handleBuyIn(w http.ResponseWriter, r *http.Request, kind BuyInKind) error {
+	var req BuyInRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return invalidJSON(err)
+		}
+	}
+	if kind == BuyInAddOn {
+		req.Value = s.game.Config().AddOnChips
+	}
+	if err := s.game.BuyIn(kind, req.Value, req.TxHash); err != nil {
+		return err
+	}
+	return JSON(w, http.StatusOK, ActionResponse{
+		Status: 	kind.String(),
+		Player: 	s.game.listenAddr,
+		Value: 		req.Value,
+	})
+}
+
+func (s *APIServer) handleGetLedger(w http.ResponseWriter, r *http.Request) error {
+	entries := s.game.ledger.Entries()
+	cfg := s.game.Config()
+	totals := make(map[string]int)
+	for _, entry := range entries {
+		totals[entry.Player] += entry.Amount
+	}
+	return JSON(w, http.StatusOK, LedgerResponse{
+		Entries: 		entries,
+		TotalBoughtIn: 	totals,
+		MinBuyIn: 		cfg.MinBuyIn,
+		MaxBuyIn: 		cfg.MaxBuyIn,
+	})
 }
 
 func (s *APIServer) handlePlayerReady(w http.ResponseWriter, r *http.Request) error {
 	s.game.SetReady(s.game.listenAddr)
-	return JSON(w, http.StatusOK, map[string]string{
-		"status": "READY",
-		"player": s.game.listenAddr,
+	return JSON(w, http.StatusOK, ActionResponse{
+		Status: 	"READY",
+		Player: 	s.game.listenAddr,
 	})
 }
 
 func (s *APIServer) handlePlayerFold(w http.ResponseWriter, r *http.Request) error {
-	if err := s.game.TakeAction(PlayerActionFold, 0); err != nil {
+	return s.takeAction(w, r, PlayerActionFold)
+}
+
+func (s *APIServer) handlePlayerCheck(w http.ResponseWriter, r *http.Request) error {
+	return s.takeAction(w, r, PlayerActionCheck)
+}
+
+func (s *APIServer) handlePlayerCall(w http.ResponseWriter, r *http.Request) error {
+	return s.takeAction(w, r, PlayerActionCall)
+}
+
+func (s *APIServer) handlePlayerBet(w http.ResponseWriter, r *http.Request) error {
+	return s.takeAction(w, r, PlayerActionBet)
+}
+
+func (s *APIServer) handlePlayerRaise(w http.ResponseWriter, r *http.Request) error {
+	return s.takeAction(w, r, PlayerActionRaise)
+}
+
+// takeAction takes a betting action. The request ID may also come as the
+// Idempotency-Key header.
+func (s *APIServer) takeAction(w http.ResponseWriter, r *http.Request, action PlayerAction) error {
+	var req ActionRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return invalidJSON(err)
+		}
+	}
+	if action != PlayerActionBet && action != PlayerActionRaise {
+		req.Value = 0
+	} else if req.Value <= 0 {
+		return newRuleError(ErrInvalidRequest, "%s value must be positive, got: %d", strings.ToLower(action.String()), req.Value)
+	}
+	if req.RequestID == "" {
+		req.RequestID = r.Header.Get("Idempotency-Key")
+	}
+	result, err := s.game.TakeAction(action, req.Value, ActionOptions{
+		RequestID: 	req.RequestID,
+		HandNumber: req.HandNumber,
+		Street: 	req.Street,
+		Turn: 		req.Turn,
+	})
+	if err != nil {
 		return err
 	}
-	return JSON(w, http.StatusOK, map[string]any{
-		"status": "FOLD",
-		"player": s.game.listenAddr,
+	return JSON(w, http.StatusOK, ActionResponse{
+		Status: 	action.String(),
+		Player: 	s.game.listenAddr,
+		Value: 		result.Value,
+		RequestID: 	req.RequestID,
+		Replayed: 	result.Replayed,
 	})
 }
 
-func (s *APIServer) handlePlayerCheck(w http.ResponseWriter, r *http.Request) error {
-	if err := s.game.TakeAction(PlayerActionCheck, 0); err != nil {
+func (s *APIServer) handleStraddle(w http.ResponseWriter, r *http.Request) error {
+	var req StraddleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return invalidJSON(err)
+	}
+	kind, err := ParseStraddleKind(req.Type)
+	if err != nil {
 		return err
 	}
-	return JSON(w, http.StatusOK, map[string]any{
-		"status": "CHECK",
-		"player": s.game.listenAddr,
+	if err := s.game.AnnounceStraddle(kind); err != nil {
+		return err
+	}
+	return JSON(w, http.StatusOK, ActionResponse{
+		Status: 	"STRADDLE",
+		Player: 	s.game.listenAddr,
+		Type: 		kind.String(),
 	})
 }
 
-func (s *APIServer) handlePlayerCall(w http.ResponseWriter, r *http.Request) error {
-	if err := s.game.TakeAction(PlayerActionCall, 0); err != nil {
+func (s *APIServer) handleRunIt(w http.ResponseWriter, r *http.Request) error {
+	var req RunItRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return invalidJSON(err)
+	}
+	if err := s.game.RunIt(req.Runs); err != nil {
 		return err
 	}
-	return JSON(w, http.StatusOK, map[string]any{
-		"status": "CALL",
-		"player": s.game.listenAddr,
+	return JSON(w, http.StatusOK, ActionResponse{
+		Status: 	"RUN-IT",
+		Player: 	s.game.listenAddr,
+		Value: 		req.Runs,
 	})
 }
 
-func (s *APIServer) handlePlayerBet(w http.ResponseWriter, r *http.Request) error {
-	value, err := parseActionValue(r, "bet")
-	if err != nil {
+func (s *APIServer) handleShowCards(w http.ResponseWriter, r *http.Request) error {
+	var req ShowCardsRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return invalidJSON(err)
+		}
+	}
+	if err := s.game.ShowCards(req.Cards); err != nil {
 		return err
 	}
-	if err := s.game.TakeAction(PlayerActionBet, value); err != nil {
+	return JSON(w, http.StatusOK, ActionResponse{
+		Status: 	"SHOW",
+		Player: 	s.game.listenAddr,
+	})
+}
+
+func (s *APIServer) handleMuck(w http.ResponseWriter, r *http.Request) error {
+	if err := s.game.Muck(); err != nil {
 		return err
 	}
-	return JSON(w, http.StatusOK, map[string]any{
-		"status": "BET",
-		"player": s.game.listenAddr,
-		"value": value,
+	return JSON(w, http.StatusOK, ActionResponse{
+		Status: 	"MUCK",
+		Player: 	s.game.listenAddr,
 	})
 }
 
-func (s *APIServer) handlePlayerRaise(w http.ResponseWriter, r *http.Request) error {
-	value, err := parseActionValue(r, "raise")
+func (s *APIServer) handleRabbitHunt(w http.ResponseWriter, r *http.Request) error {
+	if err := s.game.RequestRabbitHunt(); err != nil {
+		return err
+	}
+	return JSON(w, http.StatusOK, ActionResponse{
+		Status: 	"RABBIT_HUNT",
+		Player: 	s.game.listenAddr,
+	})
+}
+
+func (s *APIServer) handleGetHandHistories(w http.ResponseWriter, r *http.Request) error {
+	offset, err := parseQueryInt(r, "offset", 0)
+	if err != nil {
+		return err
+	}
+	limit, err := parseQueryInt(r, "limit", defaultHistoryPageSize)
+	if err != nil {
+		return err
+	}
+	if offset < 0 || limit <= 0 || limit > maxHistoryPageSize {
+		return newRuleError(ErrInvalidRequest, "offset must be at least 0 and limit between 1 and %d", maxHistoryPageSize)
+	}
+
+	hands, total := s.game.handHistory.List(offset, limit)
+	resp := HandHistoryListResponse{
+		Hands: 	make([]HandSummaryResponse, len(hands)),
+		Total: 	total,
+		Offset: offset,
+		Limit: 	limit,
+	}
+	for i, hand := range hands {
+		board := make([]CardResponse, len(hand.Board))
+		for j, card := range hand.Board {
+			board[j] = CardResponse{
+				Suit: card.Suit.String(),
+				Value: card.Value,
+				Display: card.String(),
+			}
+		}
+		resp.Hands[i] = HandSummaryResponse{
+			ID: 		hand.ID,
+			HandNumber: hand.HandNumber,
+			StartedAt: 	hand.StartedAt,
+			Pot: 		hand.Pot,
+			Board: 		board,
+			Collected: 	hand.Collected,
+		}
+	}
+	return JSON(w, http.StatusOK, resp)
+}
+
+func (s *APIServer) handleGetHandHistory(w http.ResponseWriter, r *http.Request) error {
+	hand, err := s.lookupHand(r)
 	if err != nil {
 		return err
 	}
-	if err := s.game.TakeAction(PlayerActionRaise, value); err != nil {
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		return JSON(w, http.StatusOK, hand)
+	case "pokerstars":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, err := w.Write([]byte(FormatPokerStars(hand)))
+		return err
+	case "ohh":
+		return JSON(w, http.StatusOK, ToOpenHandHistory(hand))
+	default:
+		return newRuleError(ErrInvalidRequest, "unknown format %q, want json, pokerstars or ohh", r.URL.Query().Get("format"))
+	}
+}
+
+// handleReplay returns the table of a recorded hand at ?step=N. The step
+// shown and the last step are sent in the X-Replay-Step and
+// X-Replay-Last-Step headers so clients can scrub through the hand.
+func (s *APIServer) handleReplay(w http.ResponseWriter, r *http.Request) error {
+	hand, err := s.lookupHand(r)
+	if err != nil {
 		return err
 	}
-	return JSON(w, http.StatusOK, map[string]any{
-		"status": "RAISE",
-		"player": s.game.listenAddr,
-		"value": value,
+	step, err := parseQueryInt(r, "step", 0)
+	if err != nil {
+		return err
+	}
+	resp, err := ReplayHand(hand, step)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("X-Replay-Step", strconv.Itoa(step))
+	w.Header().Set("X-Replay-Last-Step", strconv.Itoa(ReplayLastStep(hand)))
+	return JSON(w, http.StatusOK, resp)
+}
+
+func (s *APIServer) handleGetStats(w http.ResponseWriter, r *http.Request) error {
+	player := mux.Vars(r)["player"]
+	stats, ok := s.server.stats.Get(player)
+	if !ok {
+		return newRuleError(ErrNotFound, "no stats for player %s", player)
+	}
+	return JSON(w, http.StatusOK, StatsResponse{
+		Player: 			player,
+		Hands: 				stats.Hands,
+		VPIP: 				stats.VPIPPercent(),
+		PFR: 				stats.PFRPercent(),
+		AggressionFactor: 	stats.AggressionFactor(),
+		ThreeBet: 			stats.ThreeBetPercent(),
+		WentToShowdown: 	stats.WTSDPercent(),
+		WonAtShowdown: 		stats.WSDPercent(),
+		NetChips: 			stats.NetChips,
+		BigBlindsPer100: 	stats.BigBlindsPer100(),
 	})
 }
 
-func parseActionValue(r *http.Request, actionName string) (int, error) {
-	var req ActionRequest
+func (s *APIServer) lookupHand(r *http.Request) (*HandHistory, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, newRuleError(ErrInvalidRequest, "invalid hand id: %s", mux.Vars(r)["id"])
+	}
+	hand, ok := s.game.handHistory.Get(id)
+	if !ok {
+		return nil, newRuleError(ErrNotFound, "no hand with id %d", id)
+	}
+	return hand, nil
+}
+
+func parseQueryInt(r *http.Request, name string, fallback int) (int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return fallback, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, newRuleError(ErrInvalidRequest, "%s must be a number, got: %s", name, raw)
+	}
+	return value, nil
+}
+
+func (s *APIServer) handleGetAdmin(w http.ResponseWriter, r *http.Request) error {
+	s.game.lock.Lock()
+	defer s.game.lock.Unlock()
+
+	return JSON(w, http.StatusOK, s.game.adminState())
+}
+
+// adminState builds the /api/admin view of the game. The caller must hold
+// the game lock.
+func (g *Game) adminState() AdminResponse {
+	cfg := g.config
+	resp := AdminResponse{
+		Paused: 		g.paused,
+		Banned: 		make([]string, 0, len(g.banned)),
+		PendingConfig: 	g.pendingConfig,
+		Proposals: 		[]ControlResponse{},
+		Config: TableConfigResponse{
+			SmallBlind: 			cfg.SmallBlind,
+			BigBlind: 				cfg.BigBlind,
+			MinBuyIn: 				cfg.MinBuyIn,
+			MaxBuyIn: 				cfg.MaxBuyIn,
+			MaxRuns: 				cfg.MaxRuns,
+			AllowStraddle: 			cfg.AllowStraddle,
+			AllowButtonStraddle: 	cfg.AllowButtonStraddle,
+			AllowRabbitHunt: 		cfg.AllowRabbitHunt,
+		},
+	}
+	if g.identity != nil {
+		resp.Identity = g.identity.ID
+	}
+	if g.hostKey != nil {
+		resp.Host = identityID(g.hostKey)
+		resp.IsHost = resp.Host == resp.Identity
+	}
+	for id := range g.banned {
+		resp.Banned = append(resp.Banned, id)
+	}
+	sort.Strings(resp.Banned)
+	for _, result := range g.pendingControls() {
+		resp.Proposals = append(resp.Proposals, controlResponse(result))
+	}
+	return resp
+}
+
+func controlResponse(result ControlResult) ControlResponse {
+	return ControlResponse{
+		ID: 		result.Command.ID,
+		Kind: 		string(result.Command.Kind),
+		Target: 	result.Command.Target,
+		Config: 	result.Command.Config,
+		IssuedBy: 	result.Command.IssuedBy,
+		Applied: 	result.Applied,
+		Signers: 	result.Signers,
+		Needed: 	result.Needed,
+		ExpiresAt: 	time.Unix(result.Command.IssuedAt, 0).Add(controlTTL).UTC(),
+	}
+}
+
+func (s *APIServer) issueControl(w http.ResponseWriter, cmd ControlCommand) error {
+	result, err := s.game.IssueControl(cmd)
+	if err != nil {
+		return err
+	}
+	return JSON(w, http.StatusOK, controlResponse(result))
+}
+
+func (s *APIServer) handlePause(w http.ResponseWriter, r *http.Request) error {
+	return s.issueControl(w, ControlCommand{Kind: ControlPause})
+}
+
+func (s *APIServer) handleResume(w http.ResponseWriter, r *http.Request) error {
+	return s.issueControl(w, ControlCommand{Kind: ControlResume})
+}
+
+func (s *APIServer) handleAbortHand(w http.ResponseWriter, r *http.Request) error {
+	return s.issueControl(w, ControlCommand{Kind: ControlAbort})
+}
+
+func (s *APIServer) handleKick(w http.ResponseWriter, r *http.Request) error {
+	return s.issueTargetedControl(w, r, ControlKick)
+}
+
+func (s *APIServer) handleBan(w http.ResponseWriter, r *http.Request) error {
+	return s.issueTargetedControl(w, r, ControlBan)
+}
+
+func (s *APIServer) handleUnban(w http.ResponseWriter, r *http.Request) error {
+	return s.issueTargetedControl(w, r, ControlUnban)
+}
+
+func (s *APIServer) issueTargetedControl(w http.ResponseWriter, r *http.Request, kind ControlKind) error {
+	var req ControlTargetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return invalidJSON(err)
+	}
+	if req.Identity == "" {
+		return newRuleError(ErrInvalidRequest, "identity cannot be empty")
+	}
+	return s.issueControl(w, ControlCommand{Kind: kind, Target: req.Identity})
+}
+
+func (s *APIServer) handleChangeConfig(w http.ResponseWriter, r *http.Request) error {
+	var req TableConfigChange
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return invalidJSON(err)
+	}
+	return s.issueControl(w, ControlCommand{Kind: ControlConfig, Config: &req})
+}
+
+func (s *APIServer) handleApproveControl(w http.ResponseWriter, r *http.Request) error {
+	var req ApproveControlRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return 0, fmt.Errorf("invalid request body: %s", err)
+		return invalidJSON(err)
 	}
-	if req.Value <= 0 {
-		return 0, fmt.Errorf("%s value must be positive, got: %d", actionName, req.Value)
+	result, err := s.game.ApproveControl(req.ID)
+	if err != nil {
+		return err
 	}
-	return req.Value, nil
+	return JSON(w, http.StatusOK, controlResponse(result))
 }
\ No newline at end of file
@@ -0,0 +1,162 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Scope is what an API token lets its holder do. A player token can act on
+// the node's behalf, a spectator token can only watch the table and never
+// sees the node's hole cards. An admin token can also issue table control
+// commands signed with the node's identity.
+type Scope int
+
+const (
+	ScopeNone Scope = iota
+	ScopeSpectator
+	ScopePlayer
+	ScopeAdmin
+)
+
+func (s Scope) String() string {
+	switch s {
+	case ScopeSpectator: 	return "spectator"
+	case ScopePlayer: 		return "player"
+	case ScopeAdmin: 		return "admin"
+	default: 				return "none"
+	}
+}
+
+// DefaultCORSOrigins lets the frontend dev server talk to the API.
+var DefaultCORSOrigins = []string{"http://localhost:3000", "http://127.0.0.1:3000"}
+
+// AuthConfig holds the API tokens and the origins browsers may call the API
+// from. Tokens left empty are generated when the API starts.
+type AuthConfig struct {
+	AdminToken 		string
+	PlayerToken 	string
+	SpectatorToken 	string
+	// CORSOrigins are allowed browser origins, "*" allows any
+	CORSOrigins 	[]string
+}
+
+// withTokens fills in any token that was not configured.
+func (c AuthConfig) withTokens() (AuthConfig, error) {
+	var err error
+	if c.AdminToken == "" {
+		if c.AdminToken, err = NewAPIToken(); err != nil {
+			return c, err
+		}
+	}
+	if c.PlayerToken == "" {
+		if c.PlayerToken, err = NewAPIToken(); err != nil {
+			return c, err
+		}
+	}
+	if c.SpectatorToken == "" {
+		if c.SpectatorToken, err = NewAPIToken(); err != nil {
+			return c, err
+		}
+	}
+	if c.PlayerToken == c.SpectatorToken || c.AdminToken == c.PlayerToken || c.AdminToken == c.SpectatorToken {
+		return c, fmt.Errorf("the admin, player and spectator tokens must all differ")
+	}
+	if c.CORSOrigins == nil {
+		c.CORSOrigins = DefaultCORSOrigins
+	}
+	return c, nil
+}
+
+func NewAPIToken() (string, error) {
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
+
+// scopeOf returns the scope of the token a request carries. Browsers cannot
+// set headers on WebSocket and EventSource connections, so the token may
+// also come as the token query parameter.
+func (c AuthConfig) scopeOf(r *http.Request) Scope {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		return ScopeNone
+	}
+	switch {
+	case subtle.ConstantTimeCompare([]byte(token), []byte(c.AdminToken)) == 1:
+		return ScopeAdmin
+	case subtle.ConstantTimeCompare([]byte(token), []byte(c.PlayerToken)) == 1:
+		return ScopePlayer
+	case subtle.ConstantTimeCompare([]byte(token), []byte(c.SpectatorToken)) == 1:
+		return ScopeSpectator
+	default:
+		return ScopeNone
+	}
+}
+
+func (c AuthConfig) allowsOrigin(origin string) bool {
+	return slices.Contains(c.CORSOrigins, "*") || slices.Contains(c.CORSOrigins, origin)
+}
+
+type scopeKey struct{}
+
+// requestScope is the scope a request was let in with.
+func requestScope(r *http.Request) Scope {
+	scope, _ := r.Context().Value(scopeKey{}).(Scope)
+	return scope
+}
+
+// requireScope lets a request through to f only if its token has at least
+// the given scope.
+func (s *APIServer) requireScope(scope Scope, f apiFunc) http.HandlerFunc {
+	handler := makeHTTPHandlerFunc(f)
+	return func(w http.ResponseWriter, r *http.Request) {
+		got := s.auth.scopeOf(r)
+		if got == ScopeNone {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="peerpoker"`)
+			writeError(w, newAPIError(http.StatusUnauthorized, CodeUnauthorized, "missing or invalid API token"))
+			return
+		}
+		if got < scope {
+			writeError(w, newAPIError(http.StatusForbidden, CodeForbidden, "a %s token cannot do this", got))
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), scopeKey{}, got)))
+	}
+}
+
+// forSpectator strips what only the node's own player may see from the
+// table.
+func (t TableStateResponse) forSpectator() TableStateResponse {
+	t.MyHand = []CardResponse{}
+	t.ValidActions = []string{}
+	return t
+}
+
+// visibleTo returns event as a client with scope may see it, or false if it
+// may not see it at all.
+func visibleTo(scope Scope, event TableEvent) (TableEvent, bool) {
+	if scope >= ScopePlayer {
+		return event, true
+	}
+	switch data := event.Data.(type) {
+	case StateEvent:
+		data.Table = data.Table.forSpectator()
+		event.Data = data
+	case CardsRevealedEvent:
+		if data.Kind == "hole" {
+			return event, false
+		}
+	}
+	return event, true
+}
@@ -0,0 +1,239 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// eventSubscriberBuffer is how many events a subscriber may fall behind
+	// by before its backpressure policy kicks in.
+	eventSubscriberBuffer = 64
+	// eventHistorySize is how many past events are kept for clients that
+	// reconnect and want to pick up where they left off.
+	eventHistorySize = 256
+)
+
+// BackpressurePolicy says what happens to a subscriber whose buffer is full
+// when an event is published. Publishing never waits for a subscriber.
+type BackpressurePolicy int
+
+const (
+	// BackpressureDisconnect closes the subscription. It suits subscribers
+	// that can start over from a full state, like API clients.
+	BackpressureDisconnect BackpressurePolicy = iota
+	// BackpressureDropOldest throws away the oldest queued event to make
+	// room, for subscribers that care most about what is happening now.
+	BackpressureDropOldest
+	// BackpressureDropNewest throws away the new event and keeps the queue.
+	BackpressureDropNewest
+)
+
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case BackpressureDisconnect: 	return "disconnect"
+	case BackpressureDropOldest: 	return "drop-oldest"
+	case BackpressureDropNewest: 	return "drop-newest"
+	default: 						return "unknown"
+	}
+}
+
+type SubscribeOptions struct {
+	// Types limits the subscription to these events, all of them if empty.
+	Types 	[]TableEventType
+	// Buffer is how many events may queue up, eventSubscriberBuffer if 0.
+	Buffer 	int
+	Policy 	BackpressurePolicy
+}
+
+// Subscription is one subscriber's queue of events. C is closed once the
+// subscription is closed, by the subscriber or by its backpressure policy.
+type Subscription struct {
+	C 		<-chan TableEvent
+	ch 		chan TableEvent
+	bus 	*EventBus
+	types 	map[TableEventType]bool
+	policy 	BackpressurePolicy
+	dropped atomic.Uint64
+}
+
+func (s *Subscription) wants(kind TableEventType) bool {
+	return len(s.types) == 0 || s.types[kind]
+}
+
+// Dropped is how many events the subscriber missed because it fell behind.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// EventBus fans the events of a game out to whoever wants to observe them:
+// push APIs, hand history, stats and logging. The stream ID changes every
+// time the node starts, so an event ID from before a restart is never
+// mistaken for one from this run.
+type EventBus struct {
+	lock 		sync.Mutex
+	stream 		string
+	seq 		uint64
+	history 	[]TableEvent
+	subscribers map[*Subscription]struct{}
+}
+
+func NewEventBus() *EventBus {
+	stream := make([]byte, 4)
+	rand.Read(stream)
+	return &EventBus{
+		stream: 		hex.EncodeToString(stream),
+		history: 		[]TableEvent{},
+		subscribers: 	make(map[*Subscription]struct{}),
+	}
+}
+
+// EventID is the resumable ID of an event, as sent to SSE clients.
+func (b *EventBus) EventID(seq uint64) string {
+	return fmt.Sprintf("%s-%d", b.stream, seq)
+}
+
+// ParseEventID returns the sequence number of an event ID from this run.
+func (b *EventBus) ParseEventID(id string) (uint64, bool) {
+	stream, seq, ok := strings.Cut(id, "-")
+	if !ok || stream != b.stream {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(seq, 10, 64)
+	return n, err == nil
+}
+
+func (b *EventBus) Publish(kind TableEventType, data any) TableEvent {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.seq++
+	event := TableEvent{
+		Seq: 	b.seq,
+		Type: 	kind,
+		Time: 	time.Now(),
+		Data: 	data,
+	}
+	b.history = append(b.history, event)
+	if len(b.history) > eventHistorySize {
+		b.history = b.history[len(b.history)-eventHistorySize:]
+	}
+	for sub := range b.subscribers {
+		if sub.wants(kind) {
+			b.deliver(sub, event)
+		}
+	}
+	return event
+}
+
+func (b *EventBus) deliver(sub *Subscription, event TableEvent) {
+	select {
+	case sub.ch <- event:
+		return
+	default:
+	}
+	sub.dropped.Add(1)
+	switch sub.policy {
+	case BackpressureDropOldest:
+		// only the bus sends, so once one is taken out there is room
+		select {
+		case <-sub.ch:
+		default:
+		}
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	case BackpressureDropNewest:
+	default:
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Subscribe returns a subscription to every event published from now on,
+// along with the sequence number of the last event before it.
+func (b *EventBus) Subscribe(opts SubscribeOptions) (*Subscription, uint64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.add(opts), b.seq
+}
+
+// SubscribeSince subscribes a client that has seen every event up to seq and
+// returns the ones it missed. It fails, without subscribing, once some of
+// those are no longer kept, and the client has to start from a full state.
+func (b *EventBus) SubscribeSince(seq uint64, opts SubscribeOptions) (*Subscription, []TableEvent, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if seq > b.seq {
+		return nil, nil, false
+	}
+	missed := []TableEvent{}
+	if seq < b.seq {
+		if len(b.history) == 0 || b.history[0].Seq > seq+1 {
+			return nil, nil, false
+		}
+		missed = append(missed, b.history[len(b.history)-int(b.seq-seq):]...)
+	}
+	sub := b.add(opts)
+	kept := missed[:0]
+	for _, event := range missed {
+		if sub.wants(event.Type) {
+			kept = append(kept, event)
+		}
+	}
+	return sub, kept, true
+}
+
+func (b *EventBus) add(opts SubscribeOptions) *Subscription {
+	size := opts.Buffer
+	if size <= 0 {
+		size = eventSubscriberBuffer
+	}
+	ch := make(chan TableEvent, size)
+	sub := &Subscription{
+		C: 		ch,
+		ch: 	ch,
+		bus: 	b,
+		types: 	make(map[TableEventType]bool),
+		policy: opts.Policy,
+	}
+	for _, kind := range opts.Types {
+		sub.types[kind] = true
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (b *EventBus) unsubscribe(sub *Subscription) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.ch)
+	}
+}
+
+// Handle calls fn with the payload of every event on sub that carries a T,
+// until the subscription is closed. It is meant to run in a goroutine of its
+// own.
+func Handle[T any](sub *Subscription, fn func(TableEvent, T)) {
+	for event := range sub.C {
+		if data, ok := event.Data.(T); ok {
+			fn(event, data)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package p2p
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type BuyInKind byte
+
+const (
+	BuyInInitial BuyInKind = iota
+	BuyInRebuy
+	BuyInTopUp
+	BuyInAddOn
+)
+
+func (k BuyInKind) String() string {
+	switch k {
+	case BuyInInitial:
+		return "BUY-IN"
+	case BuyInRebuy:
+		return "REBUY"
+	case BuyInTopUp:
+		return "TOP-UP"
+	case BuyInAddOn:
+		return "ADD-ON"
+	default:
+		return "INVALID"
+	}
+}
+
+// LedgerEntry records chips entering a player's stack from outside of play.
+// Every node accepts the same entries because they check them against the
+// same table config, which joining nodes adopt. TxHash is the payment
+// reference the player gave. It is kept for the player's own records only:
+// the node has no chain client, so entries are not reconciled with the
+// StackTopUp events of PokerEscrow.topUpStack.
+type LedgerEntry struct {
+	Player 		string 		`json:"player"`
+	Kind 		BuyInKind 	`json:"kind"`
+	Amount 		int 		`json:"amount"`
+	StackAfter 	int 		`json:"stack_after"`
+	HandNumber 	int 		`json:"hand_number"`
+	TxHash 		string 		`json:"tx_hash,omitempty"`
+	Timestamp 	time.Time 	`json:"timestamp"`
+}
+
+type ChipLedger struct {
+	lock 	sync.RWMutex
+	entries []LedgerEntry
+}
+
+func NewChipLedger() *ChipLedger {
+	return &ChipLedger{entries: []LedgerEntry{}}
+}
+
+func (l *ChipLedger) record(entry LedgerEntry) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.entries = append(l.entries, entry)
+}
+
+func (l *ChipLedger) Entries() []LedgerEntry {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	entries := make([]LedgerEntry, len(l.entries))
+	copy(entries, l.entries)
+	return entries
+}
+
+// TotalBoughtIn is the sum of every chip a player brought to the table.
+func (l *ChipLedger) TotalBoughtIn(addr string) int {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	total := 0
+	for _, entry := range l.entries {
+		if entry.Player == addr {
+			total += entry.Amount
+		}
+	}
+	return total
+}
+
+// restateInitial changes a player's initial buy-in to amount, for a node
+// that takes the table's starting stack before it has played a hand.
+func (l *ChipLedger) restateInitial(addr string, amount int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i := range l.entries {
+		entry := &l.entries[i]
+		if entry.Player == addr && entry.Kind == BuyInInitial {
+			entry.StackAfter += amount - entry.Amount
+			entry.Amount = amount
+			return
+		}
+	}
+}
+
+func (l *ChipLedger) hasAddOn(addr string) bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	for _, entry := range l.entries {
+		if entry.Player == addr && entry.Kind == BuyInAddOn {
+			return true
+		}
+	}
+	return false
+}
+
+// BuyIn adds chips to our own stack and tells the table. Chips bought while
+// we are dealt into a running hand are held until the hand is over.
+func (g *Game) BuyIn(kind BuyInKind, amount int, txHash string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if kind == BuyInAddOn {
+		amount = g.config.AddOnChips
+	}
+	if err := g.validateBuyIn(g.listenAddr, kind, amount); err != nil {
+		return err
+	}
+	g.queueBuyIn(LedgerEntry{
+		Player: g.listenAddr,
+		Kind: 	kind,
+		Amount: amount,
+		TxHash: txHash,
+	})
+	g.sendToPlayers(MessageBuyIn{
+		Kind: 	kind,
+		Amount: amount,
+		TxHash: txHash,
+	}, g.getOtherPlayers()...)
+	return nil
+}
+
+func (g *Game) HandleBuyIn(from string, msg MessageBuyIn) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if err := g.validateBuyIn(from, msg.Kind, msg.Amount); err != nil {
+		return fmt.Errorf("rejected %s from %s: %s", msg.Kind, from, err)
+	}
+	g.queueBuyIn(LedgerEntry{
+		Player: from,
+		Kind: 	msg.Kind,
+		Amount: msg.Amount,
+		TxHash: msg.TxHash,
+	})
+	return nil
+}
+
+func (g *Game) validateBuyIn(addr string, kind BuyInKind, amount int) error {
+	state, ok := g.playerStates[addr]
+	if !ok {
+		return newRuleError(ErrUnknownPlayer, "unknown player %s", addr)
+	}
+	if amount <= 0 {
+		return newAmountError(amount, 1, g.config.MaxBuyIn, "%s amount must be positive, got: %d", kind, amount)
+	}
+	stack := state.Stack + g.pendingChips(addr)
+
+	switch kind {
+	case BuyInRebuy:
+		if stack > 0 {
+			return newRuleError(ErrIllegalAction, "rebuy is only allowed once your stack is empty, top up instead")
+		}
+		if amount < g.config.MinBuyIn || amount > g.config.MaxBuyIn {
+			return newAmountError(amount, g.config.MinBuyIn, g.config.MaxBuyIn, "rebuy must be between %d and %d", g.config.MinBuyIn, g.config.MaxBuyIn)
+		}
+	case BuyInTopUp:
+		if stack + amount > g.config.MaxBuyIn {
+			return newAmountError(amount, 1, g.config.MaxBuyIn - stack, "top-up would take your stack to %d, the maximum is %d", stack + amount, g.config.MaxBuyIn)
+		}
+	case BuyInAddOn:
+		if g.config.AddOnChips == 0 {
+			return newRuleError(ErrNotAllowed, "add-ons are not enabled at this table")
+		}
+		if g.handNumber > g.config.AddOnHands {
+			return newRuleError(ErrIllegalAction, "the add-on window closed after hand %d", g.config.AddOnHands)
+		}
+		if amount != g.config.AddOnChips {
+			return newAmountError(amount, g.config.AddOnChips, g.config.AddOnChips, "add-on must be exactly %d chips", g.config.AddOnChips)
+		}
+		if g.ledger.hasAddOn(addr) || g.hasPendingAddOn(addr) {
+			return newRuleError(ErrIllegalAction, "add-on already taken")
+		}
+	default:
+		return newRuleError(ErrInvalidRequest, "invalid buy-in kind: %s", kind)
+	}
+	return nil
+}
+
+func (g *Game) queueBuyIn(entry LedgerEntry) {
+	state := g.playerStates[entry.Player]
+	if state.InHand && g.isHandInProgress() {
+		g.pendingBuyIns = append(g.pendingBuyIns, entry)
+		logrus.Infof("Player %s %s of %d will be added after this hand", entry.Player, entry.Kind, entry.Amount)
+		return
+	}
+	g.applyBuyIn(entry)
+}
+
+func (g *Game) applyBuyIn(entry LedgerEntry) {
+	state := g.playerStates[entry.Player]
+	state.Stack += entry.Amount
+	entry.StackAfter = state.Stack
+	entry.HandNumber = g.handNumber
+	entry.Timestamp = time.Now()
+	g.ledger.record(entry)
+	logrus.Infof("Player %s %s: %d chips, stack now %d", entry.Player, entry.Kind, entry.Amount, state.Stack)
+	g.logEvent(EventBuyIn, entry.Player)
+	g.emit(TableEventBuyIn, BuyInEvent{
+		Player: entry.Player,
+		Kind: 	entry.Kind.String(),
+		Amount: entry.Amount,
+		Stack: 	state.Stack,
+	})
+}
+
+func (g *Game) applyPendingBuyIns() {
+	for _, entry := range g.pendingBuyIns {
+		if _, ok := g.playerStates[entry.Player]; ok {
+			g.applyBuyIn(entry)
+		}
+	}
+	g.pendingBuyIns = nil
+}
+
+func (g *Game) pendingChips(addr string) int {
+	total := 0
+	for _, entry := range g.pendingBuyIns {
+		if entry.Player == addr {
+			total += entry.Amount
+		}
+	}
+	return total
+}
+
+func (g *Game) hasPendingAddOn(addr string) bool {
+	for _, entry := range g.pendingBuyIns {
+		if entry.Player == addr && entry.Kind == BuyInAddOn {
+			return true
+		}
+	}
+	return false
+}
@@ -2,9 +2,22 @@ package p2p
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
 	"math/big"
 )
 
+// deckPrime is the modulus every player encrypts the deck under: the 1536
+// bit safe prime of RFC 3526 group 5. Decryption only undoes encryption, and
+// decryption proofs only hold, when the modulus is prime.
+var deckPrime, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+	"29024E088A67CC74020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B"+
+	"302B0A6DF25F14374FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B"+
+	"0BFF5CB6F406B7EDEE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D"+
+	"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD96"+
+	"1C62F356208552BB9ED529077096966D670C354E4ABC9804F1746C08CA237327"+
+	"FFFFFFFFFFFFFFFF", 16)
+
 type CardKeys struct {
 	EncryptionKey 	*big.Int
 	DecryptionKey 	*big.Int 
@@ -45,4 +58,72 @@ func (k *CardKeys) Decrypt(data []byte) []byte {
 	c := new(big.Int).SetBytes(data)
 	m := new(big.Int).Exp(c, k.DecryptionKey, k.Prime)
 	return m.Bytes()
-}
\ No newline at end of file
+}
+
+// revealGenerator is the base of the commitment to a decryption key.
+var revealGenerator = big.NewInt(2)
+
+// DecryptionProof shows that a partial decryption was made with the key
+// behind a player's commitment without giving the key away. It is a
+// Chaum-Pedersen proof that log_g(commitment) equals log_in(out), made
+// non-interactive by hashing the transcript for the challenge.
+type DecryptionProof struct {
+	T1 	[]byte
+	T2 	[]byte
+	S 	[]byte
+}
+
+// Commitment is g^d mod p for our decryption key d. Peers learn it in the
+// handshake and check our partial decryptions against it.
+func (k *CardKeys) Commitment() []byte {
+	return new(big.Int).Exp(revealGenerator, k.DecryptionKey, k.Prime).Bytes()
+}
+
+// DecryptWithProof strips our layer from data and proves we did it honestly.
+func (k *CardKeys) DecryptWithProof(data []byte) ([]byte, DecryptionProof, error) {
+	out := k.Decrypt(data)
+	order := new(big.Int).Sub(k.Prime, big.NewInt(1))
+	r, err := rand.Int(rand.Reader, order)
+	if err != nil {
+		return nil, DecryptionProof{}, err
+	}
+	x := new(big.Int).SetBytes(data)
+	t1 := new(big.Int).Exp(revealGenerator, r, k.Prime).Bytes()
+	t2 := new(big.Int).Exp(x, r, k.Prime).Bytes()
+	c := proofChallenge(order, k.Commitment(), data, out, t1, t2)
+	s := new(big.Int).Mul(c, k.DecryptionKey)
+	s.Add(s, r).Mod(s, order)
+	return out, DecryptionProof{T1: t1, T2: t2, S: s.Bytes()}, nil
+}
+
+// VerifyDecryption checks that out is in with one layer stripped by the key
+// behind commitment.
+func VerifyDecryption(prime *big.Int, commitment, in, out []byte, proof DecryptionProof) bool {
+	order := new(big.Int).Sub(prime, big.NewInt(1))
+	c := proofChallenge(order, commitment, in, out, proof.T1, proof.T2)
+	s := new(big.Int).SetBytes(proof.S)
+
+	// g^s == t1 * commitment^c
+	lhs := new(big.Int).Exp(revealGenerator, s, prime)
+	rhs := new(big.Int).Exp(new(big.Int).SetBytes(commitment), c, prime)
+	rhs.Mul(rhs, new(big.Int).SetBytes(proof.T1)).Mod(rhs, prime)
+	if lhs.Cmp(rhs) != 0 {
+		return false
+	}
+	// in^s == t2 * out^c
+	lhs.Exp(new(big.Int).SetBytes(in), s, prime)
+	rhs.Exp(new(big.Int).SetBytes(out), c, prime)
+	rhs.Mul(rhs, new(big.Int).SetBytes(proof.T2)).Mod(rhs, prime)
+	return lhs.Cmp(rhs) == 0
+}
+
+func proofChallenge(order *big.Int, parts ...[]byte) *big.Int {
+	h := sha256.New()
+	for _, part := range parts {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(part)))
+		h.Write(size[:])
+		h.Write(part)
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(h.Sum(nil)), order)
+}
@@ -0,0 +1,168 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Errors the game returns when a request breaks the rules of the table. They
+// come wrapped with the specifics, so compare them with errors.Is.
+var (
+	ErrNotYourTurn 		= errors.New("not your turn")
+	ErrIllegalAction 	= errors.New("illegal action")
+	ErrAmountTooSmall 	= errors.New("amount too small")
+	ErrAmountTooLarge 	= errors.New("amount too large")
+	ErrUnknownPlayer 	= errors.New("unknown player")
+	ErrInvalidSeat 		= errors.New("invalid seat")
+	ErrSeatTaken 		= errors.New("seat taken")
+	ErrNotSeated 		= errors.New("not seated")
+	ErrHandInProgress 	= errors.New("hand in progress")
+	ErrNotAllowed 		= errors.New("not allowed at this table")
+	ErrInvalidRequest 	= errors.New("invalid request")
+	ErrStaleAction 		= errors.New("stale action")
+	ErrRequestIDReused 	= errors.New("request id reused")
+)
+
+// ruleError is one of the errors above with a message for the player.
+type ruleError struct {
+	kind 	error
+	msg 	string
+}
+
+func (e *ruleError) Error() string { return e.msg }
+func (e *ruleError) Unwrap() error { return e.kind }
+
+func newRuleError(kind error, format string, args ...any) error {
+	return &ruleError{kind: kind, msg: fmt.Sprintf(format, args...)}
+}
+
+// AmountError is a bet, raise or buy-in outside the range the table allows
+// right now. It matches ErrAmountTooSmall or ErrAmountTooLarge.
+type AmountError struct {
+	Amount 	int
+	Min 	int
+	Max 	int
+	msg 	string
+}
+
+func (e *AmountError) Error() string { return e.msg }
+
+func (e *AmountError) Unwrap() error {
+	if e.Amount < e.Min {
+		return ErrAmountTooSmall
+	}
+	return ErrAmountTooLarge
+}
+
+func newAmountError(amount, min, max int, format string, args ...any) error {
+	return &AmountError{
+		Amount: amount,
+		Min: 	min,
+		Max: 	max,
+		msg: 	fmt.Sprintf(format, args...),
+	}
+}
+
+// ErrorCode is the stable, machine readable name of an API error. Messages
+// may change, codes do not.
+type ErrorCode string
+
+const (
+	CodeInvalidJSON 	ErrorCode = "invalid_json"
+	CodeInvalidRequest 	ErrorCode = "invalid_request"
+	CodeUnauthorized 	ErrorCode = "unauthorized"
+	CodeForbidden 		ErrorCode = "forbidden"
+	CodeNotFound 		ErrorCode = "not_found"
+	CodeNotYourTurn 	ErrorCode = "not_your_turn"
+	CodeIllegalAction 	ErrorCode = "illegal_action"
+	CodeSeatTaken 		ErrorCode = "seat_taken"
+	CodeNotSeated 		ErrorCode = "not_seated"
+	CodeHandInProgress 	ErrorCode = "hand_in_progress"
+	CodeAmountTooSmall 	ErrorCode = "amount_too_small"
+	CodeAmountTooLarge 	ErrorCode = "amount_too_large"
+	CodeInvalidSeat 	ErrorCode = "invalid_seat"
+	CodeNotAllowed 		ErrorCode = "not_allowed"
+	CodeStaleAction 	ErrorCode = "stale_action"
+	CodeRequestIDReused ErrorCode = "request_id_reused"
+	CodePeerUnreachable ErrorCode = "peer_unreachable"
+	CodePeerTimeout 	ErrorCode = "peer_timeout"
+	CodeInternal 		ErrorCode = "internal"
+)
+
+// APIError is the body of every failed API request. Error is a message for
+// people, Code is what clients should branch on.
+type APIError struct {
+	Status 	int 			`json:"-"`
+	Code 	ErrorCode 		`json:"code"`
+	Message string 			`json:"error"`
+	Details map[string]any 	`json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string { return e.Message }
+
+func newAPIError(status int, code ErrorCode, format string, args ...any) *APIError {
+	return &APIError{
+		Status: 	status,
+		Code: 		code,
+		Message: 	fmt.Sprintf(format, args...),
+	}
+}
+
+func invalidJSON(err error) *APIError {
+	return newAPIError(http.StatusBadRequest, CodeInvalidJSON, "invalid request body: %s", err)
+}
+
+var apiErrorCodes = []struct {
+	err 	error
+	status 	int
+	code 	ErrorCode
+}{
+	{ErrInvalidRequest, http.StatusBadRequest, CodeInvalidRequest},
+	{ErrNotFound, http.StatusNotFound, CodeNotFound},
+	{ErrUnknownPlayer, http.StatusNotFound, CodeNotFound},
+	{ErrNotYourTurn, http.StatusConflict, CodeNotYourTurn},
+	{ErrIllegalAction, http.StatusConflict, CodeIllegalAction},
+	{ErrSeatTaken, http.StatusConflict, CodeSeatTaken},
+	{ErrNotSeated, http.StatusConflict, CodeNotSeated},
+	{ErrHandInProgress, http.StatusConflict, CodeHandInProgress},
+	{ErrStaleAction, http.StatusConflict, CodeStaleAction},
+	{ErrRequestIDReused, http.StatusUnprocessableEntity, CodeRequestIDReused},
+	{ErrAmountTooSmall, http.StatusUnprocessableEntity, CodeAmountTooSmall},
+	{ErrAmountTooLarge, http.StatusUnprocessableEntity, CodeAmountTooLarge},
+	{ErrInvalidSeat, http.StatusUnprocessableEntity, CodeInvalidSeat},
+	{ErrNotAllowed, http.StatusUnprocessableEntity, CodeNotAllowed},
+}
+
+// toAPIError maps an error from a handler to the response it gets. Errors
+// that are not one of ours are a fault of the node, not the request.
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	for _, known := range apiErrorCodes {
+		if !errors.Is(err, known.err) {
+			continue
+		}
+		resp := newAPIError(known.status, known.code, "%s", err)
+		var amount *AmountError
+		if errors.As(err, &amount) {
+			resp.Details = map[string]any{
+				"amount": 	amount.Amount,
+				"min": 		amount.Min,
+				"max": 		amount.Max,
+			}
+		}
+		return resp
+	}
+	logrus.Errorf("API request failed: %s", err)
+	return newAPIError(http.StatusInternalServerError, CodeInternal, "%s", err)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	apiErr := toAPIError(err)
+	JSON(w, apiErr.Status, apiErr)
+}
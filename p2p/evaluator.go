@@ -18,7 +18,7 @@ func EvaluateBestHand(hole []Card, community []Card) (int32, string) {
 
 func translateToLibCard(c Card) poker.Card {
 	rankMap := map[int]string{
-		1: "a", 10: "t", 11: "j", 12: "q", 13: "k",
+		1: "A", 10: "T", 11: "J", 12: "Q", 13: "K",
 	}
 	suitMap := map[Suit]string{
 		Spades: "s", Hearts: "h", Diamonds: "d", Clubs: "c",
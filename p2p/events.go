@@ -0,0 +1,192 @@
+package p2p
+
+import (
+	"time"
+)
+
+type TableEventType string
+
+const (
+	TableEventState 		TableEventType = "state"
+	TableEventPlayerJoined 	TableEventType = "player_joined"
+	TableEventPlayerLeft 	TableEventType = "player_left"
+	TableEventHandStarted 	TableEventType = "hand_started"
+	TableEventActionTaken 	TableEventType = "action_taken"
+	TableEventStreetChanged TableEventType = "street_changed"
+	TableEventCardsRevealed TableEventType = "cards_revealed"
+	TableEventPotAwarded 	TableEventType = "pot_awarded"
+	TableEventTurnChanged 	TableEventType = "turn_changed"
+	TableEventHandComplete 	TableEventType = "hand_complete"
+	TableEventPlayerReady 	TableEventType = "player_ready"
+	TableEventSeatChanged 	TableEventType = "seat_changed"
+	TableEventBuyIn 		TableEventType = "buy_in"
+	TableEventStatusChanged TableEventType = "status_changed"
+	TableEventControl 		TableEventType = "control"
+	// TableEventHandRecorded carries the finished *HandHistory and is only
+	// for observers inside the node.
+	TableEventHandRecorded 	TableEventType = "hand_recorded"
+)
+
+// publicEventTypes are the events pushed to API clients.
+var publicEventTypes = []TableEventType{
+	TableEventPlayerJoined,
+	TableEventPlayerLeft,
+	TableEventHandStarted,
+	TableEventActionTaken,
+	TableEventStreetChanged,
+	TableEventCardsRevealed,
+	TableEventPotAwarded,
+	TableEventTurnChanged,
+	TableEventHandComplete,
+	TableEventPlayerReady,
+	TableEventSeatChanged,
+	TableEventBuyIn,
+	TableEventStatusChanged,
+	TableEventControl,
+}
+
+// TableEvent is something that happened at the table, as published on the
+// game's event bus and pushed to API clients. Seq numbers every event the node has published, so a client can
+// tell whether it missed any.
+type TableEvent struct {
+	Seq 	uint64 			`json:"seq"`
+	Type 	TableEventType 	`json:"type"`
+	Time 	time.Time 		`json:"time"`
+	Data 	any 			`json:"data"`
+}
+
+type StateEvent struct {
+	Table 	TableStateResponse 	`json:"table"`
+	Players PlayerResponse 		`json:"players"`
+}
+
+type PlayerEvent struct {
+	Player 	string 	`json:"player"`
+	Seat 	int 	`json:"seat"`
+}
+
+type HandStartedEvent struct {
+	HandNumber 	int `json:"hand_number"`
+	DealerID 	int `json:"dealer_id"`
+}
+
+type ActionEvent struct {
+	Player 	string 	`json:"player"`
+	Seat 	int 	`json:"seat"`
+	Action 	string 	`json:"action"`
+	Bet 	int 	`json:"bet"`
+	Stack 	int 	`json:"stack"`
+	Pot 	int 	`json:"pot"`
+	AllIn 	bool 	`json:"all_in"`
+}
+
+type StreetEvent struct {
+	Street 	string `json:"street"`
+}
+
+// CardsRevealedEvent is cards turned face up. Kind is "hole" for our own
+// hole cards, "board" for community cards and "shown" for a player showing
+// their hand at showdown.
+type CardsRevealedEvent struct {
+	Kind 	string 			`json:"kind"`
+	Player 	string 			`json:"player,omitempty"`
+	Cards 	[]CardResponse 	`json:"cards"`
+}
+
+type PotAwardedEvent struct {
+	Player 	string 	`json:"player"`
+	Amount 	int 	`json:"amount"`
+	Pot 	string 	`json:"pot"`
+}
+
+type TurnEvent struct {
+	Player 	string 	`json:"player"`
+	Seat 	int 	`json:"seat"`
+}
+
+type HandCompleteEvent struct {
+	HandNumber int `json:"hand_number"`
+}
+
+// SeatEvent is a player taking a seat, or leaving it when Seated is false.
+type SeatEvent struct {
+	Player 	string 	`json:"player"`
+	Seat 	int 	`json:"seat"`
+	Seated 	bool 	`json:"seated"`
+}
+
+type BuyInEvent struct {
+	Player 	string 	`json:"player"`
+	Kind 	string 	`json:"kind"`
+	Amount 	int 	`json:"amount"`
+	Stack 	int 	`json:"stack"`
+}
+
+type StatusEvent struct {
+	From 	string `json:"from"`
+	Status 	string `json:"status"`
+}
+
+// ControlEvent is a control command that was proposed, approved by one more
+// player, or applied. Player is the address of the Target, if they are at
+// the table. Pending is set on a config change held until the hand ends.
+type ControlEvent struct {
+	ID 			string 				`json:"id"`
+	Kind 		ControlKind 		`json:"kind"`
+	Target 		string 				`json:"target,omitempty"`
+	Player 		string 				`json:"player,omitempty"`
+	IssuedBy 	string 				`json:"issued_by"`
+	Config 		*TableConfigChange 	`json:"config,omitempty"`
+	Signers 	[]string 			`json:"signers"`
+	Needed 		int 				`json:"needed"`
+	Applied 	bool 				`json:"applied"`
+	Pending 	bool 				`json:"pending,omitempty"`
+	Refunds 	map[string]int 		`json:"refunds,omitempty"`
+}
+
+// Events is the bus the game publishes every state transition on.
+func (g *Game) Events() *EventBus {
+	return g.events
+}
+
+// emit publishes a table event. Detached games, such as replays, have no
+// bus and publish nothing.
+func (g *Game) emit(kind TableEventType, data any) {
+	if g.events == nil {
+		return
+	}
+	g.events.Publish(kind, data)
+}
+
+// stateEvent is the full state frame a push channel starts with, numbered
+// with the last event it reflects. The caller must hold the game lock.
+func (g *Game) stateEvent(seq uint64) TableEvent {
+	return TableEvent{
+		Seq: 	seq,
+		Type: 	TableEventState,
+		Time: 	time.Now(),
+		Data: 	StateEvent{Table: g.tableState(), Players: g.playersState()},
+	}
+}
+
+// setTurn hands the turn to a seat and tells API clients about it.
+func (g *Game) setTurn(seat int) {
+	g.currentPlayerTurnID = seat
+	g.turnNumber++
+	g.emit(TableEventTurnChanged, TurnEvent{
+		Player: g.rotationMap[seat],
+		Seat: 	seat,
+	})
+}
+
+func cardResponses(cards []Card) []CardResponse {
+	resp := make([]CardResponse, len(cards))
+	for i, card := range cards {
+		resp[i] = CardResponse{
+			Suit: card.Suit.String(),
+			Value: card.Value,
+			Display: card.String(),
+		}
+	}
+	return resp
+}
@@ -0,0 +1,470 @@
+package p2p
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	exportSiteName = "PeerPoker"
+	ohhSpecVersion = "1.4.6"
+)
+
+// pokerStarsCard writes a card the way hand histories do, e.g. "Ah" or "Tc".
+func pokerStarsCard(c Card) string {
+	rank := map[int]string{1: "A", 10: "T", 11: "J", 12: "Q", 13: "K"}[c.Value]
+	if rank == "" {
+		rank = strconv.Itoa(c.Value)
+	}
+	return rank + []string{"s", "h", "d", "c"}[c.Suit]
+}
+
+func pokerStarsCards(cards []Card) string {
+	parts := make([]string, len(cards))
+	for i, card := range cards {
+		parts[i] = pokerStarsCard(card)
+	}
+	return "[" + strings.Join(parts, " ") + "]"
+}
+
+var pokerStarsRunNames = []string{"FIRST", "SECOND", "THIRD", "FOURTH", "FIFTH"}
+
+func pokerStarsRunName(run int) string {
+	if run < len(pokerStarsRunNames) {
+		return pokerStarsRunNames[run]
+	}
+	return fmt.Sprintf("RUN %d", run+1)
+}
+
+// pokerStarsStreet writes the header of a street, e.g. "*** TURN *** [..] [..]".
+func pokerStarsStreet(b *strings.Builder, prefix string, street GameStatus, board []Card) {
+	switch street {
+	case GameStatusFlop:
+		fmt.Fprintf(b, "*** %sFLOP *** %s\n", prefix, pokerStarsCards(board[:3]))
+	case GameStatusTurn:
+		fmt.Fprintf(b, "*** %sTURN *** %s %s\n", prefix, pokerStarsCards(board[:3]), pokerStarsCards(board[3:4]))
+	case GameStatusRiver:
+		fmt.Fprintf(b, "*** %sRIVER *** %s %s\n", prefix, pokerStarsCards(board[:4]), pokerStarsCards(board[4:5]))
+	}
+}
+
+// streetCards is how many board cards are out on a street.
+func streetCards(street GameStatus) int {
+	switch street {
+	case GameStatusFlop:
+		return 3
+	case GameStatusTurn:
+		return 4
+	case GameStatusRiver:
+		return 5
+	}
+	return 0
+}
+
+func parseStreet(s string) GameStatus {
+	for status := GameStatusWaiting; status <= GameStatusHandComplete; status++ {
+		if status.String() == s {
+			return status
+		}
+	}
+	return GameStatusPreFlop
+}
+
+// FormatPokerStars converts a recorded hand into PokerStars text format so
+// it can be imported into hand trackers. Seats are numbered from one.
+func FormatPokerStars(hand *HandHistory) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "PokerStars Hand #%d: Hold'em No Limit (%d/%d) - %s UTC\n",
+		hand.ID, hand.SmallBlind, hand.BigBlind, hand.StartedAt.UTC().Format("2006/01/02 15:04:05"))
+	fmt.Fprintf(b, "Table '%s %s' %d-max Seat #%d is the button\n",
+		exportSiteName, hand.SessionID, max(hand.MaxSeats, len(hand.Seats)), hand.ButtonSeat+1)
+	for _, seat := range hand.Seats {
+		fmt.Fprintf(b, "Seat %d: %s (%d in chips)\n", seat.Seat+1, seat.Player, seat.Stack)
+	}
+
+	folded := make(map[string]GameStatus)
+	street := GameStatusPreFlop
+	highestBet := 0
+	holeCardsShown := false
+	for _, action := range hand.Actions {
+		actionStreet := parseStreet(action.Street)
+		if actionStreet != street {
+			street = actionStreet
+			highestBet = 0
+			if len(hand.Board) >= streetCards(street) {
+				pokerStarsStreet(b, "", street, hand.Board)
+			}
+		}
+		if !holeCardsShown && !isBlindPost(action.Kind) {
+			writeHoleCards(b, hand)
+			holeCardsShown = true
+		}
+		allIn := ""
+		if action.AllIn {
+			allIn = " and is all-in"
+		}
+		switch action.Kind {
+		case HistoryPostSmallBlind:
+			fmt.Fprintf(b, "%s: posts small blind %d%s\n", action.Player, action.Amount, allIn)
+		case HistoryPostBigBlind:
+			fmt.Fprintf(b, "%s: posts big blind %d%s\n", action.Player, action.Amount, allIn)
+		case HistoryPostDead:
+			fmt.Fprintf(b, "%s: posts small blind %d (dead)\n", action.Player, action.Amount)
+		case HistoryPostStraddle:
+			fmt.Fprintf(b, "%s: posts straddle %d%s\n", action.Player, action.Amount, allIn)
+		case HistoryFold:
+			fmt.Fprintf(b, "%s: folds\n", action.Player)
+			folded[action.Player] = street
+		case HistoryCheck:
+			fmt.Fprintf(b, "%s: checks\n", action.Player)
+		case HistoryCall:
+			fmt.Fprintf(b, "%s: calls %d%s\n", action.Player, action.Amount, allIn)
+		case HistoryBet:
+			fmt.Fprintf(b, "%s: bets %d%s\n", action.Player, action.Amount, allIn)
+		case HistoryRaise:
+			fmt.Fprintf(b, "%s: raises %d to %d%s\n", action.Player, action.RaisedTo-highestBet, action.RaisedTo, allIn)
+		}
+		highestBet = max(highestBet, action.RaisedTo)
+	}
+	if !holeCardsShown {
+		writeHoleCards(b, hand)
+	}
+
+	// streets nobody acted on were run out after an all-in
+	boards := [][]Card{hand.Board}
+	if len(hand.Runs) > 1 {
+		boards = make([][]Card, len(hand.Runs))
+		for i, run := range hand.Runs {
+			boards[i] = run.Board
+		}
+	}
+	for run, board := range boards {
+		prefix := ""
+		if len(boards) > 1 {
+			prefix = pokerStarsRunName(run) + " "
+		}
+		for next := street + 1; next <= GameStatusRiver && streetCards(next) <= len(board); next++ {
+			pokerStarsStreet(b, prefix, next, board)
+		}
+	}
+
+	if len(hand.ShownHands) > 0 || len(hand.Mucked) > 0 {
+		b.WriteString("*** SHOW DOWN ***\n")
+		for _, seat := range hand.Seats {
+			if cards, ok := hand.ShownHands[seat.Player]; ok {
+				if _, didFold := folded[seat.Player]; didFold || len(cards) < 2 || len(hand.Board) < 5 {
+					fmt.Fprintf(b, "%s: shows %s\n", seat.Player, pokerStarsCards(cards))
+					continue
+				}
+				_, handName := EvaluateBestHand(append([]Card{}, cards...), hand.Board)
+				fmt.Fprintf(b, "%s: shows %s (%s)\n", seat.Player, pokerStarsCards(cards), handName)
+			}
+		}
+		for _, addr := range hand.Mucked {
+			fmt.Fprintf(b, "%s: mucks hand\n", addr)
+		}
+	}
+	for _, seat := range hand.Seats {
+		if won := hand.Collected[seat.Player]; won > 0 {
+			fmt.Fprintf(b, "%s collected %d from pot\n", seat.Player, won)
+		}
+	}
+
+	b.WriteString("*** SUMMARY ***\n")
+	fmt.Fprintf(b, "Total pot %d", hand.Pot)
+	if len(hand.Pots) > 1 {
+		fmt.Fprintf(b, " Main pot %d.", hand.Pots[0].Amount)
+		for i, pot := range hand.Pots[1:] {
+			fmt.Fprintf(b, " Side pot-%d %d.", i+1, pot.Amount)
+		}
+	}
+	b.WriteString(" | Rake 0\n")
+	for run, board := range boards {
+		if len(board) == 0 {
+			continue
+		}
+		if len(boards) > 1 {
+			fmt.Fprintf(b, "%s Board %s\n", pokerStarsRunName(run), pokerStarsCards(board))
+		} else {
+			fmt.Fprintf(b, "Board %s\n", pokerStarsCards(board))
+		}
+	}
+	for _, seat := range hand.Seats {
+		fmt.Fprintf(b, "Seat %d: %s%s %s\n", seat.Seat+1, seat.Player, seatRole(hand, seat.Seat), seatOutcome(hand, seat.Player, folded))
+	}
+	return b.String()
+}
+
+func isBlindPost(kind HistoryActionKind) bool {
+	switch kind {
+	case HistoryPostSmallBlind, HistoryPostBigBlind, HistoryPostDead, HistoryPostStraddle:
+		return true
+	}
+	return false
+}
+
+func writeHoleCards(b *strings.Builder, hand *HandHistory) {
+	b.WriteString("*** HOLE CARDS ***\n")
+	if len(hand.HeroCards) == 2 {
+		fmt.Fprintf(b, "Dealt to %s %s\n", hand.Hero, pokerStarsCards(hand.HeroCards))
+	}
+}
+
+func seatRole(hand *HandHistory, seat int) string {
+	role := ""
+	if seat == hand.ButtonSeat {
+		role += " (button)"
+	}
+	for _, action := range hand.Actions {
+		if action.Seat != seat {
+			continue
+		}
+		switch action.Kind {
+		case HistoryPostSmallBlind:
+			role += " (small blind)"
+		case HistoryPostBigBlind:
+			role += " (big blind)"
+		}
+	}
+	return role
+}
+
+func seatOutcome(hand *HandHistory, addr string, folded map[string]GameStatus) string {
+	won := hand.Collected[addr]
+	if street, ok := folded[addr]; ok {
+		if street == GameStatusPreFlop {
+			return "folded before Flop"
+		}
+		return "folded on the " + ohhStreetNames[street]
+	}
+	if cards, ok := hand.ShownHands[addr]; ok {
+		if won > 0 {
+			return fmt.Sprintf("showed %s and won (%d)", pokerStarsCards(cards), won)
+		}
+		return fmt.Sprintf("showed %s and lost", pokerStarsCards(cards))
+	}
+	for _, mucked := range hand.Mucked {
+		if mucked == addr {
+			return "mucked"
+		}
+	}
+	if won > 0 {
+		return fmt.Sprintf("collected (%d)", won)
+	}
+	return "lost"
+}
+
+// ExportPokerStars writes hands one after another in PokerStars format,
+// separated by blank lines as the trackers expect.
+func ExportPokerStars(w io.Writer, hands []*HandHistory) error {
+	for _, hand := range hands {
+		if _, err := io.WriteString(w, FormatPokerStars(hand)+"\n\n\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenHandHistory is a hand in the Open Hand History JSON standard.
+type OpenHandHistory struct {
+	OHH OHHHand `json:"ohh"`
+}
+
+type OHHHand struct {
+	SpecVersion 		string 			`json:"spec_version"`
+	SiteName 			string 			`json:"site_name"`
+	NetworkName 		string 			`json:"network_name"`
+	InternalVersion 	string 			`json:"internal_version"`
+	GameNumber 			string 			`json:"game_number"`
+	StartDateUTC 		string 			`json:"start_date_utc"`
+	TableName 			string 			`json:"table_name"`
+	TableSize 			int 			`json:"table_size"`
+	GameType 			string 			`json:"game_type"`
+	BetLimit 			OHHBetLimit 	`json:"bet_limit"`
+	DealerSeat 			int 			`json:"dealer_seat"`
+	SmallBlindAmount 	int 			`json:"small_blind_amount"`
+	BigBlindAmount 		int 			`json:"big_blind_amount"`
+	AnteAmount 			int 			`json:"ante_amount"`
+	HeroPlayerID 		int 			`json:"hero_player_id"`
+	Players 			[]OHHPlayer 	`json:"players"`
+	Rounds 				[]OHHRound 		`json:"rounds"`
+	Pots 				[]OHHPot 		`json:"pots"`
+}
+
+type OHHBetLimit struct {
+	BetType string 	`json:"bet_type"`
+	BetCap 	int 	`json:"bet_cap"`
+}
+
+type OHHPlayer struct {
+	ID 				int 	`json:"id"`
+	Seat 			int 	`json:"seat"`
+	Name 			string 	`json:"name"`
+	Display 		string 	`json:"display"`
+	StartingStack 	int 	`json:"starting_stack"`
+}
+
+type OHHRound struct {
+	ID 		int 			`json:"id"`
+	Street 	string 			`json:"street"`
+	Cards 	[]string 		`json:"cards,omitempty"`
+	Actions []OHHAction 	`json:"actions"`
+}
+
+type OHHAction struct {
+	ActionNumber 	int 		`json:"action_number"`
+	PlayerID 		int 		`json:"player_id"`
+	Action 			string 		`json:"action"`
+	Amount 			int 		`json:"amount"`
+	IsAllIn 		bool 		`json:"is_allin"`
+	Cards 			[]string 	`json:"cards,omitempty"`
+}
+
+type OHHPot struct {
+	Number 		int 			`json:"number"`
+	Amount 		int 			`json:"amount"`
+	Rake 		int 			`json:"rake"`
+	PlayerWins 	[]OHHPlayerWin 	`json:"player_wins"`
+}
+
+type OHHPlayerWin struct {
+	PlayerID 		int `json:"player_id"`
+	WinAmount 		int `json:"win_amount"`
+	ContributedRake int `json:"contributed_rake"`
+}
+
+var ohhActionNames = map[HistoryActionKind]string{
+	HistoryPostSmallBlind: 	"Post SB",
+	HistoryPostBigBlind: 	"Post BB",
+	HistoryPostDead: 		"Post Dead",
+	HistoryPostStraddle: 	"Straddle",
+	HistoryFold: 			"Fold",
+	HistoryCheck: 			"Check",
+	HistoryCall: 			"Call",
+	HistoryBet: 			"Bet",
+	HistoryRaise: 			"Raise",
+}
+
+var ohhStreetNames = map[GameStatus]string{
+	GameStatusPreFlop: 	"Preflop",
+	GameStatusFlop: 	"Flop",
+	GameStatusTurn: 	"Turn",
+	GameStatusRiver: 	"River",
+	GameStatusShowdown: "Showdown",
+}
+
+func ohhCards(cards []Card) []string {
+	out := make([]string, len(cards))
+	for i, card := range cards {
+		out[i] = pokerStarsCard(card)
+	}
+	return out
+}
+
+// ToOpenHandHistory converts a recorded hand into the Open Hand History
+// format. Player ids are the seat numbers counted from one.
+func ToOpenHandHistory(hand *HandHistory) OpenHandHistory {
+	ohh := OHHHand{
+		SpecVersion: 		ohhSpecVersion,
+		SiteName: 			exportSiteName,
+		NetworkName: 		exportSiteName,
+		InternalVersion: 	"1",
+		GameNumber: 		strconv.FormatInt(hand.ID, 10),
+		StartDateUTC: 		hand.StartedAt.UTC().Format("2006-01-02T15:04:05Z"),
+		TableName: 			fmt.Sprintf("%s %s", exportSiteName, hand.SessionID),
+		TableSize: 			max(hand.MaxSeats, len(hand.Seats)),
+		GameType: 			"Holdem",
+		BetLimit: 			OHHBetLimit{BetType: "NL"},
+		DealerSeat: 		hand.ButtonSeat + 1,
+		SmallBlindAmount: 	hand.SmallBlind,
+		BigBlindAmount: 	hand.BigBlind,
+		HeroPlayerID: 		hand.seatOf(hand.Hero) + 1,
+		Players: 			[]OHHPlayer{},
+		Rounds: 			[]OHHRound{},
+		Pots: 				[]OHHPot{},
+	}
+	for _, seat := range hand.Seats {
+		ohh.Players = append(ohh.Players, OHHPlayer{
+			ID: 			seat.Seat + 1,
+			Seat: 			seat.Seat + 1,
+			Name: 			seat.Player,
+			Display: 		seat.Player,
+			StartingStack: 	seat.Stack,
+		})
+	}
+
+	actionNumber := 0
+	newRound := func(street GameStatus) *OHHRound {
+		round := OHHRound{ID: len(ohh.Rounds), Street: ohhStreetNames[street], Actions: []OHHAction{}}
+		if n := streetCards(street); n > 0 && len(hand.Board) >= n {
+			first := n - 1
+			if street == GameStatusFlop {
+				first = 0
+			}
+			round.Cards = ohhCards(hand.Board[first:n])
+		}
+		ohh.Rounds = append(ohh.Rounds, round)
+		return &ohh.Rounds[len(ohh.Rounds)-1]
+	}
+	round := newRound(GameStatusPreFlop)
+	if len(hand.HeroCards) == 2 {
+		actionNumber++
+		round.Actions = append(round.Actions, OHHAction{
+			ActionNumber: 	actionNumber,
+			PlayerID: 		ohh.HeroPlayerID,
+			Action: 		"Dealt Cards",
+			Cards: 			ohhCards(hand.HeroCards),
+		})
+	}
+	street := GameStatusPreFlop
+	for _, action := range hand.Actions {
+		if s := parseStreet(action.Street); s != street {
+			street = s
+			round = newRound(street)
+		}
+		actionNumber++
+		round.Actions = append(round.Actions, OHHAction{
+			ActionNumber: 	actionNumber,
+			PlayerID: 		action.Seat + 1,
+			Action: 		ohhActionNames[action.Kind],
+			Amount: 		action.Amount,
+			IsAllIn: 		action.AllIn,
+		})
+	}
+	for next := street + 1; next <= GameStatusRiver && streetCards(next) <= len(hand.Board); next++ {
+		newRound(next)
+	}
+	if len(hand.ShownHands) > 0 || len(hand.Mucked) > 0 {
+		round = newRound(GameStatusShowdown)
+		for _, seat := range hand.Seats {
+			if cards, ok := hand.ShownHands[seat.Player]; ok {
+				actionNumber++
+				round.Actions = append(round.Actions, OHHAction{
+					ActionNumber: 	actionNumber,
+					PlayerID: 		seat.Seat + 1,
+					Action: 		"Shows Cards",
+					Cards: 			ohhCards(cards),
+				})
+			}
+		}
+		for _, addr := range hand.Mucked {
+			actionNumber++
+			round.Actions = append(round.Actions, OHHAction{
+				ActionNumber: 	actionNumber,
+				PlayerID: 		hand.seatOf(addr) + 1,
+				Action: 		"Mucks Cards",
+			})
+		}
+	}
+
+	pot := OHHPot{Amount: hand.Pot, PlayerWins: []OHHPlayerWin{}}
+	for _, seat := range hand.Seats {
+		if won := hand.Collected[seat.Player]; won > 0 {
+			pot.PlayerWins = append(pot.PlayerWins, OHHPlayerWin{PlayerID: seat.Seat + 1, WinAmount: won})
+		}
+	}
+	ohh.Pots = append(ohh.Pots, pot)
+	return OpenHandHistory{OHH: ohh}
+}
@@ -1,7 +1,9 @@
 package p2p
 
 import (
+	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/json"
 	"fmt"
 	"math/big"
 	"sort"
@@ -12,11 +14,61 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// SmallBlind and BigBlind are the blinds of a table that does not set its
+// own.
 const (
 	SmallBlind = 10
 	BigBlind = 20
+	defaultStartingStack = 1000
+	defaultMinBuyIn = 20 * BigBlind
+	defaultMaxBuyIn = 100 * BigBlind
 )
 
+type TableConfig struct {
+	MaxSeats 		int
+	MaxWaitList 	int
+	SmallBlind 		int
+	BigBlind 		int
+	StartingStack 	int
+	MinBuyIn 		int
+	MaxBuyIn 		int
+	// AddOnChips is the fixed add-on a tournament player may buy once while
+	// the hand number is at most AddOnHands. Zero disables add-ons.
+	AddOnChips 		int
+	AddOnHands 		int
+	AllowStraddle 		bool
+	AllowButtonStraddle bool
+	// MaxRuns caps how many times an all-in board may be run. One or less
+	// turns run-it-twice off.
+	MaxRuns 			int
+	AllowRabbitHunt 	bool
+}
+
+func (c TableConfig) withDefaults() TableConfig {
+	if c.MaxSeats == 0 {
+		c.MaxSeats = defaultMaxPlayers
+	}
+	if c.MaxWaitList == 0 {
+		c.MaxWaitList = defaultMaxWaitList
+	}
+	if c.SmallBlind == 0 {
+		c.SmallBlind = SmallBlind
+	}
+	if c.BigBlind == 0 {
+		c.BigBlind = BigBlind
+	}
+	if c.StartingStack == 0 {
+		c.StartingStack = defaultStartingStack
+	}
+	if c.MinBuyIn == 0 {
+		c.MinBuyIn = defaultMinBuyIn
+	}
+	if c.MaxBuyIn == 0 {
+		c.MaxBuyIn = defaultMaxBuyIn
+	}
+	return c
+}
+
 type PlayerHand struct {
 	Addr 		string 
 	Hand 		[]Card 
@@ -106,6 +158,15 @@ type PlayerState struct {
 	IsAllIn 			bool 
 	Stack 				int
 	TotalBetThisHand 	int
+	HasSeat 			bool
+	InHand 				bool
+	MissedSmallBlind 	bool
+	MissedBigBlind 		bool
+	// SeatClaimedAt and WaitingSince are the claimant's own timestamps, in
+	// Unix nanoseconds. Every node orders competing claims by them so the
+	// seats and the waiting list come out the same everywhere.
+	SeatClaimedAt 		int64
+	WaitingSince 		int64
 }
 
 type Game struct {
@@ -113,101 +174,148 @@ type Game struct {
 	listenAddr 			string 
 	broadcastch 		chan BroadcastTo
 	playersList 		*PlayersList
+	config 				TableConfig
+	waitingList 		[]string
+	handNumber 			int
+	ledger 				*ChipLedger
+	pendingBuyIns 		[]LedgerEntry
+	straddles 			map[string]StraddleKind
+	straddleAmount 		int
+	runOut 				bool
+	runOutFrom 			int
+	runItPending 		bool
+	runItVotes 			map[string]int
+	runItTimes 			int
+	runResults 			[]RunResult
+	lastAggressorID 	int
+	showOrder 			[]string
+	shownCards 			map[string][]Card
+	mucked 				map[string]bool
+	revealedCards 		map[int]Card
+	showInFlight 		bool
+	showDecisionPending bool
+	resolving 			bool
+	finishedHand 		*finishedHand
+	rabbitVotes 		map[string]bool
+	rabbitHunt 			*RabbitHunt
+	history 			*HandHistory
+	handHistory 		*HandHistoryStore
+	sessionID 			string
+	wal 				*EventLog
+	// loggedFields is the state as of the last event or snapshot, which
+	// the next event records its changes against.
+	loggedFields 		map[string]json.RawMessage
+	store 				Storage
+	eventsSinceSnapshot int
+	keys 				*Keystore
+	events 				*EventBus
+	deckKeyID 			string
 	currentStatus 		*AtomicInt
 	currentPot 			int 
 	playerStates 		map[string]*PlayerState
 	rotationMap 		map[int]string 
 	nextRotationID 		int 
+	// handSeats is nextRotationID as it was when the hand was dealt. Deck
+	// positions are laid out from it, so a seat taken mid-hand cannot move
+	// the board.
+	handSeats 			int
 	currentDealerID 	int 
+	smallBlindID 		int
+	bigBlindID 			int
 	currentPlayerTurnID int 
+	// turnNumber counts the turns of the current hand
+	turnNumber 			int
+	actionLog 			*actionLog
 	highestBet 			int 
 	lastRaiserID 		int 
 	lastRaiseAmount 	int
 	deckKeys 			*CardKeys
-	foldedPlayerKeys 	map[string]*CardKeys
-	revealedKeys 		map[string]*CardKeys
+	deckCommitments 	map[string][]byte
 	currentDeck 		[][]byte
 	myHand 				[]Card
 	communityCards 		[]Card
 	sidePots 			[]SidePot
+	identity 			*NodeIdentity
+	hostKey 			ed25519.PublicKey
+	// hostPinned is set when hostKey comes from our config
+	hostPinned 			bool
+	playerKeys 			map[string]ed25519.PublicKey
+	paused 				bool
+	banned 				map[string]bool
+	pendingConfig 		*TableConfigChange
+	proposals 			map[string]*controlProposal
+	appliedControls 	map[string]int64
+	// removePeer disconnects a player removed by a control command, set by
+	// the server that owns the connections.
+	removePeer 			func(addr string)
 }
 
-func NewGame(addr string, bc chan BroadcastTo) *Game {
-	sharedPrime, _ := new(big.Int).SetString("C7970CEDCC5226685694605929849D3D", 16)
-	keys, _ := GenerateCardKeys(sharedPrime)
+func NewGame(addr string, cfg TableConfig, bc chan BroadcastTo) *Game {
+	keys, _ := GenerateCardKeys(deckPrime)
+	history := newHandHistoryStore(NewMemoryStorage())
 	g := &Game{
 		playersList: 			NewPlayersList(),
 		broadcastch: 			bc,
 		listenAddr: 			addr,
+		config: 				cfg.withDefaults(),
+		waitingList: 			[]string{},
+		ledger: 				NewChipLedger(),
+		straddles: 				make(map[string]StraddleKind),
+		runItVotes: 			make(map[string]int),
+		lastAggressorID: 		-1,
+		shownCards: 			make(map[string][]Card),
+		mucked: 				make(map[string]bool),
+		revealedCards: 			make(map[int]Card),
+		rabbitVotes: 			make(map[string]bool),
+		handHistory: 			history,
+		events: 				NewEventBus(),
+		actionLog: 				newActionLog(),
+		sessionID: 				time.Now().UTC().Format("20060102T150405Z"),
 		currentStatus: 			NewAtomicInt(int32(GameStatusWaiting)),
 		playerStates: 			make(map[string]*PlayerState),
 		rotationMap: 			make(map[int]string),
+		smallBlindID: 			-1,
+		bigBlindID: 			-1,
 		deckKeys: 				keys,
-		foldedPlayerKeys: 		make(map[string]*CardKeys),
-		revealedKeys: 			make(map[string]*CardKeys),
+		deckCommitments: 		make(map[string][]byte),
 		myHand: 				make([]Card, 0, 2),
 		communityCards: 		make([]Card, 0, 5),		
 		sidePots:				[]SidePot{},	
+		playerKeys: 			make(map[string]ed25519.PublicKey),
+		banned: 				make(map[string]bool),
+		proposals: 				make(map[string]*controlProposal),
+		appliedControls: 		make(map[string]int64),
 	}
 	g.playersList.add(addr)
 	g.playerStates[addr] = &PlayerState{
 		ListenAddr: addr, 
 		IsActive: true, 
-		Stack: 1000,
 	}
+	g.applyBuyIn(LedgerEntry{Player: addr, Kind: BuyInInitial, Amount: g.config.StartingStack})
 
 	go g.loop()
 	return g
 }
 
-func (g *Game) postBlinds() {
-	activeCount := len(g.getReadyActivePlayers())
-	
-	if activeCount == 2 {
-		sbID := g.currentDealerID 
-		sbAddr := g.rotationMap[sbID]
-		g.updatePlayerState(sbAddr, PlayerActionBet, SmallBlind)
-		logrus.Infof("Player %s (dealer) posted small blind: %d", sbAddr, SmallBlind)
-
-		bbID := g.getNextActivePlayerID(sbID)
-		bbAddr := g.rotationMap[bbID]
-		g.updatePlayerState(bbAddr, PlayerActionBet, BigBlind)
-		logrus.Infof("Player %s posted big blind: %d", bbAddr, BigBlind)
-
-		g.currentPlayerTurnID = sbID
-		g.lastRaiserID = bbID
-		g.lastRaiseAmount = BigBlind
-	} else {
-		sbID := g.getNextActivePlayerID(g.currentDealerID)
-		sbAddr := g.rotationMap[sbID]
-		g.updatePlayerState(sbAddr, PlayerActionBet, SmallBlind)
-		logrus.Infof("Player %s posted small blind: %d", sbAddr, SmallBlind)
-
-		bbID := g.getNextActivePlayerID(sbID)
-		bbAddr := g.rotationMap[bbID]
-		g.updatePlayerState(bbAddr, PlayerActionBet, BigBlind)
-		logrus.Infof("Player %s posted big blind: %d", bbAddr, BigBlind)
-
-		g.currentPlayerTurnID = g.getNextActivePlayerID(bbID)
-		g.lastRaiserID = bbID
-		g.lastRaiseAmount = BigBlind
-	}
-}
-
 func (g *Game) AddPlayer(addr string) {
 	g.lock.Lock()
 	defer g.lock.Unlock()
 
 	if _, exists := g.playerStates[addr]; exists {
 		g.playerStates[addr].IsActive = true 
+		g.playersList.add(addr)
+		g.logEvent(EventPlayerJoined, addr)
+		g.emit(TableEventPlayerJoined, PlayerEvent{Player: addr, Seat: g.playerStates[addr].RotationID})
 		return 
 	}
 	g.playersList.add(addr)
 	g.playerStates[addr] = &PlayerState{
 		ListenAddr: addr, 
 		IsActive: true,
-		Stack: 1000,
 	}
+	g.applyBuyIn(LedgerEntry{Player: addr, Kind: BuyInInitial, Amount: g.config.StartingStack})
+	g.logEvent(EventPlayerJoined, addr)
+	g.emit(TableEventPlayerJoined, PlayerEvent{Player: addr, Seat: g.playerStates[addr].RotationID})
 }
 
 func (g *Game) RemovePlayer(addr string) {
@@ -219,6 +327,14 @@ func (g *Game) RemovePlayer(addr string) {
 		state.IsFolded = true 
 		g.playersList.remove(addr)
 	}
+	g.removeFromWaitingList(addr)
+	g.releaseSeat(addr)
+	g.logEvent(EventPlayerLeft, addr)
+	seat := -1
+	if state, ok := g.playerStates[addr]; ok {
+		seat = state.RotationID
+	}
+	g.emit(TableEventPlayerLeft, PlayerEvent{Player: addr, Seat: seat})
 }
 
 func (g *Game) SetReady(from string) {
@@ -229,16 +345,13 @@ func (g *Game) SetReady(from string) {
 	if !ok {
 		return 
 	}
-	if !state.IsReady{
-		state.RotationID = g.nextRotationID
-		g.rotationMap[state.RotationID] = from 
-		g.nextRotationID++
-		state.IsReady = true 
-	}
+	state.IsReady = true 
+	g.logEvent(EventPlayerReady, from)
+	g.emit(TableEventPlayerReady, PlayerEvent{Player: from, Seat: state.RotationID})
 
 	g.sendToPlayers(MessageReady{}, g.getOtherPlayers()...)
 	if len(g.getReadyPlayers()) >= 2 && GameStatus(g.currentStatus.Get()) == GameStatusWaiting {
-		g.StartNewHand()
+		go g.StartNewHand()
 	}
 }
 
@@ -246,54 +359,49 @@ func (g *Game) StartNewHand() {
 	g.lock.Lock()
 	defer g.lock.Unlock()
 
-	activeReadyPlayers := g.getReadyActivePlayers()
+	if g.paused {
+		logrus.Info("Table is paused, not starting a new hand")
+		return
+	}
+	g.applyPendingConfig()
+	g.applyPendingBuyIns()
+	g.releaseDepartedSeats()
+	g.assignSeats(g.getReadyActivePlayers())
+	activeReadyPlayers := g.getSeatedReadyPlayers()
 	if len(activeReadyPlayers) < 2 {
 		g.setStatus(GameStatusWaiting)
 		logrus.Warn("Not enough players to start a hand")
 		return 
 	}
-	g.rotationMap = make(map[int]string)
-	g.nextRotationID = 0
+	g.handNumber++
+	g.turnNumber = 0
+	g.resetRunOut()
+	g.resetShowdown()
 	g.myHand = make([]Card, 0, 2)
 	g.communityCards = make([]Card, 0, 5)
-	g.lastRaiseAmount = BigBlind
+	g.currentPot = 0
+	g.highestBet = 0
+	g.lastRaiseAmount = g.config.BigBlind
 
-	sort.Strings(activeReadyPlayers)
-	for _, addr := range activeReadyPlayers{
-		state := g.playerStates[addr]
-		state.RotationID = g.nextRotationID
+	for _, state := range g.playerStates {
+		state.InHand = false
 		state.IsFolded = false 
 		state.CurrentRoundBet = 0
 		state.TotalBetThisHand = 0
 		state.IsAllIn = false
-		g.rotationMap[state.RotationID] = addr 
-		g.nextRotationID++
 	}
-	g.advanceDealer()
+	for _, addr := range activeReadyPlayers {
+		g.playerStates[addr].InHand = true
+	}
+	g.handSeats = g.nextRotationID
+	g.advanceButton()
+	g.beginHandHistory()
 	g.postBlinds()
-	g.currentPot = 0
-	g.highestBet = 0
-	g.lastRaiserID = g.currentDealerID
 	g.setStatus(GameStatusDealing)
-	g.InitiateShuffleAndDeal()
-}
-
-func (g *Game) advanceDealer() {
-	if g.nextRotationID == 0 {
-		return 
-	}
-	startID := g.currentDealerID
-	for {
-		nextID := (startID + 1) % g.nextRotationID
-		addr, ok := g.rotationMap[nextID]
-		if ok && g.playerStates[addr].IsActive{
-			g.currentDealerID = nextID 
-			return 
-		}
-		startID = nextID
-		if startID == g.currentDealerID {
-			break
-		}
+	g.logEvent(EventHandStarted, "")
+	g.emit(TableEventHandStarted, HandStartedEvent{HandNumber: g.handNumber, DealerID: g.currentDealerID})
+	if g.listenAddr == g.rotationMap[g.dealerSeat()] {
+		g.InitiateShuffleAndDeal()
 	}
 }
 
@@ -350,7 +458,7 @@ func (g *Game) getValidActions() []PlayerAction {
 	}
 	minRaise := g.highestBet + g.lastRaiseAmount
 	if g.highestBet == 0 {
-		minRaise = BigBlind
+		minRaise = g.config.BigBlind
 	}
 	if state.Stack > (minRaise - state.CurrentRoundBet){
 		if g.highestBet == 0{
@@ -362,14 +470,23 @@ func (g *Game) getValidActions() []PlayerAction {
 	return actions
 }
 
-func (g *Game) TakeAction(action PlayerAction, value int) error {
+// TakeAction acts for us. A request ID that was already used returns the
+// result of its first action without acting again.
+func (g *Game) TakeAction(action PlayerAction, value int, opts ActionOptions) (ActionResult, error) {
 	g.lock.Lock()
 	defer g.lock.Unlock()
 
+	if result, ok, err := g.repeatedAction(action, value, opts); ok || err != nil {
+		return result, err
+	}
+	if err := g.checkExpected(opts); err != nil {
+		return ActionResult{}, err
+	}
+
 	myState := g.playerStates[g.listenAddr]
 
 	if myState.RotationID != g.currentPlayerTurnID {
-		return fmt.Errorf("it is not my turn to act: %s", g.listenAddr)
+		return ActionResult{}, newRuleError(ErrNotYourTurn, "it is not my turn to act: %s", g.listenAddr)
 	}
 
 	valid := false 
@@ -380,24 +497,24 @@ func (g *Game) TakeAction(action PlayerAction, value int) error {
 		}
 	}
 	if !valid {
-		return fmt.Errorf("illegal action: you cannot %s right now", action)
+		return ActionResult{}, newRuleError(ErrIllegalAction, "illegal action: you cannot %s right now", action)
 	}
 	switch action {
 	case PlayerActionBet:
-		if value < BigBlind {
-			return fmt.Errorf("bet must be atleast the big blind (%d)", BigBlind)
+		if value < g.config.BigBlind {
+			return ActionResult{}, newAmountError(value, g.config.BigBlind, myState.Stack, "bet must be atleast the big blind (%d)", g.config.BigBlind)
 		}
 		if value > myState.Stack {
-			return fmt.Errorf("bet (%d) exceeds your stack (%d)", value, myState.Stack)
+			return ActionResult{}, newAmountError(value, g.config.BigBlind, myState.Stack, "bet (%d) exceeds your stack (%d)", value, myState.Stack)
 		}
 		g.lastRaiseAmount = value
 	case PlayerActionRaise:
 		minRaise := g.highestBet + g.lastRaiseAmount
 		if value < minRaise {
-			return fmt.Errorf("raise must be at least %d (double current bet)", minRaise)
+			return ActionResult{}, newAmountError(value, minRaise, myState.Stack, "raise must be at least %d (double current bet)", minRaise)
 		}
 		if value > myState.Stack {
-			return fmt.Errorf("raise (%d) exceeds your stack (%d)", value, myState.Stack)
+			return ActionResult{}, newAmountError(value, minRaise, myState.Stack, "raise (%d) exceeds your stack (%d)", value, myState.Stack)
 		}
 		g.lastRaiseAmount = value - g.highestBet
 	case PlayerActionCall:
@@ -407,24 +524,33 @@ func (g *Game) TakeAction(action PlayerAction, value int) error {
 		}
 	}
 	if action == PlayerActionFold {
-		g.sendToPlayers(MessageRevealKeys{
-			Keys: g.deckKeys,
-		}, g.getOtherPlayers()...)
 		myState.IsFolded = true
 	}
+	stackBefore := myState.Stack
 	g.updatePlayerState(g.listenAddr, action, value)
+	g.recordAction(g.listenAddr, historyKindForAction(action), stackBefore)
+	if action == PlayerActionBet || action == PlayerActionRaise {
+		g.lastAggressorID = myState.RotationID
+	}
 	g.sendToPlayers(MessagePlayerAction{
 		Action: action,
 		CurrentGameStatus: GameStatus(g.currentStatus.Get()),
 		Value: value,
 	}, g.getOtherPlayers()...)
+	result := ActionResult{
+		Action: 	action,
+		Value: 		value,
+		HandNumber: g.handNumber,
+		Street: 	GameStatus(g.currentStatus.Get()),
+		Turn: 		g.turnNumber,
+	}
+	if opts.RequestID != "" {
+		g.actionLog.add(opts.RequestID, result)
+	}
+	g.logEvent(EventPlayerAction, g.listenAddr)
+	g.emitAction(g.listenAddr, action)
 	g.advanceTurnAndCheckRoundEnd()
-	go func(){
-		if err := g.SaveSnapshot("game_snapshot.json"); err != nil {
-			logrus.Errorf("Failed to save snapshot: %s", err)
-		}
-	}()
-	return nil
+	return result, nil
 }
 
 func (g *Game) handlePlayerAction(from string, msg MessagePlayerAction) error {
@@ -432,14 +558,34 @@ func (g *Game) handlePlayerAction(from string, msg MessagePlayerAction) error {
 	defer g.lock.Unlock()
 
 	if g.playerStates[from].RotationID != g.currentPlayerTurnID {
-		return fmt.Errorf("player (%s) acting out of turn", from)
+		return newRuleError(ErrNotYourTurn, "player (%s) acting out of turn", from)
 	}
 
+	stackBefore := g.playerStates[from].Stack
 	g.updatePlayerState(from, msg.Action, msg.Value)
+	g.recordAction(from, historyKindForAction(msg.Action), stackBefore)
+	if msg.Action == PlayerActionBet || msg.Action == PlayerActionRaise {
+		g.lastAggressorID = g.playerStates[from].RotationID
+	}
+	g.logEvent(EventPlayerAction, from)
+	g.emitAction(from, msg.Action)
 	g.advanceTurnAndCheckRoundEnd()
 	return nil
 }
 
+func (g *Game) emitAction(addr string, action PlayerAction) {
+	state := g.playerStates[addr]
+	g.emit(TableEventActionTaken, ActionEvent{
+		Player: addr,
+		Seat: 	state.RotationID,
+		Action: action.String(),
+		Bet: 	state.CurrentRoundBet,
+		Stack: 	state.Stack,
+		Pot: 	g.currentPot,
+		AllIn: 	state.IsAllIn,
+	})
+}
+
 func (g *Game) updatePlayerState(addr string, action PlayerAction, value int) {
 	state := g.playerStates[addr]
 	switch action {
@@ -498,8 +644,8 @@ func (g *Game) incNextPlayer() {
 		nextID := g.getNextPlayerID(startID)
 		addr := g.rotationMap[nextID]
 		state, ok := g.playerStates[addr]
-		if ok && state.IsActive && !state.IsFolded && !state.IsAllIn {
-			g.currentPlayerTurnID = nextID 
+		if ok && state.InHand && state.IsActive && !state.IsFolded && !state.IsAllIn {
+			g.setTurn(nextID)
 			return 
 		}
 		startID = nextID 
@@ -512,7 +658,7 @@ func (g *Game) checkRoundEnd() bool {
 	activeNonFoldedCount := 0
 	canActCount := 0 
 	for _, state := range g.playerStates {
-		if state.IsActive && !state.IsFolded {
+		if state.InHand && state.IsActive && !state.IsFolded {
 			activeNonFoldedCount++
 			if !state.IsAllIn{
 				canActCount++
@@ -529,7 +675,7 @@ func (g *Game) checkRoundEnd() bool {
 	if canActCount == 1 {
 		allMatched := true 
 		for _, state := range g.playerStates {
-			if state.IsActive && !state.IsFolded && !state.IsAllIn {
+			if state.InHand && state.IsActive && !state.IsFolded && !state.IsAllIn {
 				if state.CurrentRoundBet < g.highestBet {
 					allMatched = false
 					break
@@ -542,7 +688,7 @@ func (g *Game) checkRoundEnd() bool {
 	}
 	allMatchedOrOut := true 
 	for _, state := range g.playerStates {
-		if state.IsActive && !state.IsFolded && !state.IsAllIn {
+		if state.InHand && state.IsActive && !state.IsFolded && !state.IsAllIn {
 			if state.CurrentRoundBet < g.highestBet {
 				allMatchedOrOut = false 
 				break
@@ -566,93 +712,103 @@ func (g *Game) ResolveWinner() {
 	nonFoldedPlayers := []string{}
 	for _, playerAddr := range activePlayers {
 		state := g.playerStates[playerAddr]
-		if !state.IsFolded {
+		if state.InHand && !state.IsFolded {
 			nonFoldedPlayers = append(nonFoldedPlayers, playerAddr)
 		}
 	}
 	if len(nonFoldedPlayers) == 1 {
 		winnerAddr := nonFoldedPlayers[0]
 		g.playerStates[winnerAddr].Stack += g.currentPot
+		g.emit(TableEventPotAwarded, PotAwardedEvent{Player: winnerAddr, Amount: g.currentPot, Pot: "Main Pot"})
 		logrus.Infof("🏆 WINNER BY DEFAULT: %s wins %d chips (everyone else folded)!", 
 			winnerAddr, g.currentPot)
 		g.resetHandState()
 		return
 	}
-	playerHands := make([]PlayerHand, 0, len(nonFoldedPlayers))
+	// only hands that were shown can win, mucked hands give up the pot
+	holeCards := make(map[string][]Card, len(nonFoldedPlayers))
+	contenders := []string{}
 	for _, playerAddr := range nonFoldedPlayers {
-		state := g.playerStates[playerAddr]
-		indices := []int{state.RotationID * 2, (state.RotationID * 2) + 1}
-		playerHand := []Card{}
-		for _, cardIdx := range indices {
-			encryptedCard := g.currentDeck[cardIdx]
-			decryptedBytes := encryptedCard
-			for _, keys := range g.revealedKeys {
-				decryptedBytes = keys.Decrypt(decryptedBytes)
-			}
-			for _, keys := range g.foldedPlayerKeys {
-				decryptedBytes = keys.Decrypt(decryptedBytes)
-			}	
-			playerHand = append(playerHand, NewCardFromByte(decryptedBytes[0]))
+		if g.hasShownHand(playerAddr) {
+			holeCards[playerAddr] = g.shownCards[playerAddr][:2]
+			contenders = append(contenders, playerAddr)
 		}
-		rank, handName := EvaluateBestHand(playerHand, g.communityCards)
-		logrus.Infof("Player %s: %v - %s (Rank: %d)", 
-			playerAddr, playerHand, handName, rank)
-		playerHands = append(playerHands, PlayerHand{
-			Addr:     playerAddr,
-			Hand:     playerHand,
-			Rank:     rank,
-			HandName: handName,
-		})
 	}
+	nonFoldedPlayers = contenders
+	boards := g.showdownBoards()
+	runs := len(boards)
+	g.runResults = make([]RunResult, 0, runs)
 	sidePots := g.calculateSidePots()
-	if len(sidePots) > 0 {
-		logrus.Infof("Distributing %d pot(s)...", len(sidePots))
-		for i, pot := range sidePots {
-			logrus.Infof("Pot #%d: %d chips (cap: %d)", i+1, pot.Amount, pot.Cap)
-			bestRank := int32(999999)
-			potWinners := []*PlayerHand{}
-			for idx := range playerHands {
-				ph := &playerHands[idx]
-				isEligible := false
-				for _, eligibleAddr := range pot.EligiblePlayers {
-					if ph.Addr == eligibleAddr {
-						isEligible = true
-						break
-					}
-				}	
-				if isEligible {
-					if ph.Rank < bestRank {
-						bestRank = ph.Rank
-						potWinners = []*PlayerHand{ph}
-					} else if ph.Rank == bestRank {
-						potWinners = append(potWinners, ph)
+
+	for run, board := range boards {
+		if runs > 1 {
+			logrus.Infof("=== RUN #%d: %v ===", run+1, board)
+		}
+		result := RunResult{Board: board, Payouts: make(map[string]int)}
+		playerHands := make([]PlayerHand, 0, len(nonFoldedPlayers))
+		for _, playerAddr := range nonFoldedPlayers {
+			playerHand := holeCards[playerAddr]
+			rank, handName := EvaluateBestHand(playerHand, board)
+			logrus.Infof("Player %s: %v - %s (Rank: %d)", 
+				playerAddr, playerHand, handName, rank)
+			playerHands = append(playerHands, PlayerHand{
+				Addr:     playerAddr,
+				Hand:     playerHand,
+				Rank:     rank,
+				HandName: handName,
+			})
+		}
+		if len(sidePots) > 0 {
+			logrus.Infof("Distributing %d pot(s)...", len(sidePots))
+			for i, pot := range sidePots {
+				potShare := splitForRun(pot.Amount, runs, run)
+				logrus.Infof("Pot #%d: %d chips (cap: %d)", i+1, potShare, pot.Cap)
+				bestRank := int32(999999)
+				potWinners := []*PlayerHand{}
+				for idx := range playerHands {
+					ph := &playerHands[idx]
+					isEligible := false
+					for _, eligibleAddr := range pot.EligiblePlayers {
+						if ph.Addr == eligibleAddr {
+							isEligible = true
+							break
+						}
+					}	
+					if isEligible {
+						if ph.Rank < bestRank {
+							bestRank = ph.Rank
+							potWinners = []*PlayerHand{ph}
+						} else if ph.Rank == bestRank {
+							potWinners = append(potWinners, ph)
+						}
 					}
 				}
+				if len(potWinners) > 0 {
+					g.distributePot(potShare, potWinners, i+1, result.Payouts)
+				}
 			}
-			if len(potWinners) > 0 {
-				g.distributePot(pot.Amount, potWinners, i+1)
+		} else {
+			bestRank := int32(999999)
+			winners := []*PlayerHand{}
+			for idx := range playerHands {
+				if playerHands[idx].Rank < bestRank {
+					bestRank = playerHands[idx].Rank
+					winners = []*PlayerHand{&playerHands[idx]}
+				} else if playerHands[idx].Rank == bestRank {
+					winners = append(winners, &playerHands[idx])
+				}
 			}
-		}
-	} else {
-		bestRank := int32(999999)
-		winners := []*PlayerHand{}
-		for idx := range playerHands {
-			if playerHands[idx].Rank < bestRank {
-				bestRank = playerHands[idx].Rank
-				winners = []*PlayerHand{&playerHands[idx]}
-			} else if playerHands[idx].Rank == bestRank {
-				winners = append(winners, &playerHands[idx])
+			if len(winners) > 0 {
+				g.distributePot(splitForRun(g.currentPot, runs, run), winners, 0, result.Payouts)
 			}
 		}
-		if len(winners) > 0 {
-			g.distributePot(g.currentPot, winners, 0)
-		}
+		g.runResults = append(g.runResults, result)
 	}
 	g.resetHandState()
 	logrus.Info("=== HAND COMPLETE ===")
 }
 
-func (g *Game) distributePot(potAmount int, winners []*PlayerHand, potNum int) {
+func (g *Game) distributePot(potAmount int, winners []*PlayerHand, potNum int, payouts map[string]int) {
 	splitAmount := potAmount / len(winners)
 	remainder := potAmount % len(winners)
 	potLabel := "Main Pot"
@@ -668,23 +824,27 @@ func (g *Game) distributePot(potAmount int, winners []*PlayerHand, potNum int) {
 			award += remainder
 		}
 		g.playerStates[winner.Addr].Stack += award 
+		payouts[winner.Addr] += award
+		g.emit(TableEventPotAwarded, PotAwardedEvent{Player: winner.Addr, Amount: award, Pot: potLabel})
 		logrus.Infof("%s Winner: %s receives %d chips with %s", potLabel, winner.Addr, award, winner.HandName)
 	}
 }
 
 func (g *Game) resetHandState(){
+	g.finishHandHistory()
+	g.recordFinishedHand()
 	g.currentPot = 0
 	g.sidePots = []SidePot{}
-	g.revealedKeys = make(map[string]*CardKeys)
-	g.foldedPlayerKeys = make(map[string]*CardKeys)
 	g.setStatus(GameStatusHandComplete)
+	g.logEvent(EventHandComplete, "")
+	g.emit(TableEventHandComplete, HandCompleteEvent{HandNumber: g.handNumber})
 }
 
 func (g *Game) advanceToNextRound() {
 	nonFoldedCount := 0 
 	var lastPlayerAddr string 
 	for addr, state := range g.playerStates {
-		if state.IsActive && !state.IsFolded{
+		if state.InHand && state.IsActive && !state.IsFolded{
 			nonFoldedCount++
 			lastPlayerAddr = addr
 		}
@@ -692,6 +852,7 @@ func (g *Game) advanceToNextRound() {
 	if nonFoldedCount == 1{
 		logrus.Infof("Only one player remains!, %s wins by default", lastPlayerAddr)
 		g.playerStates[lastPlayerAddr].Stack += g.currentPot
+		g.emit(TableEventPotAwarded, PotAwardedEvent{Player: lastPlayerAddr, Amount: g.currentPot, Pot: "Main Pot"})
 		g.resetHandState()
 		go g.StartNewHand()
 		return 
@@ -703,30 +864,42 @@ func (g *Game) advanceToNextRound() {
 		return 
 	}
 
+	if g.isAllInRunOut() {
+		g.beginRunOut()
+		return
+	}
+
 	newStatus := g.getNextGameStatus()
 	g.setStatus(newStatus)
 	g.highestBet = 0 
 	g.lastRaiseAmount = 0
+	if newStatus != GameStatusShowdown {
+		g.lastAggressorID = -1
+	}
 	for _, state := range g.playerStates {
 		state.CurrentRoundBet = 0
 	}
+	g.logEvent(EventStreet, "")
+	g.emit(TableEventStreetChanged, StreetEvent{Street: newStatus.String()})
 	if newStatus == GameStatusShowdown {
 		logrus.Infof("Advancing to %s", newStatus)
-		g.InitiateShowdown()
+		go g.InitiateShowdown()
 		return 
 	}
 
-	if g.listenAddr == g.rotationMap[g.currentDealerID] {
+	if g.listenAddr == g.rotationMap[g.dealerSeat()] {
 		communityIndices := []int{}
-		numPlayers := len(g.getReadyActivePlayers())
+		// Hole cards are dealt by seat number, so the board starts after
+		// the last seat rather than after the number of players.
+		numSeats := g.handSeats
 		switch newStatus {
 		case GameStatusFlop:
-			start := numPlayers * 2
+			start := numSeats * 2
 			communityIndices = []int{start, start+1, start+2}
 		case GameStatusTurn:
-			communityIndices = []int{numPlayers*2 + 3}
+			communityIndices = []int{numSeats*2 + 3}
 		case GameStatusRiver:
-			communityIndices = []int{numPlayers*2 + 4}
+			communityIndices = []int{numSeats*2 + 4}
 		}
 		g.sendToPlayers(MessageGameState{
 			Status: newStatus,
@@ -737,7 +910,7 @@ func (g *Game) advanceToNextRound() {
 			CommunityCards: communityIndices,
 		})
 	}
-	g.currentPlayerTurnID = g.getNextActivePlayerID(g.currentDealerID)
+	g.setTurn(g.getNextActivePlayerID(g.currentDealerID))
 	logrus.Infof("Advancing to next round: %s, Turn: %d", newStatus, g.currentPlayerTurnID)
 }
 
@@ -746,7 +919,7 @@ func (g *Game) InitiateShuffleAndDeal(){
 	deck := CreatePlaceHolderDeck()
 	encryptedDeck := g.shuffleAndEncrypt(deck)
 
-	nextPlayerAddr := g.rotationMap[g.getNextPlayerID(g.currentDealerID)]
+	nextPlayerAddr := g.rotationMap[g.getNextPlayerID(g.dealerSeat())]
 	g.sendToPlayers(MessageShuffleStatus{Deck: encryptedDeck}, nextPlayerAddr)
 }
 
@@ -771,15 +944,16 @@ func (g *Game) ShuffleAndEncrypt(from string, deck [][]byte) error {
 	g.lock.Lock()
 	defer g.lock.Unlock()
 
-	if g.listenAddr == g.rotationMap[g.currentDealerID]{
+	if g.listenAddr == g.rotationMap[g.dealerSeat()]{
 		logrus.Info("Deck fully encrypted by all players. Starting Pre-Flop.")
 		g.currentDeck = deck 
 		g.setStatus(GameStatusPreFlop)
 		g.sendToPlayers(MessageGameState{
 			Status: GameStatusPreFlop,
 			CommunityCards: []int{},
+			Deck: deck,
 		}, g.getOtherPlayers()...)
-		g.SyncState(MessageGameState{Status: GameStatusPreFlop})
+		g.syncState(MessageGameState{Status: GameStatusPreFlop, Deck: deck})
 		return nil
 	}
 	nextDeck := g.shuffleAndEncrypt(deck)
@@ -792,28 +966,36 @@ func (g *Game) SyncState(msg MessageGameState) error {
 	g.lock.Lock()
 	defer g.lock.Unlock()
 
+	g.syncState(msg)
+	return nil
+}
+
+// syncState moves to the street the dealer announced. The caller must hold
+// the game lock.
+func (g *Game) syncState(msg MessageGameState) {
 	logrus.Infof("Syncing game state: %s", msg.Status)
 	g.setStatus(msg.Status)
-	g.highestBet = 0
-	g.lastRaiseAmount = 0
-	for _, state := range g.playerStates {
-		state.CurrentRoundBet = 0
+	if msg.Deck != nil {
+		g.currentDeck = msg.Deck
 	}
 	if msg.Status == GameStatusPreFlop {
+		// the blinds posted in StartNewHand are this round's opening bets
 		go g.revealMyHoleCards()
+	} else {
+		g.highestBet = 0
+		g.lastRaiseAmount = 0
+		for _, state := range g.playerStates {
+			state.CurrentRoundBet = 0
+		}
+	}
+	if msg.Deck != nil {
+		g.logEvent(EventDeck, "")
+	} else {
+		g.logEvent(EventStreet, "")
 	}
 	if len(msg.CommunityCards) > 0 {
 		go g.revealCommunityCards(msg.CommunityCards)
 	}
-	return nil
-}
-
-func (g *Game) HandleFoldKeyReveal(from string, msg MessageRevealKeys) {
-	g.lock.Lock()
-	defer g.lock.Unlock()
-
-	g.foldedPlayerKeys[from] = msg.Keys 
-	logrus.Infof("Received keys from folded player %s", from)
 }
 
 func (g *Game) revealMyHoleCards() {
@@ -871,6 +1053,7 @@ func (g *Game) HandleRPCResponse(from string, msg MessageRPCResponse) {
 	g.lock.Lock()
 	defer g.lock.Unlock()
 	myIndices := g.getMyHoleCardIndices()
+	holeCards, boardCards := []Card{}, []Card{}
 	for i, idx := range msg.CardIndices {
 		finalBytes := g.deckKeys.Decrypt(msg.DecryptedData[i])
 		card := NewCardFromByte(finalBytes[0])
@@ -883,37 +1066,22 @@ func (g *Game) HandleRPCResponse(from string, msg MessageRPCResponse) {
 		}
 		if isHole {
 			g.myHand = append(g.myHand, card)
+			holeCards = append(holeCards, card)
 			logrus.Infof("!!! HOLE CARD REVEALED: %s !!!", card.String())
 		} else {
 			g.communityCards = append(g.communityCards, card)
+			boardCards = append(boardCards, card)
 			logrus.Infof("!!! COMMUNITY CARD REVEALED: %s !!!", card.String())
 		}
 	}
-}
-
-func (g *Game) InitiateShowdown() {
-	logrus.Info("!!! SHOWDOWN REACHED: Broadcasting Private Keys !!!")
-	g.sendToPlayers(MessageRevealKeys{
-		Keys: g.deckKeys,
-	}, g.getOtherPlayers()...)
-	g.lock.Lock()
-	g.revealedKeys[g.listenAddr] = g.deckKeys
-	g.lock.Unlock()
-}
-
-func (g *Game) HandleShowdownKeyReveal(from string, msg MessageRevealKeys) {
-	g.lock.Lock()
-	g.revealedKeys[from] = msg.Keys
-	expectedKeys := 0 
-	for _, state := range g.playerStates {
-		if state.IsActive && !state.IsFolded {
-			expectedKeys++
-		}
+	if len(holeCards) > 0 {
+		g.emit(TableEventCardsRevealed, CardsRevealedEvent{Kind: "hole", Player: g.listenAddr, Cards: cardResponses(holeCards)})
 	}
-	g.lock.Unlock()
-	if len(g.revealedKeys) == expectedKeys {
-		go g.ResolveWinner()
+	if len(boardCards) > 0 {
+		g.emit(TableEventCardsRevealed, CardsRevealedEvent{Kind: "board", Cards: cardResponses(boardCards)})
 	}
+	g.logEvent(EventCardsDealt, g.listenAddr)
+	g.advanceShowdown()
 }
 
 func (g *Game) getMyHoleCardIndices() []int {
@@ -921,8 +1089,17 @@ func (g *Game) getMyHoleCardIndices() []int {
 	return []int{myID*2, (myID*2)+1}
 }
 
+// getNextPlayerID returns the next seat after id that was dealt into the
+// current hand, skipping empty seats and players sitting out.
 func (g *Game) getNextPlayerID(id int) int {
 	if g.nextRotationID == 0 {return 0}
+	nextID := id
+	for i := 0; i < g.nextRotationID; i++ {
+		nextID = (nextID + 1) % g.nextRotationID
+		if g.isDealtIn(nextID) {
+			return nextID
+		}
+	}
 	return (id + 1) % g.nextRotationID
 }
 
@@ -965,24 +1142,29 @@ func (g *Game) loop() {
 
 func (g *Game) getNextActivePlayerID(currentID int) int {
 	startID := currentID
-	for {
+	for attempts := 0; attempts <= g.nextRotationID; attempts++ {
 		nextID := g.getNextPlayerID(startID)
 		addr, ok := g.rotationMap[nextID]
 		if ok {
 			state := g.playerStates[addr]
-			if state.IsActive && !state.IsFolded && !state.IsAllIn{
+			if state.InHand && state.IsActive && !state.IsFolded && !state.IsAllIn{
 				return nextID
 			}
 		}
 		startID = nextID
 		if startID == currentID {
-			return currentID
+			break
 		}
 	}
+	return currentID
 }
 
 func (g *Game) setStatus(s GameStatus) {
+	from := GameStatus(g.currentStatus.Get())
 	g.currentStatus.Set(int32(s))
+	if from != s {
+		g.emit(TableEventStatusChanged, StatusEvent{From: from.String(), Status: s.String()})
+	}
 }
 
 func (g *Game) getNextGameStatus() GameStatus {
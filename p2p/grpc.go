@@ -0,0 +1,246 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCService is the full name of the gRPC service. Its methods are the RPC
+// names in the API route table, plus WatchTable.
+const GRPCService = "peerpoker.v1.Table"
+
+func init() {
+	encoding.RegisterCodec(GRPCCodec{})
+}
+
+// GRPCCodec encodes gRPC messages as JSON, the same JSON the HTTP API
+// speaks, so the gRPC service needs no generated code on either side. The
+// server uses it for every call; Go clients pass grpc.ForceCodec(GRPCCodec{})
+// and others the "json" content subtype.
+type GRPCCodec struct{}
+
+func (GRPCCodec) Name() string { return "json" }
+
+func (GRPCCodec) Marshal(v any) ([]byte, error) {
+	if raw, ok := v.(*json.RawMessage); ok {
+		return *raw, nil
+	}
+	return json.Marshal(v)
+}
+
+func (GRPCCodec) Unmarshal(data []byte, v any) error {
+	if raw, ok := v.(*json.RawMessage); ok {
+		*raw = append((*raw)[:0], data...)
+		return nil
+	}
+	// methods without a request accept an empty message
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WatchRequest starts a WatchTable stream. With the ID of the last event a
+// client saw it resumes after that event, like Last-Event-ID on
+// /api/events.
+type WatchRequest struct {
+	LastEventID string `json:"last_event_id,omitempty"`
+}
+
+// GRPCServer serves the API over gRPC. Every unary method runs the handler
+// of its HTTP route, token check included, so the two APIs answer alike.
+// The token goes in the authorization metadata as "Bearer <token>". Failed
+// calls carry the API error code in the error-code trailer.
+type GRPCServer struct {
+	listenAddr 	string
+	api 		*APIServer
+}
+
+func NewGRPCServer(listenAddr string, api *APIServer) *GRPCServer {
+	return &GRPCServer{
+		listenAddr: listenAddr,
+		api: 		api,
+	}
+}
+
+func (s *GRPCServer) Run() {
+	logrus.WithFields(logrus.Fields{
+		"addr": s.listenAddr,
+	}).Info("gRPC Server starting...")
+
+	ln, err := net.Listen("tcp", s.listenAddr)
+	if err != nil {
+		logrus.Errorf("gRPC server failed to listen on %s: %s", s.listenAddr, err)
+		return
+	}
+	srv := grpc.NewServer(grpc.ForceServerCodec(GRPCCodec{}))
+	srv.RegisterService(s.serviceDesc(), s)
+	if err := srv.Serve(ln); err != nil {
+		logrus.Errorf("gRPC server stopped: %s", err)
+	}
+}
+
+func (s *GRPCServer) serviceDesc() *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: 	GRPCService,
+		HandlerType: 	(*any)(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName: 	"WatchTable",
+			Handler: 		s.watchTable,
+			ServerStreams: 	true,
+		}},
+		Metadata: "peerpoker/table",
+	}
+	for _, route := range s.api.routes() {
+		if route.RPC == "" {
+			continue
+		}
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: route.RPC,
+			Handler: 	s.unary(route),
+		})
+	}
+	return desc
+}
+
+// unary serves a route's gRPC method by running its HTTP handler on the
+// request message.
+func (s *GRPCServer) unary(route apiRoute) grpc.MethodHandler {
+	handler := s.api.routeHandler(route)
+	return func(_ any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		var body json.RawMessage
+		if err := dec(&body); err != nil {
+			return nil, err
+		}
+		call := func(ctx context.Context, req any) (any, error) {
+			r := s.httpRequest(ctx, route.Method, route.Path, *req.(*json.RawMessage))
+			w := httptest.NewRecorder()
+			handler(w, r)
+			resp := json.RawMessage(w.Body.Bytes())
+			if w.Code >= 300 {
+				apiErr := &APIError{}
+				if err := json.Unmarshal(resp, apiErr); err != nil {
+					return nil, status.Errorf(codes.Internal, "%s", bytes.TrimSpace(resp))
+				}
+				apiErr.Status = w.Code
+				grpc.SetTrailer(ctx, errorTrailer(apiErr))
+				return nil, grpcError(apiErr)
+			}
+			return &resp, nil
+		}
+		if interceptor == nil {
+			return call(ctx, &body)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server: 	s,
+			FullMethod: "/" + GRPCService + "/" + route.RPC,
+		}
+		return interceptor(ctx, &body, info, call)
+	}
+}
+
+// httpRequest turns a gRPC call into the HTTP request its route expects,
+// carrying the token from the call's metadata.
+func (s *GRPCServer) httpRequest(ctx context.Context, method, path string, body []byte) *http.Request {
+	r := httptest.NewRequest(method, path, bytes.NewReader(body)).WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if auth := md.Get("authorization"); len(auth) > 0 {
+			r.Header.Set("Authorization", auth[0])
+		}
+	}
+	return r
+}
+
+// watchTable streams table events the way /api/events does, starting with a
+// state frame unless the client resumes.
+func (s *GRPCServer) watchTable(_ any, stream grpc.ServerStream) error {
+	var req WatchRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	ctx := stream.Context()
+	scope := s.api.auth.scopeOf(s.httpRequest(ctx, http.MethodGet, "/api/events", nil))
+	if scope == ScopeNone {
+		apiErr := newAPIError(http.StatusUnauthorized, CodeUnauthorized, "missing or invalid API token")
+		stream.SetTrailer(errorTrailer(apiErr))
+		return grpcError(apiErr)
+	}
+
+	events, backlog := s.api.resume(req.LastEventID)
+	defer events.Close()
+
+	send := func(event TableEvent) error {
+		event, ok := visibleTo(scope, event)
+		if !ok {
+			return nil
+		}
+		return stream.SendMsg(event)
+	}
+	for _, event := range backlog {
+		if err := send(event); err != nil {
+			return err
+		}
+	}
+	for {
+		select {
+		case event, ok := <-events.C:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "client fell behind, resume with the last event ID")
+			}
+			if err := send(event); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func grpcError(apiErr *APIError) error {
+	return status.Error(grpcCode(apiErr.Status), apiErr.Message)
+}
+
+// errorTrailer carries the API error code and any details of a failed call,
+// as gRPC status codes are much coarser.
+func errorTrailer(apiErr *APIError) metadata.MD {
+	trailer := metadata.Pairs("error-code", string(apiErr.Code))
+	if apiErr.Details != nil {
+		if details, err := json.Marshal(apiErr.Details); err == nil {
+			trailer.Append("error-details", string(details))
+		}
+	}
+	return trailer
+}
+
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	default:
+		return codes.Internal
+	}
+}
@@ -0,0 +1,293 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type HistoryActionKind string
+
+const (
+	HistoryPostSmallBlind 	HistoryActionKind = "post_small_blind"
+	HistoryPostBigBlind 	HistoryActionKind = "post_big_blind"
+	HistoryPostDead 		HistoryActionKind = "post_dead"
+	HistoryPostStraddle 	HistoryActionKind = "post_straddle"
+	HistoryFold 			HistoryActionKind = "fold"
+	HistoryCheck 			HistoryActionKind = "check"
+	HistoryCall 			HistoryActionKind = "call"
+	HistoryBet 				HistoryActionKind = "bet"
+	HistoryRaise 			HistoryActionKind = "raise"
+)
+
+func historyKindForAction(action PlayerAction) HistoryActionKind {
+	switch action {
+	case PlayerActionFold:
+		return HistoryFold
+	case PlayerActionCheck:
+		return HistoryCheck
+	case PlayerActionCall:
+		return HistoryCall
+	case PlayerActionBet:
+		return HistoryBet
+	default:
+		return HistoryRaise
+	}
+}
+
+type HistorySeat struct {
+	Seat 		int 	`json:"seat"`
+	Player 		string 	`json:"player"`
+	Stack 		int 	`json:"stack"`
+	FinalStack 	int 	`json:"final_stack"`
+}
+
+// HistoryAction is one thing a player did. Amount is the chips it put in
+// the pot and RaisedTo is their total bet on the street afterwards.
+type HistoryAction struct {
+	Street 		string 				`json:"street"`
+	Player 		string 				`json:"player"`
+	Seat 		int 				`json:"seat"`
+	Kind 		HistoryActionKind 	`json:"kind"`
+	Amount 		int 				`json:"amount"`
+	RaisedTo 	int 				`json:"raised_to"`
+	AllIn 		bool 				`json:"all_in"`
+}
+
+// HandHistory is everything that happened in one hand, as seen from this
+// node. HeroCards are our own hole cards, which only we know unless shown.
+type HandHistory struct {
+	ID 			int64 				`json:"id"`
+	SessionID 	string 				`json:"session_id"`
+	HandNumber 	int 				`json:"hand_number"`
+	StartedAt 	time.Time 			`json:"started_at"`
+	EndedAt 	time.Time 			`json:"ended_at"`
+	Hero 		string 				`json:"hero"`
+	HeroCards 	[]Card 				`json:"hero_cards,omitempty"`
+	SmallBlind 	int 				`json:"small_blind"`
+	BigBlind 	int 				`json:"big_blind"`
+	MaxSeats 	int 				`json:"max_seats"`
+	ButtonSeat 	int 				`json:"button_seat"`
+	Seats 		[]HistorySeat 		`json:"seats"`
+	Actions 	[]HistoryAction 	`json:"actions"`
+	Board 		[]Card 				`json:"board"`
+	Runs 		[]RunResult 		`json:"runs,omitempty"`
+	ShownHands 	map[string][]Card 	`json:"shown_hands,omitempty"`
+	Mucked 		[]string 			`json:"mucked,omitempty"`
+	Pot 		int 				`json:"pot"`
+	Pots 		[]SidePot 			`json:"pots"`
+	Collected 	map[string]int 		`json:"collected"`
+}
+
+func (h *HandHistory) seatOf(addr string) int {
+	for _, seat := range h.Seats {
+		if seat.Player == addr {
+			return seat.Seat
+		}
+	}
+	return -1
+}
+
+// HandHistoryStore keeps finished hands in memory and saves each one to the
+// node's storage, keyed by hand ID, so they survive restarts.
+type HandHistoryStore struct {
+	lock 	sync.RWMutex
+	store 	Storage
+	hands 	[]*HandHistory
+	nextID 	int64
+}
+
+func NewHandHistoryStore(store Storage) (*HandHistoryStore, error) {
+	s := newHandHistoryStore(store)
+	records, err := store.List(BucketHands)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		hand := &HandHistory{}
+		if err := json.Unmarshal(record.Value, hand); err != nil {
+			return nil, fmt.Errorf("corrupt hand history %s: %s", record.Key, err)
+		}
+		s.hands = append(s.hands, hand)
+		s.nextID = max(s.nextID, hand.ID+1)
+	}
+	return s, nil
+}
+
+// newHandHistoryStore is an empty store that has not read anything from
+// storage yet, which cannot fail.
+func newHandHistoryStore(store Storage) *HandHistoryStore {
+	return &HandHistoryStore{
+		store: 	store,
+		hands: 	[]*HandHistory{},
+		nextID: 1,
+	}
+}
+
+func (s *HandHistoryStore) Save(hand *HandHistory) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	hand.ID = s.nextID
+	s.nextID++
+	s.hands = append(s.hands, hand)
+	data, err := json.Marshal(hand)
+	if err != nil {
+		return err
+	}
+	return s.store.Put(BucketHands, seqKey(uint64(hand.ID)), data)
+}
+
+func (s *HandHistoryStore) Get(id int64) (*HandHistory, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	i := sort.Search(len(s.hands), func(i int) bool { return s.hands[i].ID >= id })
+	if i < len(s.hands) && s.hands[i].ID == id {
+		return s.hands[i], true
+	}
+	return nil, false
+}
+
+// List returns up to limit hands, newest first, skipping the offset newest,
+// along with the total number of hands stored.
+func (s *HandHistoryStore) List(offset, limit int) ([]*HandHistory, int) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	total := len(s.hands)
+	hands := []*HandHistory{}
+	for i := total - 1 - offset; i >= 0 && len(hands) < limit; i-- {
+		hands = append(hands, s.hands[i])
+	}
+	return hands, total
+}
+
+// Session returns the hands played in one session, oldest first. An empty
+// id picks the most recent session.
+func (s *HandHistoryStore) Session(id string) []*HandHistory {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if id == "" && len(s.hands) > 0 {
+		id = s.hands[len(s.hands)-1].SessionID
+	}
+	hands := []*HandHistory{}
+	for _, hand := range s.hands {
+		if hand.SessionID == id {
+			hands = append(hands, hand)
+		}
+	}
+	return hands
+}
+
+// All returns every stored hand, oldest first.
+func (s *HandHistoryStore) All() []*HandHistory {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	hands := make([]*HandHistory, len(s.hands))
+	copy(hands, s.hands)
+	return hands
+}
+
+// beginHandHistory starts recording a hand once the players are dealt in and
+// the button has moved, before any blinds go in.
+func (g *Game) beginHandHistory() {
+	hand := &HandHistory{
+		SessionID: 	g.sessionID,
+		HandNumber: g.handNumber,
+		StartedAt: 	time.Now(),
+		Hero: 		g.listenAddr,
+		SmallBlind: g.config.SmallBlind,
+		BigBlind: 	g.config.BigBlind,
+		MaxSeats: 	g.config.MaxSeats,
+		ButtonSeat: g.currentDealerID,
+		Seats: 		[]HistorySeat{},
+		Actions: 	[]HistoryAction{},
+	}
+	for seat := 0; seat < g.nextRotationID; seat++ {
+		if !g.isDealtIn(seat) {
+			continue
+		}
+		addr := g.rotationMap[seat]
+		hand.Seats = append(hand.Seats, HistorySeat{
+			Seat: 	seat,
+			Player: addr,
+			Stack: 	g.playerStates[addr].Stack,
+		})
+	}
+	g.history = hand
+}
+
+// recordAction adds an action to the hand being recorded. stackBefore is the
+// player's stack before the action so the chips it cost can be worked out.
+func (g *Game) recordAction(addr string, kind HistoryActionKind, stackBefore int) {
+	if g.history == nil {
+		return
+	}
+	state := g.playerStates[addr]
+	street := GameStatus(g.currentStatus.Get())
+	if street < GameStatusPreFlop || street > GameStatusRiver {
+		street = GameStatusPreFlop
+	}
+	g.history.Actions = append(g.history.Actions, HistoryAction{
+		Street: 	street.String(),
+		Player: 	addr,
+		Seat: 		state.RotationID,
+		Kind: 		kind,
+		Amount: 	stackBefore - state.Stack,
+		RaisedTo: 	state.CurrentRoundBet,
+		AllIn: 		state.IsAllIn,
+	})
+}
+
+// finishHandHistory completes the hand being recorded after the pot has been
+// paid out and stores it.
+func (g *Game) finishHandHistory() {
+	hand := g.history
+	if hand == nil {
+		return
+	}
+	g.history = nil
+
+	hand.EndedAt = time.Now()
+	hand.HeroCards = append([]Card{}, g.myHand...)
+	hand.Board = append([]Card{}, g.communityCards...)
+	hand.Runs = g.runResults
+	hand.Pots = g.showdownPots()
+	hand.Collected = make(map[string]int)
+	for i, seat := range hand.Seats {
+		state, ok := g.playerStates[seat.Player]
+		if !ok {
+			continue
+		}
+		hand.Seats[i].FinalStack = state.Stack
+		hand.Pot += state.TotalBetThisHand
+		if won := state.Stack - seat.Stack + state.TotalBetThisHand; won > 0 {
+			hand.Collected[seat.Player] = won
+		}
+	}
+	if g.runOut && len(g.runResults) > 0 {
+		hand.Board = g.runResults[0].Board
+	}
+	for addr, cards := range g.shownCards {
+		if hand.ShownHands == nil {
+			hand.ShownHands = make(map[string][]Card)
+		}
+		hand.ShownHands[addr] = append([]Card{}, cards...)
+	}
+	for addr := range g.mucked {
+		hand.Mucked = append(hand.Mucked, addr)
+	}
+	sort.Strings(hand.Mucked)
+
+	if err := g.handHistory.Save(hand); err != nil {
+		logrus.Errorf("Failed to save history of hand #%d: %s", hand.HandNumber, err)
+	}
+	g.emit(TableEventHandRecorded, hand)
+}
@@ -0,0 +1,174 @@
+package p2p
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const identityKey = "node"
+
+// NodeIdentity is the long-lived key pair of a node. It is created the first
+// time a node starts and kept in its storage, so the node stays the same one
+// across restarts even if its address changes. The private key lives in the
+// keystore under the identity ID.
+type NodeIdentity struct {
+	ID 			string 				`json:"id"`
+	PublicKey 	ed25519.PublicKey 	`json:"public_key"`
+	PrivateKey 	ed25519.PrivateKey 	`json:"-"`
+	CreatedAt 	time.Time 			`json:"created_at"`
+}
+
+// identityRecord is what the identity bucket holds. Nodes from before the
+// keystore kept the private key in it too, which LoadIdentity moves out.
+type identityRecord struct {
+	NodeIdentity
+	LegacyPrivateKey ed25519.PrivateKey `json:"private_key,omitempty"`
+}
+
+// ExportedIdentity is an identity sealed for moving it to another machine.
+type ExportedIdentity struct {
+	ID 			string 				`json:"id"`
+	PublicKey 	ed25519.PublicKey 	`json:"public_key"`
+	CreatedAt 	time.Time 			`json:"created_at"`
+	PrivateKey 	*SealedSecret 		`json:"private_key"`
+}
+
+func NewNodeIdentity() (*NodeIdentity, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &NodeIdentity{
+		ID: 		identityID(pub),
+		PublicKey: 	pub,
+		PrivateKey: priv,
+		CreatedAt: 	time.Now().UTC(),
+	}, nil
+}
+
+// identityID is a short fingerprint of a public key for logs and the API.
+func identityID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Sign signs data with the identity's private key.
+func (n *NodeIdentity) Sign(data []byte) []byte {
+	return ed25519.Sign(n.PrivateKey, data)
+}
+
+// LoadIdentity returns the node's identity, or ErrNotFound if it has none.
+func LoadIdentity(keys *Keystore) (*NodeIdentity, error) {
+	data, err := keys.store.Get(BucketIdentity, identityKey)
+	if err != nil {
+		return nil, err
+	}
+	record := identityRecord{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	identity := record.NodeIdentity
+	if record.LegacyPrivateKey != nil {
+		identity.PrivateKey = record.LegacyPrivateKey
+		if err := SaveIdentity(keys, &identity); err != nil {
+			return nil, fmt.Errorf("moving identity into the keystore: %s", err)
+		}
+		return &identity, nil
+	}
+	secret, err := keys.get(identity.ID)
+	if err != nil {
+		return nil, err
+	}
+	identity.PrivateKey = ed25519.PrivateKey(secret)
+	return &identity, nil
+}
+
+// LoadOrCreateIdentity returns the node's identity, creating and saving a
+// new one if there is none yet.
+func LoadOrCreateIdentity(keys *Keystore) (*NodeIdentity, error) {
+	identity, err := LoadIdentity(keys)
+	if err != ErrNotFound {
+		return identity, err
+	}
+	if identity, err = NewNodeIdentity(); err != nil {
+		return nil, err
+	}
+	return identity, SaveIdentity(keys, identity)
+}
+
+// SaveIdentity makes identity the node's identity. The one it replaces is
+// kept in the keystore as a retired key.
+func SaveIdentity(keys *Keystore, identity *NodeIdentity) error {
+	if err := keys.put(identity.ID, KeyKindIdentity, identity.PrivateKey); err != nil {
+		return err
+	}
+	data, err := keys.store.Get(BucketIdentity, identityKey)
+	if err == nil {
+		old := identityRecord{}
+		if err := json.Unmarshal(data, &old); err != nil {
+			return err
+		}
+		if old.ID != identity.ID && old.LegacyPrivateKey == nil {
+			if err := keys.setKind(old.ID, KeyKindRetiredIdentity); err != nil && err != ErrNotFound {
+				return err
+			}
+		}
+	} else if err != ErrNotFound {
+		return err
+	}
+	data, err = json.Marshal(identityRecord{NodeIdentity: *identity})
+	if err != nil {
+		return err
+	}
+	return keys.store.Put(BucketIdentity, identityKey, data)
+}
+
+// RotateIdentity replaces the node's identity with a new key pair.
+func RotateIdentity(keys *Keystore) (*NodeIdentity, error) {
+	identity, err := NewNodeIdentity()
+	if err != nil {
+		return nil, err
+	}
+	return identity, SaveIdentity(keys, identity)
+}
+
+// Export seals the identity under a passphrase of its own.
+func (n *NodeIdentity) Export(passphrase string) (*ExportedIdentity, error) {
+	sealed, err := sealSecret(passphrase, n.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &ExportedIdentity{
+		ID: 		n.ID,
+		PublicKey: 	n.PublicKey,
+		CreatedAt: 	n.CreatedAt,
+		PrivateKey: sealed,
+	}, nil
+}
+
+// Open unseals an exported identity and checks the key pair matches.
+func (e *ExportedIdentity) Open(passphrase string) (*NodeIdentity, error) {
+	secret, err := e.PrivateKey.Open(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if len(secret) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("exported identity %s has a malformed private key", e.ID)
+	}
+	priv := ed25519.PrivateKey(secret)
+	pub := priv.Public().(ed25519.PublicKey)
+	if !pub.Equal(e.PublicKey) {
+		return nil, fmt.Errorf("exported identity %s does not match its public key", e.ID)
+	}
+	return &NodeIdentity{
+		ID: 		identityID(pub),
+		PublicKey: 	pub,
+		PrivateKey: priv,
+		CreatedAt: 	e.CreatedAt,
+	}, nil
+}
@@ -0,0 +1,225 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	KeyKindIdentity 		= "identity"
+	KeyKindRetiredIdentity 	= "retired_identity"
+	KeyKindCard 			= "card"
+)
+
+// storedKey is one keystore record. The secret is sealed with the keystore
+// passphrase, or kept in Plain when the node runs without one.
+type storedKey struct {
+	ID 			string 			`json:"id"`
+	Kind 		string 			`json:"kind"`
+	CreatedAt 	time.Time 		`json:"created_at"`
+	Sealed 		*SealedSecret 	`json:"sealed,omitempty"`
+	Plain 		[]byte 			`json:"plain,omitempty"`
+}
+
+type KeyInfo struct {
+	ID 			string 		`json:"id"`
+	Kind 		string 		`json:"kind"`
+	CreatedAt 	time.Time 	`json:"created_at"`
+	Encrypted 	bool 		`json:"encrypted"`
+}
+
+// Keystore keeps a node's private key material in its storage, encrypted
+// at rest under a passphrase. Everything else refers to keys by ID.
+type Keystore struct {
+	store 		Storage
+	passphrase 	string
+}
+
+func NewKeystore(store Storage, passphrase string) *Keystore {
+	return &Keystore{
+		store: 		store,
+		passphrase: passphrase,
+	}
+}
+
+func (k *Keystore) Encrypted() bool {
+	return k.passphrase != ""
+}
+
+func (k *Keystore) put(id, kind string, secret []byte) error {
+	key := storedKey{
+		ID: 		id,
+		Kind: 		kind,
+		CreatedAt: 	time.Now().UTC(),
+	}
+	if err := k.seal(&key, secret); err != nil {
+		return err
+	}
+	return k.write(key)
+}
+
+func (k *Keystore) seal(key *storedKey, secret []byte) error {
+	key.Sealed = nil
+	key.Plain = nil
+	if !k.Encrypted() {
+		key.Plain = secret
+		return nil
+	}
+	sealed, err := sealSecret(k.passphrase, secret)
+	if err != nil {
+		return err
+	}
+	key.Sealed = sealed
+	return nil
+}
+
+func (k *Keystore) write(key storedKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return k.store.Put(BucketKeys, key.ID, data)
+}
+
+func (k *Keystore) read(id string) (storedKey, error) {
+	var key storedKey
+	data, err := k.store.Get(BucketKeys, id)
+	if err != nil {
+		return key, err
+	}
+	err = json.Unmarshal(data, &key)
+	return key, err
+}
+
+func (k *Keystore) open(key storedKey) ([]byte, error) {
+	if key.Sealed == nil {
+		return key.Plain, nil
+	}
+	if !k.Encrypted() {
+		return nil, fmt.Errorf("key %s is encrypted but no passphrase was given", key.ID)
+	}
+	secret, err := key.Sealed.Open(k.passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %s", key.ID, err)
+	}
+	return secret, nil
+}
+
+func (k *Keystore) get(id string) ([]byte, error) {
+	key, err := k.read(id)
+	if err != nil {
+		return nil, err
+	}
+	return k.open(key)
+}
+
+// setKind moves a key to another kind without touching the secret.
+func (k *Keystore) setKind(id, kind string) error {
+	key, err := k.read(id)
+	if err != nil {
+		return err
+	}
+	key.Kind = kind
+	return k.write(key)
+}
+
+func (k *Keystore) List() ([]KeyInfo, error) {
+	records, err := k.store.List(BucketKeys)
+	if err != nil {
+		return nil, err
+	}
+	infos := []KeyInfo{}
+	for _, record := range records {
+		var key storedKey
+		if err := json.Unmarshal(record.Value, &key); err != nil {
+			return nil, fmt.Errorf("corrupt key %s: %s", record.Key, err)
+		}
+		infos = append(infos, KeyInfo{
+			ID: 		key.ID,
+			Kind: 		key.Kind,
+			CreatedAt: 	key.CreatedAt,
+			Encrypted: 	key.Sealed != nil,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].CreatedAt.Before(infos[j].CreatedAt) })
+	return infos, nil
+}
+
+// ChangePassphrase seals every key again under a new passphrase. An empty
+// passphrase leaves the keys unencrypted.
+func (k *Keystore) ChangePassphrase(passphrase string) error {
+	infos, err := k.List()
+	if err != nil {
+		return err
+	}
+	// open everything first so a wrong old passphrase changes nothing
+	keys := make([]storedKey, len(infos))
+	secrets := make([][]byte, len(infos))
+	for i, info := range infos {
+		if keys[i], err = k.read(info.ID); err != nil {
+			return err
+		}
+		if secrets[i], err = k.open(keys[i]); err != nil {
+			return err
+		}
+	}
+	k.passphrase = passphrase
+	for i := range keys {
+		if err := k.seal(&keys[i], secrets[i]); err != nil {
+			return err
+		}
+		if err := k.write(keys[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutCardKeys stores our deck keys and returns the ID snapshots refer to
+// them by.
+func (k *Keystore) PutCardKeys(keys *CardKeys) (string, error) {
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return "", err
+	}
+	// the ID is random so it tells nothing about the keys
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	id := KeyKindCard + "-" + hex.EncodeToString(random)
+	return id, k.put(id, KeyKindCard, data)
+}
+
+func (k *Keystore) CardKeys(id string) (*CardKeys, error) {
+	data, err := k.get(id)
+	if err != nil {
+		return nil, fmt.Errorf("loading deck keys %s: %s", id, err)
+	}
+	keys := &CardKeys{}
+	if err := json.Unmarshal(data, keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// dropCardKeys deletes every deck key except the one in use. Old ones are
+// of no use once no snapshot refers to them.
+func (k *Keystore) dropCardKeys(keep string) error {
+	infos, err := k.List()
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.Kind == KeyKindCard && info.ID != keep {
+			if err := k.store.Delete(BucketKeys, info.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -1,5 +1,7 @@
 package p2p
 
+import "crypto/ed25519"
+
 type Message struct {
 	Payload any 
 	From string
@@ -21,6 +23,27 @@ type Handshake struct {
 	Version string 
 	GameVariant GameVariant
 	ListenAddr string 
+	// PublicKey is the node's identity key, which control messages are
+	// signed with. HostKey is the key of the table's host as far as the
+	// node knows, empty if it does not know yet.
+	PublicKey ed25519.PublicKey
+	HostKey ed25519.PublicKey
+	// Config is the table config as the node has it. A node joining the
+	// table takes it from the peer it joins through.
+	Config TableConfig
+	// DeckCommitment commits to the node's deck key so its partial
+	// decryptions can be checked.
+	DeckCommitment []byte
+	// Nonce challenges the other node to prove it holds PublicKey. Proof
+	// answers the nonce of the handshake this one replies to, and is empty
+	// in the handshake that opens a connection.
+	Nonce []byte
+	Proof []byte
+}
+
+// HandshakeProof answers the nonce of the handshake that replied to ours.
+type HandshakeProof struct {
+	Signature []byte
 }
 
 type MessagePeerList struct {
@@ -46,6 +69,9 @@ type MessageEncDeck struct {
 type MessageGameState struct {
 	Status GameStatus
 	CommunityCards []int
+	// Deck is the fully encrypted deck, sent with the move to pre-flop so
+	// every node can check the reveals made from it.
+	Deck [][]byte
 }
 
 type MessagePreShuffle struct {
@@ -67,12 +93,87 @@ type MessageRPCResponse struct {
 	DecryptedData [][]byte
 }
 
-type MessageRevealKeys struct {
-	Keys *CardKeys
+type RevealKind byte
+
+const (
+	RevealHand RevealKind = iota
+	RevealBoard
+	RevealRabbit
+)
+
+// RevealLayer is one player's partial decryption of the cards in a reveal,
+// with a proof for every card that it was made with their key.
+type RevealLayer struct {
+	Player 	string
+	Data 	[][]byte
+	Proofs 	[]DecryptionProof
 }
 
+// MessageRevealRequest travels the ring to reveal cards to the whole table.
+// Each player strips their layer from the last entry in Layers, adds their
+// own and passes it to the next player in seat order. The last one before
+// the owner announces every layer with MessageCardsRevealed.
+type MessageRevealRequest struct {
+	Owner 		string
+	Kind 		RevealKind
+	HandNumber 	int
+	Indices 	[]int
+	Layers 		[]RevealLayer
+}
+
+// MessageCardsRevealed carries every layer of a finished reveal so each
+// node can follow the cards from the encrypted deck to the plaintext itself.
+type MessageCardsRevealed struct {
+	Owner 		string
+	Kind 		RevealKind
+	HandNumber 	int
+	Indices 	[]int
+	Layers 		[]RevealLayer
+}
+
+type MessageRabbitHunt struct {
+	HandNumber int
+}
+
+type MessageMuck struct {}
+
 type MessageShowdownResult struct {
 	PlayerAddr string 
 	HandRank int32 
 	HandName string
+}
+
+// MessageTakeSeat claims a seat. ClaimedAt is the claimant's clock in Unix
+// nanoseconds and decides between claims for the same seat.
+type MessageTakeSeat struct {
+	Seat 		int
+	ClaimedAt 	int64
+}
+
+type MessageLeaveSeat struct {}
+
+type MessageJoinWaitList struct {
+	JoinedAt 	int64
+}
+
+type MessageStraddle struct {
+	Kind StraddleKind
+}
+
+type MessageRunIt struct {
+	Runs int
+}
+
+// MessageControl carries a table control command and the signatures
+// approving it so far. Command is the JSON of a ControlCommand exactly as it
+// was signed.
+type MessageControl struct {
+	Command 	[]byte
+	Signatures 	[]ControlSignature
+}
+
+type MessageBuyIn struct {
+	Kind 	BuyInKind
+	Amount 	int
+	TxHash 	string
 }
\ No newline at end of file
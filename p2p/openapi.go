@@ -0,0 +1,339 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	openAPIVersion = "3.0.3"
+	// apiVersion changes whenever a client written against the document
+	// would break
+	apiVersion = "1.0.0"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaBuilder turns Go types into OpenAPI schemas the way encoding/json
+// would encode them. Named structs go into components and are referenced.
+type schemaBuilder struct {
+	schemas map[string]any
+}
+
+func (b *schemaBuilder) schemaOf(t reflect.Type) (map[string]any, error) {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}, nil
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return b.schemaOf(t.Elem())
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]any{"type": "integer"}, nil
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}, nil
+	case reflect.String:
+		return map[string]any{"type": "string"}, nil
+	case reflect.Interface:
+		return map[string]any{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}, nil
+		}
+		items, err := b.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("%s: only maps with string keys can be described", t)
+		}
+		values, err := b.schemaOf(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+		if _, ok := b.schemas[t.Name()]; !ok {
+			// placeholder so recursive types end
+			b.schemas[t.Name()] = nil
+			schema, err := b.structSchema(t)
+			if err != nil {
+				return nil, err
+			}
+			b.schemas[t.Name()] = schema
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}, nil
+	default:
+		return nil, fmt.Errorf("%s: cannot describe a %s", t, t.Kind())
+	}
+}
+
+func (b *schemaBuilder) structSchema(t reflect.Type) (map[string]any, error) {
+	properties := map[string]any{}
+	required := []string{}
+	if err := b.addFields(t, properties, &required); err != nil {
+		return nil, err
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema, nil
+}
+
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]any, required *[]string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			if err := b.addFields(field.Type, properties, required); err != nil {
+				return err
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema, err := b.schemaOf(field.Type)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %s", t.Name(), field.Name, err)
+		}
+		properties[name] = schema
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+	return nil
+}
+
+// OpenAPISpec describes the HTTP API as an OpenAPI 3 document, built from the
+// same route table the API server routes with.
+func OpenAPISpec() (map[string]any, error) {
+	return (&APIServer{}).openAPISpec()
+}
+
+func (s *APIServer) openAPISpec() (map[string]any, error) {
+	b := &schemaBuilder{schemas: map[string]any{}}
+	errorSchema, err := b.schemaOf(reflect.TypeOf(APIError{}))
+	if err != nil {
+		return nil, err
+	}
+	errorResponse := map[string]any{
+		"description": "The request failed, see code",
+		"content": map[string]any{"application/json": map[string]any{"schema": errorSchema}},
+	}
+
+	paths := map[string]any{}
+	for _, route := range s.routes() {
+		op, err := b.operation(route, errorResponse)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %s", route.Method, route.Path, err)
+		}
+		item, ok := paths[route.Path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": openAPIVersion,
+		"info": map[string]any{
+			"title": 	"Peer Poker node API",
+			"version": 	apiVersion,
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				// for WebSockets and EventSources, which cannot set headers
+				"token": map[string]any{"type": "apiKey", "in": "query", "name": "token"},
+			},
+		},
+	}, nil
+}
+
+func (b *schemaBuilder) operation(route apiRoute, errorResponse map[string]any) (map[string]any, error) {
+	op := map[string]any{
+		"summary": route.Summary,
+		"responses": map[string]any{"default": errorResponse},
+	}
+	if route.Scope != ScopeNone {
+		op["security"] = []any{
+			map[string]any{"bearer": []string{}},
+			map[string]any{"token": []string{}},
+		}
+		op["x-scope"] = route.Scope.String()
+	}
+	if route.RPC != "" {
+		op["x-grpc"] = GRPCService + "/" + route.RPC
+	}
+
+	params := []any{}
+	for _, segment := range strings.Split(route.Path, "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			params = append(params, map[string]any{
+				"name": 	strings.TrimSuffix(name, "}"),
+				"in": 		"path",
+				"required": true,
+				"schema": 	map[string]any{"type": "string"},
+			})
+		}
+	}
+	for _, param := range route.Query {
+		params = append(params, map[string]any{
+			"name": 		param.Name,
+			"in": 			"query",
+			"description": 	param.Description,
+			"schema": 		map[string]any{"type": param.Type},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if route.Request != nil {
+		schema, err := b.schemaOf(reflect.TypeOf(route.Request))
+		if err != nil {
+			return nil, err
+		}
+		op["requestBody"] = map[string]any{
+			"content": map[string]any{"application/json": map[string]any{"schema": schema}},
+		}
+	}
+
+	responses := op["responses"].(map[string]any)
+	var schema map[string]any
+	if route.Response != nil {
+		var err error
+		if schema, err = b.schemaOf(reflect.TypeOf(route.Response)); err != nil {
+			return nil, err
+		}
+	}
+	switch route.Stream {
+	case "websocket":
+		responses["101"] = map[string]any{
+			"description": "Switched to a WebSocket carrying one JSON event per message",
+			"x-message": schema,
+		}
+	case "":
+		ok := map[string]any{"description": "OK"}
+		if schema != nil {
+			ok["content"] = map[string]any{"application/json": map[string]any{"schema": schema}}
+		}
+		responses["200"] = ok
+	default:
+		responses["200"] = map[string]any{
+			"description": "A stream of events",
+			"content": map[string]any{route.Stream: map[string]any{"schema": schema}},
+		}
+	}
+	return op, nil
+}
+
+func (s *APIServer) handleOpenAPI(w http.ResponseWriter, r *http.Request) error {
+	spec, err := s.openAPISpec()
+	if err != nil {
+		return err
+	}
+	return JSON(w, http.StatusOK, spec)
+}
+
+// MarshalOpenAPISpec is the document as it is committed for API clients to
+// generate code from.
+func MarshalOpenAPISpec() ([]byte, error) {
+	spec, err := OpenAPISpec()
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+// CheckOpenAPI makes sure the API and a committed copy of its document agree:
+// every route the router serves is described in the committed document,
+// nothing else is, and the document is the one the handlers produce today.
+func CheckOpenAPI(committed []byte) error {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(committed, &doc); err != nil {
+		return fmt.Errorf("committed document is not valid JSON: %s", err)
+	}
+	described := map[string]bool{}
+	for path, item := range doc.Paths {
+		for method := range item {
+			described[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+	served := map[string]bool{}
+	err := (&APIServer{}).router().Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			// the catch-all preflight route has no path
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		for _, method := range methods {
+			served[method+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	problems := []string{}
+	for route := range served {
+		if !described[route] {
+			problems = append(problems, route+" is served but not described")
+		}
+	}
+	for route := range described {
+		if !served[route] {
+			problems = append(problems, route+" is described but not served")
+		}
+	}
+
+	current, err := MarshalOpenAPISpec()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(bytes.TrimSpace(committed), bytes.TrimSpace(current)) {
+		problems = append(problems, "the committed document is out of date, regenerate it with 'peerpoker openapi'")
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("API and OpenAPI document diverge:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
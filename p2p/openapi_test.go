@@ -0,0 +1,160 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// sampleValue fills a value of t with something other than its zero value,
+// so a handler decoding into a type that disagrees with the document fails.
+func sampleValue(t reflect.Type) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Pointer:
+		v.Set(reflect.New(t.Elem()))
+		v.Elem().Set(sampleValue(t.Elem()))
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(1)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(1)
+	case reflect.String:
+		v.SetString("sample")
+	case reflect.Struct:
+		if t == timeType {
+			break
+		}
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				v.Field(i).Set(sampleValue(t.Field(i).Type))
+			}
+		}
+	}
+	return v
+}
+
+func newContractAPIServer(t *testing.T) *APIServer {
+	t.Helper()
+	game := NewGame(":3000", TableConfig{}, drainBroadcasts())
+	identity, err := NewNodeIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	game.SetIdentity(identity, true)
+	stats, err := NewStatsTracker(NewMemoryStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{peers: make(map[string]*Peer), gameState: game, stats: stats}
+	return NewAPIServer("", game, server, AuthConfig{})
+}
+
+// TestRouteContracts calls the handler of every route with a request of the
+// documented type and decodes what it answers against the documented
+// response. A handler that reads or writes anything else fails.
+func TestRouteContracts(t *testing.T) {
+	s := newContractAPIServer(t)
+	answered := 0
+	for _, route := range s.routes() {
+		if route.Stream != "" {
+			continue
+		}
+		name := route.Method + " " + route.Path
+		t.Run(name, func(t *testing.T) {
+			var body []byte
+			if route.Request != nil {
+				var err error
+				if body, err = json.Marshal(sampleValue(reflect.TypeOf(route.Request)).Interface()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			path := strings.NewReplacer("{id}", "1", "{player}", s.game.listenAddr).Replace(route.Path)
+			req := httptest.NewRequest(route.Method, path, bytes.NewReader(body))
+			req = mux.SetURLVars(req, map[string]string{"id": "1", "player": s.game.listenAddr})
+			w := httptest.NewRecorder()
+			makeHTTPHandlerFunc(route.Handler)(w, req)
+
+			if w.Code != http.StatusOK {
+				var apiErr APIError
+				if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil {
+					t.Fatalf("error answer is not an APIError: %s: %s", err, w.Body)
+				}
+				if apiErr.Code == CodeInvalidJSON {
+					t.Fatalf("handler cannot decode the documented request %s: %s", body, apiErr.Message)
+				}
+				return
+			}
+			answered++
+			if route.Response == nil {
+				return
+			}
+			assertDecodesAs(t, w.Body.Bytes(), reflect.TypeOf(route.Response))
+		})
+	}
+	if answered == 0 {
+		t.Fatal("no route answered, the contract was not checked")
+	}
+}
+
+// assertDecodesAs fails unless data is exactly what encoding a value of t
+// would give: no unknown fields, and no documented field left out.
+func assertDecodesAs(t *testing.T, data []byte, typ reflect.Type) {
+	t.Helper()
+	value := reflect.New(typ)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(value.Interface()); err != nil {
+		t.Fatalf("answer does not decode as %s: %s: %s", typ, err, data)
+	}
+	encoded, err := json.Marshal(value.Interface())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got, want any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(encoded, &want); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("answer is not a %s:\n got %s\nwant %s", typ, bytes.TrimSpace(data), encoded)
+	}
+}
+
+func TestCommittedOpenAPI(t *testing.T) {
+	committed, err := os.ReadFile("../frontend/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckOpenAPI(committed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckOpenAPIFindsUndescribedRoutes(t *testing.T) {
+	spec, err := OpenAPISpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(spec["paths"].(map[string]any), "/api/health")
+	stale, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = CheckOpenAPI(stale)
+	if err == nil || !strings.Contains(err.Error(), "GET /api/health is served but not described") {
+		t.Fatalf("want /api/health reported as undescribed, got %v", err)
+	}
+}
@@ -1,60 +1,704 @@
 package p2p
 
 import (
+	"bytes"
+	"crypto/ed25519"
 	"encoding/json"
-	"os"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
+// snapshotInterval is how many events may pile up in the log before a new
+// snapshot is taken. A snapshot is also taken whenever a hand completes.
+const snapshotInterval = 50
+
+const (
+	EventPlayerJoined 	= "player_joined"
+	EventPlayerLeft 	= "player_left"
+	EventPlayerReady 	= "player_ready"
+	EventSeatChanged 	= "seat_changed"
+	EventBuyIn 			= "buy_in"
+	EventHandStarted 	= "hand_started"
+	EventPlayerAction 	= "player_action"
+	EventStreet 		= "street"
+	EventHandComplete 	= "hand_complete"
+	EventControl 		= "control"
+	EventTableConfig 	= "table_config"
+	EventDeck 			= "deck"
+	EventCardsDealt 	= "cards_dealt"
+	EventCardsRevealed 	= "cards_revealed"
+	EventShowdown 		= "showdown"
+	EventRunItVote 		= "run_it_vote"
+	EventStraddle 		= "straddle"
+	EventWaitList 		= "wait_list"
+)
+
+// SnapshotVersion is the current snapshot format. Version 1 is the format
+// from before snapshots were versioned, which had no Version field at all.
+const SnapshotVersion = 3
+
+// GameSnapshot holds everything needed to resume a game, including in the
+// middle of a hand. Our deck keys live in the keystore and the snapshot only
+// refers to them by ID. Version 2 snapshots carried them inline in DeckKeys
+// or SealedDeckKeys; recovery moves those into the keystore.
 type GameSnapshot struct {
-	CurrentStatus 	int32 
-	CurrentPot 		int 
-	PlayerStates 	map[string]*PlayerState
-	RotationMap 	map[int]string 
-	CurrentDealerID int 
-	HighestBet 		int 
-	CommunityCards 	[]Card
+	Version 			int
+	Seq 				uint64
+	CurrentStatus 		int32
+	HandNumber 			int
+	PlayersList 		[]string
+	PlayerStates 		map[string]*PlayerState
+	RotationMap 		map[int]string
+	NextRotationID 		int
+	HandSeats 			int
+	WaitingList 		[]string
+	CurrentDealerID 	int
+	SmallBlindID 		int
+	BigBlindID 			int
+	CurrentPlayerTurnID int
+	TurnNumber 			int
+	CurrentPot 			int
+	SidePots 			[]SidePot
+	HighestBet 			int
+	LastRaiserID 		int
+	LastRaiseAmount 	int
+	LastAggressorID 	int
+	Straddles 			map[string]StraddleKind
+	StraddleAmount 		int
+	PendingBuyIns 		[]LedgerEntry
+	Ledger 				[]LedgerEntry
+	RunOut 				bool
+	RunOutFrom 			int
+	RunItPending 		bool
+	RunItVotes 			map[string]int
+	RunItTimes 			int
+	RunResults 			[]RunResult
+	ShowOrder 			[]string
+	ShownCards 			map[string][]Card
+	Mucked 				map[string]bool
+	RevealedCards 		map[int]Card
+	CommunityCards 		[]Card
+	MyHand 				[]Card
+	CurrentDeck 		[][]byte
+	DeckKeyID 			string 			`json:",omitempty"`
+	DeckKeys 			*CardKeys 		`json:",omitempty"`
+	SealedDeckKeys 		*SealedSecret 	`json:",omitempty"`
+	// Config is the table config as control commands left it
+	Config 				*TableConfig 		`json:",omitempty"`
+	PendingConfig 		*TableConfigChange 	`json:",omitempty"`
+	Paused 				bool 				`json:",omitempty"`
+	Banned 				[]string 			`json:",omitempty"`
+	HostKey 			[]byte 				`json:",omitempty"`
 }
 
-func (g *Game) SaveSnapshot(filename string) error {
-	g.lock.RLock()
-	defer g.lock.RUnlock()
+// snapshotMigrations upgrade a decoded snapshot from the version they are
+// keyed by to the next one.
+var snapshotMigrations = map[int]func(map[string]any) error{
+	1: migrateSnapshotV1,
+	// version 3 only adds DeckKeyID, inline keys are moved on restore
+	2: func(map[string]any) error { return nil },
+}
 
-	snapshot := GameSnapshot{
-		CurrentStatus: g.currentStatus.Get(),
-		CurrentPot: g.currentPot,
-		PlayerStates: g.playerStates,
-		RotationMap: g.rotationMap,
-		CurrentDealerID: g.currentDealerID,
-		HighestBet: g.highestBet,
-		CommunityCards: g.communityCards,
+// migrateSnapshotV1 fills in what version 1 snapshots did not record. The
+// seat count and players follow from the seats and player states, and the
+// per-hand pointers start unset, as they do in a new game.
+func migrateSnapshotV1(raw map[string]any) error {
+	nextRotationID := 0
+	if rotationMap, ok := raw["RotationMap"].(map[string]any); ok {
+		for key := range rotationMap {
+			var seat int
+			if _, err := fmt.Sscan(key, &seat); err != nil {
+				return fmt.Errorf("bad seat %q: %s", key, err)
+			}
+			nextRotationID = max(nextRotationID, seat+1)
+		}
+	}
+	players := []any{}
+	if states, ok := raw["PlayerStates"].(map[string]any); ok {
+		for addr := range states {
+			players = append(players, addr)
+		}
 	}
+	raw["NextRotationID"] = nextRotationID
+	raw["PlayersList"] = players
+	raw["SmallBlindID"] = -1
+	raw["BigBlindID"] = -1
+	raw["CurrentPlayerTurnID"] = -1
+	raw["LastRaiserID"] = -1
+	raw["LastAggressorID"] = -1
+	raw["RunItTimes"] = 1
+	return nil
+}
 
-	data, err := json.MarshalIndent(snapshot, "", " ")
+// decodeSnapshot reads a snapshot of any known version and migrates it to
+// the current one.
+func decodeSnapshot(data []byte) (GameSnapshot, error) {
+	var snapshot GameSnapshot
+	// numbers stay as written so key material survives the round trip
+	raw := map[string]any{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return snapshot, err
+	}
+	version := 1
+	if v, ok := raw["Version"].(json.Number); ok {
+		n, err := v.Int64()
+		if err != nil {
+			return snapshot, fmt.Errorf("bad snapshot version %s", v)
+		}
+		version = int(n)
+	}
+	if version > SnapshotVersion {
+		return snapshot, fmt.Errorf("snapshot version %d is newer than this build supports (%d)", version, SnapshotVersion)
+	}
+	for ; version < SnapshotVersion; version++ {
+		migrate, ok := snapshotMigrations[version]
+		if !ok {
+			return snapshot, fmt.Errorf("no migration from snapshot version %d", version)
+		}
+		if err := migrate(raw); err != nil {
+			return snapshot, fmt.Errorf("migrating snapshot from version %d: %s", version, err)
+		}
+		raw["Version"] = version + 1
+	}
+	migrated, err := json.Marshal(raw)
 	if err != nil {
-		return err
+		return snapshot, err
 	}
-	return os.WriteFile(filename, data, 0644)
+	err = json.Unmarshal(migrated, &snapshot)
+	return snapshot, err
+}
+
+// Event is one state transition of the game. Changes holds the snapshot
+// fields the transition changed, encoded the way a snapshot encodes them.
+// Recovery applies the changes of every event after the last snapshot to it
+// in order, so replaying the log never has to run the game logic again.
+type Event struct {
+	Seq 	uint64 						`json:"seq"`
+	Time 	time.Time 					`json:"time"`
+	Kind 	string 						`json:"kind"`
+	Player 	string 						`json:"player,omitempty"`
+	Changes map[string]json.RawMessage 	`json:"changes"`
+}
+
+// EventLog is an append-only log of events kept in the node's storage, one
+// record per event keyed by sequence number. Every append is durable before
+// the game moves on.
+type EventLog struct {
+	lock 	sync.Mutex
+	store 	Storage
+	lastSeq uint64
 }
 
-func (g *Game) LoadSnapshot(filename string) error {
-	data, err := os.ReadFile(filename)
+func OpenEventLog(store Storage) (*EventLog, error) {
+	l := &EventLog{store: store}
+	records, err := store.List(BucketEvents)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var snapshot GameSnapshot
-	if err := json.Unmarshal(data, &snapshot); err != nil {
+	if len(records) > 0 {
+		fmt.Sscan(records[len(records)-1].Key, &l.lastSeq)
+	}
+	return l, nil
+}
+
+func (l *EventLog) Append(kind, player string, changes map[string]json.RawMessage) (uint64, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	event := Event{
+		Seq: 	l.lastSeq + 1,
+		Time: 	time.Now(),
+		Kind: 	kind,
+		Player: player,
+		Changes: changes,
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	if err := l.store.Put(BucketEvents, seqKey(event.Seq), data); err != nil {
+		return 0, err
+	}
+	l.lastSeq = event.Seq
+	return event.Seq, nil
+}
+
+// ReadAfter returns the events with a sequence number above seq.
+func (l *EventLog) ReadAfter(seq uint64) ([]Event, error) {
+	records, err := l.store.List(BucketEvents)
+	if err != nil {
+		return nil, err
+	}
+	events := []Event{}
+	for _, record := range records {
+		var event Event
+		if err := json.Unmarshal(record.Value, &event); err != nil {
+			logrus.Warnf("Skipping unreadable event %s: %s", record.Key, err)
+			continue
+		}
+		if event.Seq > seq {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Truncate drops every event once a snapshot covers them. Sequence numbers
+// carry on from where they were.
+func (l *EventLog) Truncate() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	records, err := l.store.List(BucketEvents)
+	if err != nil {
 		return err
 	}
-	g.lock.Lock()
-	defer g.lock.Unlock()
+	for _, record := range records {
+		if err := l.store.Delete(BucketEvents, record.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LastSeq is the sequence number of the last event appended.
+func (l *EventLog) LastSeq() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.lastSeq
+}
+
+// skipTo makes sure new events are numbered after seq, which a snapshot may
+// cover even when the log itself is empty.
+func (l *EventLog) skipTo(seq uint64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.lastSeq = max(l.lastSeq, seq)
+}
+
+// snapshot copies the game state. The caller must hold the game lock.
+func (g *Game) snapshot() GameSnapshot {
+	playerStates := make(map[string]*PlayerState, len(g.playerStates))
+	for addr, state := range g.playerStates {
+		copied := *state
+		playerStates[addr] = &copied
+	}
+	rotationMap := make(map[int]string, len(g.rotationMap))
+	for seat, addr := range g.rotationMap {
+		rotationMap[seat] = addr
+	}
+	straddles := make(map[string]StraddleKind, len(g.straddles))
+	for addr, kind := range g.straddles {
+		straddles[addr] = kind
+	}
+	runItVotes := make(map[string]int, len(g.runItVotes))
+	for addr, runs := range g.runItVotes {
+		runItVotes[addr] = runs
+	}
+	shownCards := make(map[string][]Card, len(g.shownCards))
+	for addr, cards := range g.shownCards {
+		shownCards[addr] = append([]Card{}, cards...)
+	}
+	mucked := make(map[string]bool, len(g.mucked))
+	for addr, m := range g.mucked {
+		mucked[addr] = m
+	}
+	revealedCards := make(map[int]Card, len(g.revealedCards))
+	for idx, card := range g.revealedCards {
+		revealedCards[idx] = card
+	}
+	banned := make([]string, 0, len(g.banned))
+	for id := range g.banned {
+		banned = append(banned, id)
+	}
+	sort.Strings(banned)
+	config := g.config
+	return GameSnapshot{
+		Version: 			SnapshotVersion,
+		CurrentStatus: 		g.currentStatus.Get(),
+		HandNumber: 		g.handNumber,
+		PlayersList: 		g.playersList.List(),
+		PlayerStates: 		playerStates,
+		RotationMap: 		rotationMap,
+		NextRotationID: 	g.nextRotationID,
+		HandSeats: 			g.handSeats,
+		WaitingList: 		append([]string{}, g.waitingList...),
+		CurrentDealerID: 	g.currentDealerID,
+		SmallBlindID: 		g.smallBlindID,
+		BigBlindID: 		g.bigBlindID,
+		CurrentPlayerTurnID: g.currentPlayerTurnID,
+		TurnNumber: 		g.turnNumber,
+		CurrentPot: 		g.currentPot,
+		SidePots: 			append([]SidePot{}, g.sidePots...),
+		HighestBet: 		g.highestBet,
+		LastRaiserID: 		g.lastRaiserID,
+		LastRaiseAmount: 	g.lastRaiseAmount,
+		LastAggressorID: 	g.lastAggressorID,
+		Straddles: 			straddles,
+		StraddleAmount: 	g.straddleAmount,
+		PendingBuyIns: 		append([]LedgerEntry{}, g.pendingBuyIns...),
+		Ledger: 			g.ledger.Entries(),
+		RunOut: 			g.runOut,
+		RunOutFrom: 		g.runOutFrom,
+		RunItPending: 		g.runItPending,
+		RunItVotes: 		runItVotes,
+		RunItTimes: 		g.runItTimes,
+		RunResults: 		append([]RunResult{}, g.runResults...),
+		ShowOrder: 			append([]string{}, g.showOrder...),
+		ShownCards: 		shownCards,
+		Mucked: 			mucked,
+		RevealedCards: 		revealedCards,
+		CommunityCards: 	append([]Card{}, g.communityCards...),
+		MyHand: 			append([]Card{}, g.myHand...),
+		CurrentDeck: 		append([][]byte{}, g.currentDeck...),
+		DeckKeyID: 			g.deckKeyID,
+		Config: 			&config,
+		PendingConfig: 		g.pendingConfig,
+		Paused: 			g.paused,
+		Banned: 			banned,
+		HostKey: 			g.hostKey,
+	}
+}
+
+// restore puts the game back into a snapshotted state. Deck keys are
+// loaded from the keystore when the snapshot refers to other keys than the
+// ones in use. The caller must hold the game lock.
+func (g *Game) restore(snapshot GameSnapshot) error {
+	switch {
+	case snapshot.DeckKeyID != "" && snapshot.DeckKeyID != g.deckKeyID:
+		if g.keys == nil {
+			return fmt.Errorf("snapshot needs deck keys %s but there is no keystore", snapshot.DeckKeyID)
+		}
+		keys, err := g.keys.CardKeys(snapshot.DeckKeyID)
+		if err != nil {
+			return err
+		}
+		g.deckKeys = keys
+		g.deckKeyID = snapshot.DeckKeyID
+	case snapshot.SealedDeckKeys != nil:
+		passphrase := ""
+		if g.keys != nil {
+			passphrase = g.keys.passphrase
+		}
+		data, err := snapshot.SealedDeckKeys.Open(passphrase)
+		if err != nil {
+			return fmt.Errorf("unsealing deck keys: %s", err)
+		}
+		keys := &CardKeys{}
+		if err := json.Unmarshal(data, keys); err != nil {
+			return err
+		}
+		g.deckKeys = keys
+		g.deckKeyID = ""
+	case snapshot.DeckKeys != nil:
+		g.deckKeys = snapshot.DeckKeys
+		g.deckKeyID = ""
+	}
 
 	g.currentStatus.Set(snapshot.CurrentStatus)
-	g.currentPot = snapshot.CurrentPot
+	g.handNumber = snapshot.HandNumber
 	g.playerStates = snapshot.PlayerStates
 	g.rotationMap = snapshot.RotationMap
+	g.nextRotationID = snapshot.NextRotationID
+	g.handSeats = snapshot.HandSeats
+	if g.handSeats == 0 {
+		// older snapshots had no seat count per hand
+		g.handSeats = g.nextRotationID
+	}
+	g.waitingList = snapshot.WaitingList
 	g.currentDealerID = snapshot.CurrentDealerID
+	g.smallBlindID = snapshot.SmallBlindID
+	g.bigBlindID = snapshot.BigBlindID
+	g.currentPlayerTurnID = snapshot.CurrentPlayerTurnID
+	g.turnNumber = snapshot.TurnNumber
+	g.currentPot = snapshot.CurrentPot
+	g.sidePots = snapshot.SidePots
 	g.highestBet = snapshot.HighestBet
+	g.lastRaiserID = snapshot.LastRaiserID
+	g.lastRaiseAmount = snapshot.LastRaiseAmount
+	g.lastAggressorID = snapshot.LastAggressorID
+	g.straddles = snapshot.Straddles
+	g.straddleAmount = snapshot.StraddleAmount
+	g.pendingBuyIns = snapshot.PendingBuyIns
+	g.ledger = NewChipLedger()
+	for _, entry := range snapshot.Ledger {
+		g.ledger.record(entry)
+	}
+	g.runOut = snapshot.RunOut
+	g.runOutFrom = snapshot.RunOutFrom
+	g.runItPending = snapshot.RunItPending
+	g.runItVotes = snapshot.RunItVotes
+	g.runItTimes = max(snapshot.RunItTimes, 1)
+	g.runResults = snapshot.RunResults
+	g.showOrder = snapshot.ShowOrder
+	g.shownCards = snapshot.ShownCards
+	g.mucked = snapshot.Mucked
+	g.revealedCards = snapshot.RevealedCards
 	g.communityCards = snapshot.CommunityCards
-	
+	g.myHand = snapshot.MyHand
+	g.currentDeck = snapshot.CurrentDeck
+	if snapshot.Config != nil {
+		g.config = snapshot.Config.withDefaults()
+	}
+	g.pendingConfig = snapshot.PendingConfig
+	g.paused = snapshot.Paused
+	g.banned = make(map[string]bool, len(snapshot.Banned))
+	for _, id := range snapshot.Banned {
+		g.banned[id] = true
+	}
+	if len(snapshot.HostKey) == ed25519.PublicKeySize {
+		g.hostKey = snapshot.HostKey
+	}
+
+	if g.playerStates == nil {
+		g.playerStates = make(map[string]*PlayerState)
+	}
+	if g.rotationMap == nil {
+		g.rotationMap = make(map[int]string)
+	}
+	if g.straddles == nil {
+		g.straddles = make(map[string]StraddleKind)
+	}
+	if g.runItVotes == nil {
+		g.runItVotes = make(map[string]int)
+	}
+	if g.shownCards == nil {
+		g.shownCards = make(map[string][]Card)
+	}
+	if g.mucked == nil {
+		g.mucked = make(map[string]bool)
+	}
+	if g.revealedCards == nil {
+		g.revealedCards = make(map[int]Card)
+	}
+	if g.waitingList == nil {
+		g.waitingList = []string{}
+	}
+	if _, ok := g.playerStates[g.listenAddr]; !ok {
+		g.playerStates[g.listenAddr] = &PlayerState{ListenAddr: g.listenAddr, IsActive: true}
+	}
+	for addr, state := range g.playerStates {
+		if addr == g.listenAddr {
+			state.IsActive = true
+			continue
+		}
+		// peers count as gone until they handshake with us again
+		state.IsActive = false
+	}
+	g.playersList = NewPlayersList()
+	g.playersList.add(g.listenAddr)
 	return nil
-}
\ No newline at end of file
+}
+
+const snapshotKey = "latest"
+
+// SaveSnapshot writes the game state to storage outside the usual schedule,
+// for example before a planned shutdown.
+func (g *Game) SaveSnapshot() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.store == nil {
+		return fmt.Errorf("persistence is not enabled")
+	}
+	return g.saveSnapshot(g.wal.LastSeq())
+}
+
+func writeSnapshot(store Storage, snapshot GameSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return store.Put(BucketSnapshot, snapshotKey, data)
+}
+
+// snapshotFields splits an encoded snapshot into its top level fields, the
+// unit events record changes in.
+func snapshotFields(snapshot GameSnapshot) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "Seq")
+	return fields, nil
+}
+
+// replayEvents applies the changes of events to a snapshot in order.
+func replayEvents(snapshot GameSnapshot, events []Event) (GameSnapshot, error) {
+	fields, err := snapshotFields(snapshot)
+	if err != nil {
+		return snapshot, err
+	}
+	for _, event := range events {
+		for name, value := range event.Changes {
+			fields[name] = value
+		}
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return snapshot, err
+	}
+	var replayed GameSnapshot
+	if err := json.Unmarshal(data, &replayed); err != nil {
+		return snapshot, err
+	}
+	return replayed, nil
+}
+
+func readSnapshot(store Storage) (GameSnapshot, error) {
+	data, err := store.Get(BucketSnapshot, snapshotKey)
+	if err != nil {
+		return GameSnapshot{}, err
+	}
+	return decodeSnapshot(data)
+}
+
+// EnablePersistence recovers the game from the last snapshot and the event
+// log written after it, then keeps logging every transition. It returns the
+// peers the recovered table knew about so the node can rejoin them.
+func (g *Game) EnablePersistence(store Storage, keys *Keystore) ([]string, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.keys = keys
+
+	wal, err := OpenEventLog(store)
+	if err != nil {
+		return nil, err
+	}
+	var seq uint64
+	recovered := false
+	snapshot, err := readSnapshot(store)
+	switch {
+	case err == nil:
+		seq = snapshot.Seq
+		recovered = true
+	case err == ErrNotFound:
+		snapshot = g.snapshot()
+	default:
+		return nil, fmt.Errorf("reading snapshot: %s", err)
+	}
+	wal.skipTo(seq)
+	events, err := wal.ReadAfter(seq)
+	if err != nil {
+		return nil, err
+	}
+	if len(events) > 0 {
+		if snapshot, err = replayEvents(snapshot, events); err != nil {
+			return nil, fmt.Errorf("replaying the event log: %s", err)
+		}
+		seq = events[len(events)-1].Seq
+		recovered = true
+	}
+	if recovered {
+		if err := g.restore(snapshot); err != nil {
+			return nil, fmt.Errorf("restoring snapshot: %s", err)
+		}
+		// the log as it stands leads to this state, new events follow on
+		if g.loggedFields, err = snapshotFields(snapshot); err != nil {
+			return nil, err
+		}
+	}
+	players := snapshot.PlayersList
+
+	g.wal = wal
+	g.store = store
+	// our deck keys go into the keystore before any card is encrypted
+	// with them, so they are there whenever a snapshot refers to them
+	if g.deckKeyID == "" {
+		if g.deckKeyID, err = keys.PutCardKeys(g.deckKeys); err != nil {
+			return nil, fmt.Errorf("storing deck keys: %s", err)
+		}
+	}
+	if err := keys.dropCardKeys(g.deckKeyID); err != nil {
+		logrus.Warnf("Failed to drop old deck keys: %s", err)
+	}
+	if !recovered {
+		if err := g.saveSnapshot(0); err != nil {
+			logrus.Errorf("Failed to write initial snapshot: %s", err)
+		}
+		return nil, nil
+	}
+	logrus.Infof("Recovered game state at event #%d (%d event(s) replayed after the snapshot)", seq, len(events))
+
+	peers := []string{}
+	seen := map[string]bool{g.listenAddr: true}
+	for _, addr := range players {
+		if !seen[addr] {
+			seen[addr] = true
+			peers = append(peers, addr)
+		}
+	}
+	for addr := range g.playerStates {
+		if !seen[addr] {
+			seen[addr] = true
+			peers = append(peers, addr)
+		}
+	}
+	return peers, nil
+}
+
+// saveSnapshot writes a snapshot covering the event log up to seq. The
+// caller must hold the game lock.
+func (g *Game) saveSnapshot(seq uint64) error {
+	snapshot := g.snapshot()
+	snapshot.Seq = seq
+	if err := writeSnapshot(g.store, snapshot); err != nil {
+		return err
+	}
+	fields, err := snapshotFields(snapshot)
+	if err != nil {
+		return err
+	}
+	g.loggedFields = fields
+	return nil
+}
+
+// logEvent appends a transition to the event log, recording the snapshot
+// fields that changed since the last event or snapshot, and takes a
+// snapshot when a hand completes or enough events have piled up. The caller must hold the
+// game lock.
+func (g *Game) logEvent(kind, player string) {
+	if g.wal == nil {
+		return
+	}
+	fields, err := snapshotFields(g.snapshot())
+	if err != nil {
+		logrus.Errorf("Failed to encode %s event: %s", kind, err)
+		return
+	}
+	changes := map[string]json.RawMessage{}
+	for name, value := range fields {
+		if !bytes.Equal(g.loggedFields[name], value) {
+			changes[name] = value
+		}
+	}
+	seq, err := g.wal.Append(kind, player, changes)
+	if err != nil {
+		logrus.Errorf("Failed to log %s event: %s", kind, err)
+		return
+	}
+	g.loggedFields = fields
+	g.eventsSinceSnapshot++
+	if kind != EventHandComplete && g.eventsSinceSnapshot < snapshotInterval {
+		return
+	}
+	if err := g.saveSnapshot(seq); err != nil {
+		logrus.Errorf("Failed to write snapshot: %s", err)
+		return
+	}
+	g.eventsSinceSnapshot = 0
+	if err := g.wal.Truncate(); err != nil {
+		logrus.Errorf("Failed to truncate event log: %s", err)
+	}
+}
@@ -0,0 +1,296 @@
+package p2p
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+const (
+	fixtureEncryptionKey = "123456789012345678901234567890123456789012345678901"
+	fixtureDecryptionKey = "987654321098765432109876543210987654321098765432109"
+	fixturePrime 		 = "1000000000000000000000000000000000000000000000000000000000000000057"
+	fixtureFoldedKey 	 = "555555555555555555555555555555555555555555555555555"
+)
+
+// snapshotV1 is a snapshot from before snapshots were versioned: no
+// Version, no seat count or player list, deck keys inline. Snapshots then
+// also held the keys folded players sent, which are no longer kept.
+const snapshotV1 = `{
+	"CurrentStatus": 4,
+	"HandNumber": 7,
+	"PlayerStates": {
+		":3000": {"ListenAddr": ":3000", "RotationID": 0, "IsReady": true, "IsActive": true, "Stack": 900},
+		":3001": {"ListenAddr": ":3001", "RotationID": 2, "IsReady": true, "IsActive": true, "Stack": 1100}
+	},
+	"RotationMap": {"0": ":3000", "2": ":3001"},
+	"CurrentDealerID": 0,
+	"CurrentPot": 60,
+	"HighestBet": 20,
+	"CurrentDeck": ["AQI=", "AwQ="],
+	"DeckKeys": {"EncryptionKey": ` + fixtureEncryptionKey + `, "DecryptionKey": ` + fixtureDecryptionKey + `, "Prime": ` + fixturePrime + `},
+	"FoldedPlayerKeys": {":3001": {"EncryptionKey": ` + fixtureFoldedKey + `, "DecryptionKey": ` + fixtureFoldedKey + `, "Prime": ` + fixturePrime + `}}
+}`
+
+// snapshotV2 is a version 2 snapshot with the deck keys sealed inline. The
+// sealed secret is filled in by the test since sealing is randomised.
+const snapshotV2 = `{
+	"Version": 2,
+	"CurrentStatus": 5,
+	"HandNumber": 9,
+	"PlayersList": [":3000", ":3001"],
+	"PlayerStates": {
+		":3000": {"ListenAddr": ":3000", "RotationID": 0, "IsReady": true, "IsActive": true, "HasSeat": true, "InHand": true, "Stack": 800},
+		":3001": {"ListenAddr": ":3001", "RotationID": 1, "IsReady": true, "IsActive": true, "HasSeat": true, "InHand": true, "Stack": 1200}
+	},
+	"RotationMap": {"0": ":3000", "1": ":3001"},
+	"NextRotationID": 2,
+	"CurrentDealerID": 1,
+	"SmallBlindID": 1,
+	"BigBlindID": 0,
+	"CurrentPlayerTurnID": 1,
+	"LastRaiserID": 0,
+	"LastAggressorID": -1,
+	"RunItTimes": 1,
+	"CurrentDeck": ["BQY=", "Bwg="],
+	"SealedDeckKeys": %s,
+	"FoldedPlayerKeys": {":3001": {"EncryptionKey": ` + fixtureFoldedKey + `, "DecryptionKey": ` + fixtureFoldedKey + `, "Prime": ` + fixturePrime + `}}
+}`
+
+const testPassphrase = "correct horse battery staple"
+
+func drainBroadcasts() chan BroadcastTo {
+	bc := make(chan BroadcastTo, 64)
+	go func() {
+		for range bc {
+		}
+	}()
+	return bc
+}
+
+func mustBigInt(t *testing.T, s string) *big.Int {
+	t.Helper()
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		t.Fatalf("bad number %s", s)
+	}
+	return n
+}
+
+func assertKeyBytes(t *testing.T, name string, got *CardKeys, encryption, decryption string) {
+	t.Helper()
+	if got == nil {
+		t.Fatalf("%s: no keys", name)
+	}
+	checks := []struct {
+		field 	string
+		got 	*big.Int
+		want 	string
+	}{
+		{"EncryptionKey", got.EncryptionKey, encryption},
+		{"DecryptionKey", got.DecryptionKey, decryption},
+		{"Prime", got.Prime, fixturePrime},
+	}
+	for _, check := range checks {
+		want := mustBigInt(t, check.want).Bytes()
+		if check.got == nil || !bytes.Equal(check.got.Bytes(), want) {
+			t.Errorf("%s.%s = %x, want %x", name, check.field, check.got, want)
+		}
+	}
+}
+
+// recoverGame opens a new game on store the way a restarted node does.
+func recoverGame(t *testing.T, store Storage) *Game {
+	t.Helper()
+	g := NewGame(":3000", TableConfig{}, drainBroadcasts())
+	if _, err := g.EnablePersistence(store, NewKeystore(store, testPassphrase)); err != nil {
+		t.Fatalf("recovering: %s", err)
+	}
+	return g
+}
+
+func TestSnapshotRoundTripMidHand(t *testing.T) {
+	store := NewMemoryStorage()
+	g := recoverGame(t, store)
+	g.AddPlayer(":3001")
+	g.AddPlayer(":3002")
+
+	g.lock.Lock()
+	for seat, addr := range []string{":3000", ":3001", ":3002"} {
+		if err := g.seatPlayer(addr, seat, 0); err != nil {
+			t.Fatal(err)
+		}
+		state := g.playerStates[addr]
+		state.IsReady = true
+		state.InHand = true
+	}
+	deck := CreatePlaceHolderDeck()
+	g.currentDeck = g.shuffleAndEncrypt(deck)
+	g.handNumber = 3
+	g.resetRunOut()
+	g.resetShowdown()
+	g.handSeats = g.nextRotationID
+	g.setStatus(GameStatusFlop)
+	g.currentDealerID = 0
+	g.smallBlindID = 1
+	g.bigBlindID = 2
+	g.currentPlayerTurnID = 1
+	g.lastRaiserID = 2
+	g.lastRaiseAmount = 40
+	g.highestBet = 60
+	g.currentPot = 150
+	g.turnNumber = 6
+	g.myHand = []Card{{Suit: Spades, Value: 1}, {Suit: Hearts, Value: 13}}
+	g.communityCards = []Card{{Suit: Clubs, Value: 2}, {Suit: Diamonds, Value: 9}, {Suit: Spades, Value: 11}}
+	g.playerStates[":3001"].IsFolded = true
+	g.lock.Unlock()
+
+	if err := g.SaveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	// a transition after the snapshot has to come back from the log
+	g.lock.Lock()
+	g.currentPlayerTurnID = 2
+	g.lastRaiserID = 1
+	g.playerStates[":3002"].CurrentRoundBet = 60
+	g.logEvent(EventPlayerAction, ":3002")
+	want := g.snapshot()
+	g.lock.Unlock()
+
+	restored := recoverGame(t, store)
+	restored.lock.RLock()
+	got := restored.snapshot()
+	restored.lock.RUnlock()
+
+	// peers count as gone until they reconnect
+	want.PlayersList = []string{":3000"}
+	for addr, state := range want.PlayerStates {
+		state.IsActive = addr == ":3000"
+	}
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if !bytes.Equal(wantJSON, gotJSON) {
+		t.Errorf("restored state differs\n got: %s\nwant: %s", gotJSON, wantJSON)
+	}
+	if got.CurrentPlayerTurnID != 2 || got.LastRaiserID != 1 {
+		t.Errorf("turn %d and raiser %d, want the ones logged after the snapshot", got.CurrentPlayerTurnID, got.LastRaiserID)
+	}
+	if restored.deckKeyID != g.deckKeyID {
+		t.Errorf("deck key ID %q, want %q", restored.deckKeyID, g.deckKeyID)
+	}
+	if restored.deckKeys.EncryptionKey.Cmp(g.deckKeys.EncryptionKey) != 0 || restored.deckKeys.DecryptionKey.Cmp(g.deckKeys.DecryptionKey) != 0 {
+		t.Error("deck keys did not survive the restart")
+	}
+	// the restored keys still strip our layer from the restored deck
+	if !bytes.Equal(restored.deckKeys.Decrypt(restored.currentDeck[0]), g.deckKeys.Decrypt(g.currentDeck[0])) {
+		t.Error("restored deck keys do not decrypt the restored deck")
+	}
+}
+
+func TestMigrateSnapshotV1(t *testing.T) {
+	snapshot, err := decodeSnapshot([]byte(snapshotV1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Version != SnapshotVersion {
+		t.Errorf("version %d, want %d", snapshot.Version, SnapshotVersion)
+	}
+	if snapshot.NextRotationID != 3 {
+		t.Errorf("NextRotationID %d, want 3", snapshot.NextRotationID)
+	}
+	if len(snapshot.PlayersList) != 2 {
+		t.Errorf("PlayersList %v, want both players", snapshot.PlayersList)
+	}
+	if snapshot.SmallBlindID != -1 || snapshot.BigBlindID != -1 || snapshot.CurrentPlayerTurnID != -1 || snapshot.LastRaiserID != -1 {
+		t.Error("per-hand pointers should start unset")
+	}
+	assertKeyBytes(t, "DeckKeys", snapshot.DeckKeys, fixtureEncryptionKey, fixtureDecryptionKey)
+	if !bytes.Equal(snapshot.CurrentDeck[0], []byte{1, 2}) || !bytes.Equal(snapshot.CurrentDeck[1], []byte{3, 4}) {
+		t.Errorf("CurrentDeck %x", snapshot.CurrentDeck)
+	}
+
+	store := NewMemoryStorage()
+	if err := store.Put(BucketSnapshot, snapshotKey, []byte(snapshotV1)); err != nil {
+		t.Fatal(err)
+	}
+	g := recoverGame(t, store)
+	assertKeyBytes(t, "recovered deck keys", g.deckKeys, fixtureEncryptionKey, fixtureDecryptionKey)
+	assertMovedToKeystore(t, g, store)
+}
+
+func TestMigrateSnapshotV2(t *testing.T) {
+	keys := &CardKeys{
+		EncryptionKey: 	mustBigInt(t, fixtureEncryptionKey),
+		DecryptionKey: 	mustBigInt(t, fixtureDecryptionKey),
+		Prime: 			mustBigInt(t, fixturePrime),
+	}
+	plain, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealSecret(testPassphrase, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedJSON, err := json.Marshal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture := []byte(fmt.Sprintf(snapshotV2, sealedJSON))
+
+	snapshot, err := decodeSnapshot(fixture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Version != SnapshotVersion {
+		t.Errorf("version %d, want %d", snapshot.Version, SnapshotVersion)
+	}
+	if snapshot.SealedDeckKeys == nil || !bytes.Equal(snapshot.SealedDeckKeys.Ciphertext, sealed.Ciphertext) {
+		t.Error("sealed deck keys changed in migration")
+	}
+	if snapshot.CurrentPlayerTurnID != 1 || snapshot.LastRaiserID != 0 || snapshot.NextRotationID != 2 {
+		t.Error("version 2 fields changed in migration")
+	}
+
+	store := NewMemoryStorage()
+	if err := store.Put(BucketSnapshot, snapshotKey, fixture); err != nil {
+		t.Fatal(err)
+	}
+	g := recoverGame(t, store)
+	assertKeyBytes(t, "recovered deck keys", g.deckKeys, fixtureEncryptionKey, fixtureDecryptionKey)
+	assertMovedToKeystore(t, g, store)
+}
+
+// assertMovedToKeystore checks that recovery put inline deck keys into the
+// keystore and that new snapshots only refer to them.
+func assertMovedToKeystore(t *testing.T, g *Game, store Storage) {
+	t.Helper()
+	if g.deckKeyID == "" {
+		t.Fatal("recovered deck keys were not put in the keystore")
+	}
+	stored, err := NewKeystore(store, testPassphrase).CardKeys(g.deckKeyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertKeyBytes(t, "keystore deck keys", stored, fixtureEncryptionKey, fixtureDecryptionKey)
+
+	if err := g.SaveSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := readSnapshot(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.DeckKeys != nil || snapshot.SealedDeckKeys != nil || snapshot.DeckKeyID != g.deckKeyID {
+		t.Error("a new snapshot should only refer to the deck keys by ID")
+	}
+	data, err := store.Get(BucketSnapshot, snapshotKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte(fixtureFoldedKey)) {
+		t.Error("a new snapshot still holds the keys of folded players")
+	}
+}
@@ -0,0 +1,160 @@
+package p2p
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// finishedHand keeps what a rabbit hunt needs from a hand that ended before
+// the river: its encrypted deck, how much of the board was dealt and who
+// holds a layer of encryption on it, in ring order from the dealer.
+type finishedHand struct {
+	handNumber 	int
+	deck 		[][]byte
+	boardBase 	int
+	board 		[]Card
+	players 	[]string
+	started 	bool
+}
+
+// RabbitHunt is the rest of a board revealed after the hand was over. The
+// cards were never part of the hand and change nothing about its result.
+type RabbitHunt struct {
+	HandNumber 	int
+	Board 		[]Card
+	Cards 		[]Card
+}
+
+// recordFinishedHand remembers a hand that ended with community cards still
+// undealt so the table can rabbit hunt them.
+func (g *Game) recordFinishedHand() {
+	g.finishedHand = nil
+	g.rabbitVotes = make(map[string]bool)
+	if !g.config.AllowRabbitHunt || g.runOut || len(g.communityCards) >= 5 || len(g.currentDeck) == 0 {
+		return
+	}
+	players := []string{}
+	dealer := g.dealerSeat()
+	for i := 0; i < g.nextRotationID; i++ {
+		seat := (dealer + i) % g.nextRotationID
+		if g.isDealtIn(seat) {
+			players = append(players, g.rotationMap[seat])
+		}
+	}
+	deck := make([][]byte, len(g.currentDeck))
+	copy(deck, g.currentDeck)
+	board := make([]Card, len(g.communityCards))
+	copy(board, g.communityCards)
+	g.finishedHand = &finishedHand{
+		handNumber: g.handNumber,
+		deck: 		deck,
+		boardBase: 	g.handSeats * 2,
+		board: 		board,
+		players: 	players,
+	}
+}
+
+// RequestRabbitHunt votes to see the cards that would have come in the last
+// hand. The board is only revealed once everyone dealt into it agrees.
+func (g *Game) RequestRabbitHunt() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if err := g.validateRabbitVote(g.listenAddr); err != nil {
+		return err
+	}
+	g.rabbitVotes[g.listenAddr] = true
+	g.sendToPlayers(MessageRabbitHunt{HandNumber: g.finishedHand.handNumber}, g.getOtherPlayers()...)
+	g.tryStartRabbitHunt()
+	return nil
+}
+
+func (g *Game) HandleRabbitHunt(from string, msg MessageRabbitHunt) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if err := g.validateRabbitVote(from); err != nil {
+		return fmt.Errorf("rejected rabbit hunt vote from %s: %s", from, err)
+	}
+	if msg.HandNumber != g.finishedHand.handNumber {
+		return fmt.Errorf("rabbit hunt vote from %s is for hand #%d, not #%d", from, msg.HandNumber, g.finishedHand.handNumber)
+	}
+	g.rabbitVotes[from] = true
+	logrus.Infof("Player %s wants to rabbit hunt hand #%d", from, msg.HandNumber)
+	g.tryStartRabbitHunt()
+	return nil
+}
+
+func (g *Game) validateRabbitVote(addr string) error {
+	if !g.config.AllowRabbitHunt {
+		return newRuleError(ErrNotAllowed, "rabbit hunting is not allowed at this table")
+	}
+	if g.finishedHand == nil {
+		return newRuleError(ErrIllegalAction, "there is no finished hand with cards left to hunt")
+	}
+	if g.finishedHand.started {
+		return newRuleError(ErrIllegalAction, "hand #%d is already being rabbit hunted", g.finishedHand.handNumber)
+	}
+	for _, player := range g.finishedHand.players {
+		if player == addr {
+			return nil
+		}
+	}
+	return newRuleError(ErrIllegalAction, "only players dealt into hand #%d can ask to rabbit hunt", g.finishedHand.handNumber)
+}
+
+// tryStartRabbitHunt has the dealer of the finished hand send its undealt
+// board positions around that hand's ring once every player agreed.
+func (g *Game) tryStartRabbitHunt() {
+	hand := g.finishedHand
+	for _, addr := range hand.players {
+		if !g.rabbitVotes[addr] {
+			return
+		}
+	}
+	hand.started = true
+	logrus.Infof("Everyone agreed to rabbit hunt hand #%d", hand.handNumber)
+	if hand.players[0] != g.listenAddr {
+		return
+	}
+	indices := []int{}
+	encrypted := [][]byte{}
+	for idx := hand.boardBase + len(hand.board); idx < hand.boardBase+5 && idx < len(hand.deck); idx++ {
+		indices = append(indices, idx)
+		encrypted = append(encrypted, hand.deck[idx])
+	}
+	g.startReveal(RevealRabbit, hand.handNumber, indices, encrypted)
+}
+
+// applyRabbitHunt records the checked cards of a rabbit hunt. The caller
+// has made sure the reveal is for the finished hand.
+func (g *Game) applyRabbitHunt(handNumber int, revealed []byte) {
+	hand := g.finishedHand
+	cards := make([]Card, 0, len(revealed))
+	for _, b := range revealed {
+		cards = append(cards, NewCardFromByte(b))
+	}
+	g.rabbitHunt = &RabbitHunt{
+		HandNumber: hand.handNumber,
+		Board: 		hand.board,
+		Cards: 		cards,
+	}
+	g.finishedHand = nil
+	logrus.Infof("Rabbit hunt for hand #%d: %v would have come", handNumber, cards)
+}
+
+// rabbitVotesNeeded is how many players still have to agree before the
+// last hand is rabbit hunted.
+func (g *Game) rabbitVotesNeeded() int {
+	if g.finishedHand == nil || g.finishedHand.started {
+		return 0
+	}
+	needed := 0
+	for _, addr := range g.finishedHand.players {
+		if !g.rabbitVotes[addr] {
+			needed++
+		}
+	}
+	return needed
+}
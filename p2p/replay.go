@@ -0,0 +1,134 @@
+package p2p
+
+// ReplayLastStep is the final step of a replay. Step zero is the table
+// before the blinds go in, step n is the table after the first n actions
+// and the last step, one past the final action, shows how the hand ended.
+func ReplayLastStep(hand *HandHistory) int {
+	return len(hand.Actions) + 1
+}
+
+// ReplayHand rebuilds the table of a recorded hand as it stood at a step,
+// seen from the player who recorded it.
+func ReplayHand(hand *HandHistory, step int) (TableStateResponse, error) {
+	if step < 0 || step > ReplayLastStep(hand) {
+		return TableStateResponse{}, newAmountError(step, 0, ReplayLastStep(hand), "step must be between 0 and %d", ReplayLastStep(hand))
+	}
+	g := newReplayGame(hand)
+	for _, action := range hand.Actions[:min(step, len(hand.Actions))] {
+		g.replayAction(hand, action)
+	}
+	switch {
+	case step < len(hand.Actions):
+		g.currentPlayerTurnID = hand.Actions[step].Seat
+	case step == ReplayLastStep(hand):
+		g.replayResult(hand)
+	}
+
+	resp := g.tableState()
+	resp.ValidActions = []string{}
+	return resp, nil
+}
+
+// newReplayGame sets up a detached Game holding only the table of a recorded
+// hand. It has no peers, keys or deck and is never started.
+func newReplayGame(hand *HandHistory) *Game {
+	g := &Game{
+		listenAddr: 			hand.Hero,
+		config: 				TableConfig{MaxSeats: hand.MaxSeats}.withDefaults(),
+		currentStatus: 			NewAtomicInt(int32(GameStatusPreFlop)),
+		playerStates: 			make(map[string]*PlayerState),
+		rotationMap: 			make(map[int]string),
+		currentDealerID: 		hand.ButtonSeat,
+		currentPlayerTurnID: 	-1,
+		smallBlindID: 			-1,
+		bigBlindID: 			-1,
+		lastAggressorID: 		-1,
+		shownCards: 			make(map[string][]Card),
+		mucked: 				make(map[string]bool),
+		revealedCards: 			make(map[int]Card),
+		rabbitVotes: 			make(map[string]bool),
+		myHand: 				hand.HeroCards,
+		communityCards: 		[]Card{},
+	}
+	for _, seat := range hand.Seats {
+		g.rotationMap[seat.Seat] = seat.Player
+		g.playerStates[seat.Player] = &PlayerState{
+			ListenAddr: seat.Player,
+			RotationID: seat.Seat,
+			IsReady: 	true,
+			IsActive: 	true,
+			HasSeat: 	true,
+			InHand: 	true,
+			Stack: 		seat.Stack,
+		}
+		g.nextRotationID = max(g.nextRotationID, seat.Seat+1)
+	}
+	g.handSeats = g.nextRotationID
+	if _, ok := g.playerStates[hand.Hero]; !ok {
+		g.playerStates[hand.Hero] = &PlayerState{ListenAddr: hand.Hero, RotationID: -1}
+	}
+	return g
+}
+
+// replayAction applies a recorded action using the amounts that were
+// recorded rather than the betting rules, so a replay always matches what
+// happened at the table.
+func (g *Game) replayAction(hand *HandHistory, action HistoryAction) {
+	if street := parseStreet(action.Street); street != g.GetStatus() {
+		g.setStatus(street)
+		g.highestBet = 0
+		g.lastRaiseAmount = 0
+		for _, state := range g.playerStates {
+			state.CurrentRoundBet = 0
+		}
+		g.communityCards = hand.Board[:min(streetCards(street), len(hand.Board))]
+	}
+	state, ok := g.playerStates[action.Player]
+	if !ok {
+		return
+	}
+	state.Stack -= action.Amount
+	state.TotalBetThisHand += action.Amount
+	g.currentPot += action.Amount
+	if action.Kind != HistoryPostDead {
+		state.CurrentRoundBet = action.RaisedTo
+	}
+	state.IsAllIn = action.AllIn
+
+	switch action.Kind {
+	case HistoryFold:
+		state.IsFolded = true
+	case HistoryPostSmallBlind:
+		g.smallBlindID = action.Seat
+	case HistoryPostBigBlind:
+		if g.bigBlindID < 0 {
+			g.bigBlindID = action.Seat
+		}
+	case HistoryPostStraddle:
+		g.straddleAmount = action.RaisedTo
+	}
+	if action.RaisedTo > g.highestBet {
+		g.lastRaiseAmount = action.RaisedTo - g.highestBet
+		g.highestBet = action.RaisedTo
+		g.lastRaiserID = action.Seat
+	}
+}
+
+// replayResult shows the end of the hand: the whole board, the hands that
+// were shown and the stacks after the pot was paid out.
+func (g *Game) replayResult(hand *HandHistory) {
+	g.setStatus(GameStatusHandComplete)
+	g.currentPlayerTurnID = -1
+	g.communityCards = hand.Board
+	g.runResults = hand.Runs
+	g.currentPot = 0
+	g.highestBet = 0
+	for addr, cards := range hand.ShownHands {
+		g.shownCards[addr] = cards
+	}
+	for _, seat := range hand.Seats {
+		state := g.playerStates[seat.Player]
+		state.Stack = seat.FinalStack
+		state.CurrentRoundBet = 0
+	}
+}
@@ -0,0 +1,258 @@
+package p2p
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runItVoteTimeout is how long the players in an all-in hand have to vote on
+// how many times to run the board. A player who has not voted by then is
+// counted as asking for one run.
+const runItVoteTimeout = 15 * time.Second
+
+// RunResult is one board dealt at showdown and the chips it paid out. A hand
+// that is run once has a single result.
+type RunResult struct {
+	Board 	[]Card 			`json:"board"`
+	Payouts map[string]int 	`json:"payouts"`
+}
+
+// maxRunsFor is how many boards one deck can deal when seats players hold
+// two cards each and missing board cards are still to come: the first run
+// takes the normal board positions, every later run the fresh cards after
+// the river.
+func maxRunsFor(seats, missing int) int {
+	if missing <= 0 {
+		return 1
+	}
+	return max((52-2*seats-5)/missing+1, 1)
+}
+
+// runItLimit is the most runs the current all-in hand can be run, the table
+// cap or what is left of the deck, whichever is lower.
+func (g *Game) runItLimit() int {
+	return min(g.config.MaxRuns, maxRunsFor(g.handSeats, 5-g.runOutFrom))
+}
+
+func (g *Game) resetRunOut() {
+	g.runOut = false
+	g.runOutFrom = 0
+	g.runItPending = false
+	g.runItVotes = make(map[string]int)
+	g.runItTimes = 1
+	g.runResults = nil
+}
+
+// isAllInRunOut reports whether betting is over before the river because at
+// most one player left in the hand still has chips behind.
+func (g *Game) isAllInRunOut() bool {
+	status := GameStatus(g.currentStatus.Get())
+	if status < GameStatusPreFlop || status >= GameStatusRiver {
+		return false
+	}
+	remaining, canAct := 0, 0
+	for _, state := range g.playerStates {
+		if state.InHand && state.IsActive && !state.IsFolded {
+			remaining++
+			if !state.IsAllIn {
+				canAct++
+			}
+		}
+	}
+	return remaining >= 2 && canAct <= 1
+}
+
+// beginRunOut skips the remaining betting rounds and goes to showdown, where
+// the dealer reveals the rest of the board for every run in one pass around
+// the ring. When the table allows it the players first agree on how many
+// times to run the board.
+func (g *Game) beginRunOut() {
+	switch GameStatus(g.currentStatus.Get()) {
+	case GameStatusFlop:
+		g.runOutFrom = 3
+	case GameStatusTurn:
+		g.runOutFrom = 4
+	default:
+		g.runOutFrom = 0
+	}
+	g.runOut = true
+	g.highestBet = 0
+	g.lastRaiseAmount = 0
+	for _, state := range g.playerStates {
+		state.CurrentRoundBet = 0
+	}
+
+	if g.runItLimit() <= 1 {
+		g.startRunOutShowdown()
+		return
+	}
+	g.runItPending = true
+	logrus.Infof("All-in before the river: waiting %s for players to agree to run it up to %d times", runItVoteTimeout, g.runItLimit())
+	handNumber := g.handNumber
+	time.AfterFunc(runItVoteTimeout, func() {
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		if g.handNumber == handNumber && g.runItPending {
+			g.closeRunItVote()
+		}
+	})
+	g.tryResolveRunItVotes()
+}
+
+func (g *Game) startRunOutShowdown() {
+	g.runItPending = false
+	g.setStatus(GameStatusShowdown)
+	logrus.Infof("Running the board %d time(s)", g.runItTimes)
+	go g.InitiateShowdown()
+}
+
+// RunIt records how many times we want to run an all-in board. Asking for
+// one run declines run-it-twice for everyone.
+func (g *Game) RunIt(runs int) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if err := g.validateRunItVote(g.listenAddr, runs); err != nil {
+		return err
+	}
+	g.runItVotes[g.listenAddr] = runs
+	g.logEvent(EventRunItVote, g.listenAddr)
+	g.sendToPlayers(MessageRunIt{Runs: runs}, g.getOtherPlayers()...)
+	g.tryResolveRunItVotes()
+	return nil
+}
+
+func (g *Game) HandleRunIt(from string, msg MessageRunIt) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if err := g.validateRunItVote(from, msg.Runs); err != nil {
+		return fmt.Errorf("rejected run-it vote from %s: %s", from, err)
+	}
+	g.runItVotes[from] = msg.Runs
+	logrus.Infof("Player %s wants to run it %d time(s)", from, msg.Runs)
+	g.logEvent(EventRunItVote, from)
+	g.tryResolveRunItVotes()
+	return nil
+}
+
+func (g *Game) validateRunItVote(addr string, runs int) error {
+	state, ok := g.playerStates[addr]
+	if !ok {
+		return newRuleError(ErrUnknownPlayer, "unknown player %s", addr)
+	}
+	if !state.InHand || state.IsFolded {
+		return newRuleError(ErrIllegalAction, "only players still in the hand can choose how to run it")
+	}
+	if limit := g.runItLimit(); runs < 1 || runs > limit {
+		return newAmountError(runs, 1, limit, "runs must be between 1 and %d", limit)
+	}
+	return nil
+}
+
+// tryResolveRunItVotes settles on the smallest number of runs asked for once
+// everyone left in the hand has voted.
+func (g *Game) tryResolveRunItVotes() {
+	if !g.runItPending {
+		return
+	}
+	runs := g.runItLimit()
+	for addr, state := range g.playerStates {
+		if !state.InHand || !state.IsActive || state.IsFolded {
+			continue
+		}
+		vote, ok := g.runItVotes[addr]
+		if !ok {
+			return
+		}
+		runs = min(runs, vote)
+	}
+	g.runItTimes = runs
+	g.startRunOutShowdown()
+}
+
+// closeRunItVote ends a vote that ran out of time. Anyone who did not vote
+// counts as asking for one run, so the board is run once.
+func (g *Game) closeRunItVote() {
+	for addr, state := range g.playerStates {
+		if !state.InHand || !state.IsActive || state.IsFolded {
+			continue
+		}
+		if _, ok := g.runItVotes[addr]; !ok {
+			logrus.Infof("Player %s did not vote in time, running it once", addr)
+			g.runItVotes[addr] = 1
+		}
+	}
+	g.logEvent(EventRunItVote, "")
+	g.tryResolveRunItVotes()
+}
+
+// runBoardIndices returns the deck positions making up the board of a run.
+// Cards dealt before the all-in are shared by every run; the first run deals
+// the rest of the board as normal and every later run takes fresh cards
+// from the positions after the river.
+func (g *Game) runBoardIndices(run int) []int {
+	base := g.handSeats * 2
+	missing := 5 - g.runOutFrom
+	indices := make([]int, 0, 5)
+	for i := 0; i < g.runOutFrom; i++ {
+		indices = append(indices, base+i)
+	}
+	for i := 0; i < missing; i++ {
+		if run == 0 {
+			indices = append(indices, base+g.runOutFrom+i)
+		} else {
+			indices = append(indices, base+5+(run-1)*missing+i)
+		}
+	}
+	return indices
+}
+
+// showdownBoards returns the board of every run. Without a run-out this is
+// the board revealed street by street, otherwise the runs are read from the
+// cards revealed publicly at showdown.
+func (g *Game) showdownBoards() [][]Card {
+	if !g.runOut {
+		return [][]Card{g.communityCards}
+	}
+	boards := make([][]Card, 0, g.runItTimes)
+	for run := 0; run < g.runItTimes; run++ {
+		board := make([]Card, 0, 5)
+		for _, idx := range g.runBoardIndices(run) {
+			card, ok := g.revealedCards[idx]
+			if !ok {
+				logrus.Errorf("Board card at position %d for run #%d was never revealed", idx, run+1)
+				return boards
+			}
+			board = append(board, card)
+		}
+		boards = append(boards, board)
+	}
+	return boards
+}
+
+func (g *Game) boardsComplete() bool {
+	if !g.runOut {
+		return len(g.communityCards) >= 5
+	}
+	for run := 0; run < g.runItTimes; run++ {
+		for _, idx := range g.runBoardIndices(run) {
+			if _, ok := g.revealedCards[idx]; !ok && idx < len(g.currentDeck) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// splitForRun is the part of a pot paid out on a run. Odd chips go to the
+// first run.
+func splitForRun(amount, runs, run int) int {
+	share := amount / runs
+	if run == 0 {
+		share += amount % runs
+	}
+	return share
+}
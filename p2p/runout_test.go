@@ -0,0 +1,132 @@
+package p2p
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSplitForRun(t *testing.T) {
+	tests := []struct {
+		name 	string
+		amount 	int
+		runs 	int
+		want 	[]int
+	}{
+		{name: "run once", amount: 125, runs: 1, want: []int{125}},
+		{name: "even split", amount: 200, runs: 2, want: []int{100, 100}},
+		{name: "odd chip to the first run", amount: 201, runs: 2, want: []int{101, 100}},
+		{name: "three runs", amount: 100, runs: 3, want: []int{34, 33, 33}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]int, tt.runs)
+			total := 0
+			for run := range got {
+				got[run] = splitForRun(tt.amount, tt.runs, run)
+				total += got[run]
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("shares %v, want %v", got, tt.want)
+			}
+			if total != tt.amount {
+				t.Fatalf("shares add up to %d, want %d", total, tt.amount)
+			}
+		})
+	}
+}
+
+func TestRunBoardIndices(t *testing.T) {
+	tests := []struct {
+		name 		string
+		seats 		int
+		runOutFrom 	int
+		run 		int
+		want 		[]int
+	}{
+		{name: "preflop first run", seats: 2, runOutFrom: 0, run: 0, want: []int{4, 5, 6, 7, 8}},
+		{name: "preflop second run", seats: 2, runOutFrom: 0, run: 1, want: []int{9, 10, 11, 12, 13}},
+		{name: "flop second run shares the flop", seats: 3, runOutFrom: 3, run: 1, want: []int{6, 7, 8, 11, 12}},
+		{name: "turn third run", seats: 3, runOutFrom: 4, run: 2, want: []int{6, 7, 8, 9, 12}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGame(":3000", TableConfig{}, drainBroadcasts())
+			g.handSeats = tt.seats
+			g.runOutFrom = tt.runOutFrom
+			if got := g.runBoardIndices(tt.run); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("indices %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxRunsFor(t *testing.T) {
+	tests := []struct {
+		seats 	int
+		missing int
+		want 	int
+	}{
+		{seats: 2, missing: 5, want: 9},
+		{seats: 9, missing: 5, want: 6},
+		{seats: 9, missing: 2, want: 15},
+		{seats: 9, missing: 1, want: 30},
+		{seats: 23, missing: 5, want: 1},
+		{seats: 6, missing: 0, want: 1},
+	}
+	for _, tt := range tests {
+		got := maxRunsFor(tt.seats, tt.missing)
+		if got != tt.want {
+			t.Errorf("maxRunsFor(%d, %d) = %d, want %d", tt.seats, tt.missing, got, tt.want)
+		}
+		// the last run must still fit in the deck
+		if tt.missing > 0 && 2*tt.seats+5+(got-1)*tt.missing > 52 {
+			t.Errorf("maxRunsFor(%d, %d) = %d runs past the end of the deck", tt.seats, tt.missing, got)
+		}
+	}
+}
+
+func TestRunItVotes(t *testing.T) {
+	tests := []struct {
+		name 		string
+		maxRuns 	int
+		seats 		int
+		votes 		[]int
+		wantErr 	error
+		wantRuns 	int
+	}{
+		{name: "smallest vote wins", maxRuns: 3, seats: 2, votes: []int{3, 2}, wantRuns: 2},
+		{name: "one run declines for everyone", maxRuns: 3, seats: 2, votes: []int{3, 1}, wantRuns: 1},
+		{name: "above the table cap", maxRuns: 2, seats: 2, votes: []int{3}, wantErr: ErrAmountTooLarge},
+		{name: "above what the deck can deal", maxRuns: 8, seats: 20, votes: []int{3}, wantErr: ErrAmountTooLarge},
+		{name: "zero runs", maxRuns: 2, seats: 2, votes: []int{0}, wantErr: ErrAmountTooSmall},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newSeatedTable(t, 2, 0, 1)
+			g.config.MaxRuns = tt.maxRuns
+			g.handSeats = tt.seats
+			g.runOut = true
+			g.runItPending = true
+
+			for i, runs := range tt.votes {
+				addr := seatAddr(i)
+				err := g.validateRunItVote(addr, runs)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("vote %d from %s: got error %v, want %v", runs, addr, err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				g.runItVotes[addr] = runs
+			}
+			g.lock.Lock()
+			defer g.lock.Unlock()
+			g.tryResolveRunItVotes()
+
+			if g.runItPending || g.runItTimes != tt.wantRuns {
+				t.Fatalf("pending %v, runs %d, want %d", g.runItPending, g.runItTimes, tt.wantRuns)
+			}
+		})
+	}
+}
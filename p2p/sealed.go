@@ -0,0 +1,84 @@
+package p2p
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	sealKDF 	= "scrypt"
+	sealScryptN = 1 << 15
+	sealScryptR = 8
+	sealScryptP = 1
+	sealKeyLen 	= 32
+)
+
+// SealedSecret is data encrypted at rest with AES-256-GCM under a key
+// stretched from a passphrase with scrypt. The KDF parameters are stored
+// alongside so they can be raised later without breaking old files.
+type SealedSecret struct {
+	KDF 		string 	`json:"kdf"`
+	N 			int 	`json:"n"`
+	R 			int 	`json:"r"`
+	P 			int 	`json:"p"`
+	Salt 		[]byte 	`json:"salt"`
+	Nonce 		[]byte 	`json:"nonce"`
+	Ciphertext 	[]byte 	`json:"ciphertext"`
+}
+
+func sealSecret(passphrase string, plaintext []byte) (*SealedSecret, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("a passphrase is required to encrypt secrets")
+	}
+	sealed := &SealedSecret{
+		KDF: 	sealKDF,
+		N: 		sealScryptN,
+		R: 		sealScryptR,
+		P: 		sealScryptP,
+		Salt: 	make([]byte, 16),
+	}
+	if _, err := rand.Read(sealed.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := sealed.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(sealed.Nonce); err != nil {
+		return nil, err
+	}
+	sealed.Ciphertext = aead.Seal(nil, sealed.Nonce, plaintext, nil)
+	return sealed, nil
+}
+
+func (s *SealedSecret) Open(passphrase string) ([]byte, error) {
+	aead, err := s.aead(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, s.Nonce, s.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrong passphrase or corrupted secret")
+	}
+	return plaintext, nil
+}
+
+func (s *SealedSecret) aead(passphrase string) (cipher.AEAD, error) {
+	if s.KDF != sealKDF {
+		return nil, fmt.Errorf("unsupported key derivation %q", s.KDF)
+	}
+	key, err := scrypt.Key([]byte(passphrase), s.Salt, s.N, s.R, s.P, sealKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,491 @@
+package p2p
+
+import (
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// assignSeats gives every player without a seat the lowest free seat number.
+// Players are seated in address order so every node builds the same layout,
+// and a seat is kept across hands until the player gives it up. When the
+// table is full the remaining players go on the waiting list.
+func (g *Game) assignSeats(players []string) {
+	sorted := make([]string, len(players))
+	copy(sorted, players)
+	sort.Strings(sorted)
+
+	for _, addr := range sorted {
+		state := g.playerStates[addr]
+		if state.HasSeat || g.isOnWaitingList(addr) {
+			continue
+		}
+		seat := g.lowestFreeSeat()
+		if seat < 0 {
+			if err := g.addToWaitingList(addr, g.lastWaitingSince()+1); err != nil {
+				logrus.Warnf("Player %s sits out: %s", addr, err)
+			}
+			continue
+		}
+		if err := g.seatPlayer(addr, seat, 0); err != nil {
+			logrus.Errorf("Failed to seat player %s: %s", addr, err)
+		}
+	}
+}
+
+func (g *Game) lowestFreeSeat() int {
+	for seat := 0; seat < g.config.MaxSeats; seat++ {
+		if _, taken := g.rotationMap[seat]; !taken {
+			return seat
+		}
+	}
+	return -1
+}
+
+// seatClaimWindow is how old a seat claim may be when it arrives. Within it
+// two claims for the same seat are settled by their timestamps; anything
+// older is refused so a backdated claim cannot take a seat that was settled
+// long ago.
+const seatClaimWindow = 5 * time.Second
+
+// claimsBefore orders seat claims and waiting list entries: the earlier
+// timestamp wins and the lower address breaks a tie.
+func claimsBefore(at int64, addr string, otherAt int64, other string) bool {
+	if at != otherAt {
+		return at < otherAt
+	}
+	return addr < other
+}
+
+// seatPlayer puts addr in the given seat, moving them if they already sit
+// somewhere else. claimedAt is the claimant's timestamp, zero for seats
+// handed out by the table itself. When two players claim the same seat every
+// node keeps the earlier claim, whatever order the claims arrived in, so the
+// later claimant is stood back up if their claim was applied first.
+func (g *Game) seatPlayer(addr string, seat int, claimedAt int64) error {
+	state, ok := g.playerStates[addr]
+	if !ok {
+		return newRuleError(ErrUnknownPlayer, "unknown player %s", addr)
+	}
+	if seat < 0 || seat >= g.config.MaxSeats {
+		return newRuleError(ErrInvalidSeat, "seat %d does not exist, the table has %d seats", seat, g.config.MaxSeats)
+	}
+	if occupant, taken := g.rotationMap[seat]; taken {
+		if occupant == addr {
+			return nil
+		}
+		held := g.playerStates[occupant]
+		if claimsBefore(held.SeatClaimedAt, occupant, claimedAt, addr) || (held.InHand && g.isHandInProgress()) {
+			return newRuleError(ErrSeatTaken, "seat %d is already taken by %s", seat, occupant)
+		}
+		g.displaceFromSeat(occupant)
+	}
+	if state.HasSeat {
+		if state.InHand && g.isHandInProgress() {
+			return newRuleError(ErrHandInProgress, "cannot change seats during a hand")
+		}
+		delete(g.rotationMap, state.RotationID)
+	}
+	state.RotationID = seat
+	state.HasSeat = true
+	state.SeatClaimedAt = claimedAt
+	g.rotationMap[seat] = addr
+	if seat >= g.nextRotationID {
+		g.nextRotationID = seat + 1
+	}
+	g.removeFromWaitingList(addr)
+	logrus.Infof("Player %s takes seat %d", addr, seat)
+	g.logEvent(EventSeatChanged, addr)
+	g.emit(TableEventSeatChanged, SeatEvent{Player: addr, Seat: seat, Seated: true})
+	return nil
+}
+
+// displaceFromSeat stands addr up because an earlier claim for their seat
+// turned up. They are not put on the waiting list; they can claim another
+// seat.
+func (g *Game) displaceFromSeat(addr string) {
+	state := g.playerStates[addr]
+	seat := state.RotationID
+	delete(g.rotationMap, seat)
+	state.HasSeat = false
+	state.InHand = false
+	state.IsReady = false
+	state.SeatClaimedAt = 0
+	logrus.Infof("Player %s lost seat %d to an earlier claim", addr, seat)
+	g.logEvent(EventSeatChanged, addr)
+	g.emit(TableEventSeatChanged, SeatEvent{Player: addr, Seat: seat})
+}
+
+// releaseSeat frees the seat of a player who left the table. A player still
+// in the current hand keeps the seat until the hand is over and
+// releaseDepartedSeats frees it before the next one.
+func (g *Game) releaseSeat(addr string) {
+	state, ok := g.playerStates[addr]
+	if !ok || !state.HasSeat {
+		return
+	}
+	if state.InHand && g.isHandInProgress() {
+		logrus.Infof("Seat %d frees up when the hand ends", state.RotationID)
+		return
+	}
+	if err := g.unseatPlayer(addr); err != nil {
+		logrus.Errorf("Failed to free the seat of %s: %s", addr, err)
+	}
+}
+
+// releaseDepartedSeats frees the seats still held by players who left during
+// a hand. Seats are walked in order so every node hands them to the waiting
+// list the same way.
+func (g *Game) releaseDepartedSeats() {
+	for seat := 0; seat < g.nextRotationID; seat++ {
+		addr, ok := g.rotationMap[seat]
+		if !ok {
+			continue
+		}
+		if state := g.playerStates[addr]; !state.IsActive {
+			g.releaseSeat(addr)
+		}
+	}
+}
+
+// unseatPlayer frees the seat held by addr and hands it to the first player
+// on the waiting list.
+func (g *Game) unseatPlayer(addr string) error {
+	state, ok := g.playerStates[addr]
+	if !ok {
+		return newRuleError(ErrUnknownPlayer, "unknown player %s", addr)
+	}
+	if !state.HasSeat {
+		return newRuleError(ErrNotSeated, "player %s is not seated", addr)
+	}
+	if state.InHand && g.isHandInProgress() {
+		return newRuleError(ErrHandInProgress, "cannot leave the seat during a hand")
+	}
+	seat := state.RotationID
+	delete(g.rotationMap, seat)
+	state.HasSeat = false
+	state.InHand = false
+	state.IsReady = false
+	state.SeatClaimedAt = 0
+	state.MissedSmallBlind = false
+	state.MissedBigBlind = false
+	logrus.Infof("Player %s left seat %d", addr, seat)
+	g.logEvent(EventSeatChanged, addr)
+	g.emit(TableEventSeatChanged, SeatEvent{Player: addr, Seat: seat})
+
+	g.seatFromWaitingList(seat)
+	return nil
+}
+
+func (g *Game) seatFromWaitingList(seat int) {
+	for len(g.waitingList) > 0 {
+		next := g.waitingList[0]
+		if err := g.seatPlayer(next, seat, 0); err != nil {
+			logrus.Errorf("Failed to seat %s from the waiting list: %s", next, err)
+			g.removeFromWaitingList(next)
+			continue
+		}
+		return
+	}
+}
+
+func (g *Game) isOnWaitingList(addr string) bool {
+	for _, waiting := range g.waitingList {
+		if waiting == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// addToWaitingList queues addr by the time they asked to join rather than
+// the order their request arrived in, so every node has the same queue.
+func (g *Game) addToWaitingList(addr string, joinedAt int64) error {
+	if g.isOnWaitingList(addr) {
+		return nil
+	}
+	if len(g.waitingList) >= g.config.MaxWaitList {
+		return newRuleError(ErrNotAllowed, "the waiting list is full (%d players)", g.config.MaxWaitList)
+	}
+	g.playerStates[addr].WaitingSince = joinedAt
+	pos := sort.Search(len(g.waitingList), func(i int) bool {
+		other := g.waitingList[i]
+		return claimsBefore(joinedAt, addr, g.playerStates[other].WaitingSince, other)
+	})
+	g.waitingList = append(g.waitingList, "")
+	copy(g.waitingList[pos+1:], g.waitingList[pos:])
+	g.waitingList[pos] = addr
+	logrus.Infof("Player %s joined the waiting list at position %d", addr, pos+1)
+	g.logEvent(EventWaitList, addr)
+	return nil
+}
+
+// lastWaitingSince is the latest join time on the waiting list. Players the
+// table queues itself go in behind it.
+func (g *Game) lastWaitingSince() int64 {
+	last := int64(0)
+	for _, addr := range g.waitingList {
+		last = max(last, g.playerStates[addr].WaitingSince)
+	}
+	return last
+}
+
+func (g *Game) removeFromWaitingList(addr string) {
+	for i, waiting := range g.waitingList {
+		if waiting == addr {
+			g.waitingList = append(g.waitingList[:i], g.waitingList[i+1:]...)
+			return
+		}
+	}
+}
+
+func (g *Game) isHandInProgress() bool {
+	status := GameStatus(g.currentStatus.Get())
+	return status >= GameStatusDealing && status <= GameStatusShowdown
+}
+
+// getSeatedReadyPlayers returns the players who will be dealt into the next
+// hand: seated, ready, connected and with chips in front of them.
+func (g *Game) getSeatedReadyPlayers() []string {
+	seated := []string{}
+	for _, addr := range g.getReadyActivePlayers() {
+		if state := g.playerStates[addr]; state.HasSeat && state.Stack > 0 {
+			seated = append(seated, addr)
+		}
+	}
+	return seated
+}
+
+// TakeSeat sits us down in the given seat and announces it to the table.
+func (g *Game) TakeSeat(seat int) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	claimedAt := time.Now().UnixNano()
+	if err := g.seatPlayer(g.listenAddr, seat, claimedAt); err != nil {
+		return err
+	}
+	g.sendToPlayers(MessageTakeSeat{Seat: seat, ClaimedAt: claimedAt}, g.getOtherPlayers()...)
+	return nil
+}
+
+// LeaveSeat stands us up between hands, giving the seat to the waiting list.
+func (g *Game) LeaveSeat() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if err := g.unseatPlayer(g.listenAddr); err != nil {
+		return err
+	}
+	g.sendToPlayers(MessageLeaveSeat{}, g.getOtherPlayers()...)
+	return nil
+}
+
+// JoinWaitingList queues us for the next seat that opens up.
+func (g *Game) JoinWaitingList() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if state := g.playerStates[g.listenAddr]; state.HasSeat {
+		return newRuleError(ErrIllegalAction, "already seated in seat %d", state.RotationID)
+	}
+	if seat := g.lowestFreeSeat(); seat >= 0 {
+		return newRuleError(ErrIllegalAction, "seat %d is free, take it instead of waiting", seat)
+	}
+	joinedAt := time.Now().UnixNano()
+	if err := g.addToWaitingList(g.listenAddr, joinedAt); err != nil {
+		return err
+	}
+	g.sendToPlayers(MessageJoinWaitList{JoinedAt: joinedAt}, g.getOtherPlayers()...)
+	return nil
+}
+
+func (g *Game) HandleTakeSeat(from string, msg MessageTakeSeat) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if age := time.Since(time.Unix(0, msg.ClaimedAt)); age > seatClaimWindow || age < -seatClaimWindow {
+		return newRuleError(ErrSeatTaken, "seat claim from %s is %s off our clock", from, age)
+	}
+	return g.seatPlayer(from, msg.Seat, msg.ClaimedAt)
+}
+
+func (g *Game) HandleLeaveSeat(from string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.unseatPlayer(from)
+}
+
+func (g *Game) HandleJoinWaitList(from string, msg MessageJoinWaitList) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if _, ok := g.playerStates[from]; !ok {
+		return newRuleError(ErrUnknownPlayer, "unknown player %s", from)
+	}
+	return g.addToWaitingList(from, msg.JoinedAt)
+}
+
+// isSittingOut reports whether a player is out of the game: not active or
+// ready for the next hand, or left out of the hand being played.
+func (g *Game) isSittingOut(state *PlayerState) bool {
+	if !state.IsActive || !state.IsReady {
+		return true
+	}
+	return g.handNumber > 0 && !state.InHand
+}
+
+func (g *Game) isDealtIn(seat int) bool {
+	addr, ok := g.rotationMap[seat]
+	if !ok {
+		return false
+	}
+	state, ok := g.playerStates[addr]
+	return ok && state.InHand
+}
+
+// dealerSeat is the seat that drives the shuffle and deals the board. With a
+// dead button that is the first player dealt in after the button.
+func (g *Game) dealerSeat() int {
+	if g.isDealtIn(g.currentDealerID) {
+		return g.currentDealerID
+	}
+	return g.getNextPlayerID(g.currentDealerID)
+}
+
+func (g *Game) countDealtIn() int {
+	count := 0
+	for _, state := range g.playerStates {
+		if state.InHand {
+			count++
+		}
+	}
+	return count
+}
+
+// advanceButton moves the button and blinds for a new hand using the dead
+// button rule: the big blind always moves forward exactly one seat, the
+// previous big blind posts the small blind and the button lands on the
+// previous small blind, even if those seats are now empty. Seated players
+// the big blind passes over while they sit out owe the blinds they missed.
+func (g *Game) advanceButton() {
+	dealtIn := g.countDealtIn()
+	if g.bigBlindID < 0 || dealtIn == 2 {
+		g.rotateButton(dealtIn)
+		return
+	}
+
+	prevSB, prevBB := g.smallBlindID, g.bigBlindID
+	bbID := prevBB
+	for i := 0; i < g.nextRotationID; i++ {
+		bbID = (bbID + 1) % g.nextRotationID
+		addr, ok := g.rotationMap[bbID]
+		if !ok {
+			continue
+		}
+		state := g.playerStates[addr]
+		if state.InHand {
+			break
+		}
+		state.MissedBigBlind = true
+		state.MissedSmallBlind = true
+		logrus.Infof("Player %s missed the big blind in seat %d", addr, bbID)
+	}
+
+	if bbID == prevSB || bbID == prevBB {
+		// the table shrank around the blinds, fall back to a plain rotation
+		g.rotateButton(dealtIn)
+		return
+	}
+	g.bigBlindID = bbID
+	g.smallBlindID = prevBB
+	g.currentDealerID = prevSB
+
+	if !g.isDealtIn(g.smallBlindID) {
+		if addr, ok := g.rotationMap[g.smallBlindID]; ok {
+			g.playerStates[addr].MissedSmallBlind = true
+			logrus.Infof("Player %s missed the small blind in seat %d", addr, g.smallBlindID)
+		}
+		logrus.Infof("Dead small blind in seat %d", g.smallBlindID)
+	}
+	if !g.isDealtIn(g.currentDealerID) {
+		logrus.Infof("Dead button in seat %d", g.currentDealerID)
+	}
+}
+
+// rotateButton moves the button to the next player dealt in and seats the
+// blinds behind it. Heads-up the button posts the small blind.
+func (g *Game) rotateButton(dealtIn int) {
+	g.currentDealerID = g.getNextPlayerID(g.currentDealerID)
+	if dealtIn == 2 {
+		g.smallBlindID = g.currentDealerID
+	} else {
+		g.smallBlindID = g.getNextPlayerID(g.currentDealerID)
+	}
+	g.bigBlindID = g.getNextPlayerID(g.smallBlindID)
+}
+
+func (g *Game) postBlinds() {
+	if g.isDealtIn(g.smallBlindID) {
+		sbAddr := g.rotationMap[g.smallBlindID]
+		stackBefore := g.playerStates[sbAddr].Stack
+		g.updatePlayerState(sbAddr, PlayerActionBet, g.config.SmallBlind)
+		g.recordAction(sbAddr, HistoryPostSmallBlind, stackBefore)
+		logrus.Infof("Player %s posted small blind: %d", sbAddr, g.config.SmallBlind)
+	}
+
+	bbAddr := g.rotationMap[g.bigBlindID]
+	stackBefore := g.playerStates[bbAddr].Stack
+	g.updatePlayerState(bbAddr, PlayerActionBet, g.config.BigBlind)
+	g.recordAction(bbAddr, HistoryPostBigBlind, stackBefore)
+	logrus.Infof("Player %s posted big blind: %d", bbAddr, g.config.BigBlind)
+
+	g.postMissedBlinds()
+
+	if g.countDealtIn() == 2 {
+		g.setTurn(g.smallBlindID)
+	} else {
+		g.setTurn(g.getNextActivePlayerID(g.bigBlindID))
+	}
+	g.lastRaiserID = g.bigBlindID
+	g.lastRaiseAmount = g.config.BigBlind
+
+	g.postStraddle()
+}
+
+// postMissedBlinds makes returning players pay the blinds they skipped while
+// sitting out: a missed big blind is posted live and a missed small blind is
+// posted dead, straight into the pot. Players who are in the big blind this
+// hand are square again without paying extra.
+func (g *Game) postMissedBlinds() {
+	for seat := 0; seat < g.nextRotationID; seat++ {
+		if !g.isDealtIn(seat) {
+			continue
+		}
+		addr := g.rotationMap[seat]
+		state := g.playerStates[addr]
+		if !state.MissedBigBlind && !state.MissedSmallBlind {
+			continue
+		}
+		if seat != g.bigBlindID {
+			if state.MissedBigBlind && state.CurrentRoundBet < g.config.BigBlind {
+				stackBefore := state.Stack
+				g.updatePlayerState(addr, PlayerActionBet, g.config.BigBlind)
+				g.recordAction(addr, HistoryPostBigBlind, stackBefore)
+				logrus.Infof("Player %s posted missed big blind: %d", addr, g.config.BigBlind)
+			}
+			if state.MissedSmallBlind {
+				dead := min(g.config.SmallBlind, state.Stack)
+				state.Stack -= dead
+				state.TotalBetThisHand += dead
+				g.currentPot += dead
+				g.recordAction(addr, HistoryPostDead, state.Stack + dead)
+				logrus.Infof("Player %s posted missed small blind dead: %d", addr, dead)
+			}
+		}
+		state.MissedBigBlind = false
+		state.MissedSmallBlind = false
+	}
+}
@@ -0,0 +1,199 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func seatAddr(seat int) string {
+	return fmt.Sprintf(":%d", 3000+seat)
+}
+
+// newSeatedTable sits a player in each of the first seats seats, with the
+// players in dealtIn dealt into the hand about to start.
+func newSeatedTable(t *testing.T, seats int, dealtIn ...int) *Game {
+	t.Helper()
+	g := NewGame(seatAddr(0), TableConfig{MaxSeats: seats}, drainBroadcasts())
+	for seat := 0; seat < seats; seat++ {
+		addr := seatAddr(seat)
+		if seat > 0 {
+			g.AddPlayer(addr)
+		}
+		if err := g.seatPlayer(addr, seat, 0); err != nil {
+			t.Fatal(err)
+		}
+		g.playerStates[addr].IsReady = true
+	}
+	for _, seat := range dealtIn {
+		g.playerStates[seatAddr(seat)].InHand = true
+	}
+	g.handNumber = 1
+	return g
+}
+
+func TestAdvanceButton(t *testing.T) {
+	tests := []struct {
+		name 			string
+		dealtIn 		[]int
+		button, sb, bb 	int
+		missedBB 		[]int
+		missedSB 		[]int
+	}{
+		{
+			name: 		"everyone dealt in",
+			dealtIn: 	[]int{0, 1, 2, 3},
+			button: 	1, sb: 2, bb: 3,
+		},
+		{
+			name: 		"big blind passes a player sitting out",
+			dealtIn: 	[]int{0, 1, 2},
+			button: 	1, sb: 2, bb: 0,
+			missedBB: 	[]int{3},
+			missedSB: 	[]int{3},
+		},
+		{
+			name: 		"dead button on the old small blind",
+			dealtIn: 	[]int{0, 2, 3},
+			button: 	1, sb: 2, bb: 3,
+		},
+		{
+			name: 		"dead small blind on the old big blind",
+			dealtIn: 	[]int{0, 1, 3},
+			button: 	1, sb: 2, bb: 3,
+			missedSB: 	[]int{2},
+		},
+		{
+			name: 		"heads-up rotates with the button on the small blind",
+			dealtIn: 	[]int{0, 2},
+			button: 	2, sb: 2, bb: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newSeatedTable(t, 4, tt.dealtIn...)
+			g.currentDealerID, g.smallBlindID, g.bigBlindID = 0, 1, 2
+
+			g.advanceButton()
+
+			if g.currentDealerID != tt.button || g.smallBlindID != tt.sb || g.bigBlindID != tt.bb {
+				t.Fatalf("button/sb/bb = %d/%d/%d, want %d/%d/%d",
+					g.currentDealerID, g.smallBlindID, g.bigBlindID, tt.button, tt.sb, tt.bb)
+			}
+			for seat := 0; seat < 4; seat++ {
+				state := g.playerStates[seatAddr(seat)]
+				if want := containsSeat(tt.missedBB, seat); state.MissedBigBlind != want {
+					t.Errorf("seat %d missed big blind = %v, want %v", seat, state.MissedBigBlind, want)
+				}
+				if want := containsSeat(tt.missedSB, seat); state.MissedSmallBlind != want {
+					t.Errorf("seat %d missed small blind = %v, want %v", seat, state.MissedSmallBlind, want)
+				}
+			}
+		})
+	}
+}
+
+func containsSeat(seats []int, seat int) bool {
+	for _, s := range seats {
+		if s == seat {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPostMissedBlinds(t *testing.T) {
+	tests := []struct {
+		name 				string
+		seat 				int
+		missedBB, missedSB 	bool
+		wantBet, wantPot 	int
+	}{
+		{name: "owes both blinds", seat: 0, missedBB: true, missedSB: true, wantBet: BigBlind, wantPot: BigBlind + SmallBlind},
+		{name: "owes the small blind only", seat: 0, missedSB: true, wantBet: 0, wantPot: SmallBlind},
+		{name: "in the big blind owes nothing more", seat: 3, missedBB: true, missedSB: true, wantBet: 0, wantPot: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newSeatedTable(t, 4, 0, 1, 2, 3)
+			g.bigBlindID = 3
+			state := g.playerStates[seatAddr(tt.seat)]
+			state.MissedBigBlind, state.MissedSmallBlind = tt.missedBB, tt.missedSB
+			stack := state.Stack
+
+			g.postMissedBlinds()
+
+			if state.CurrentRoundBet != tt.wantBet || g.currentPot != tt.wantPot {
+				t.Fatalf("bet %d, pot %d, want bet %d, pot %d", state.CurrentRoundBet, g.currentPot, tt.wantBet, tt.wantPot)
+			}
+			if state.Stack != stack-tt.wantPot {
+				t.Fatalf("stack %d, want %d", state.Stack, stack-tt.wantPot)
+			}
+			if state.MissedBigBlind || state.MissedSmallBlind {
+				t.Fatal("missed blinds not cleared")
+			}
+		})
+	}
+}
+
+func TestSeatClaims(t *testing.T) {
+	tests := []struct {
+		name 		string
+		heldAt 		int64
+		claimAt 	int64
+		wantErr 	error
+		wantSeated 	string
+	}{
+		{name: "earlier claim takes the seat", heldAt: 20, claimAt: 10, wantSeated: seatAddr(2)},
+		{name: "later claim is refused", heldAt: 10, claimAt: 20, wantErr: ErrSeatTaken, wantSeated: seatAddr(1)},
+		{name: "tie goes to the lower address", heldAt: 10, claimAt: 10, wantErr: ErrSeatTaken, wantSeated: seatAddr(1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGame(seatAddr(0), TableConfig{MaxSeats: 6}, drainBroadcasts())
+			g.AddPlayer(seatAddr(1))
+			g.AddPlayer(seatAddr(2))
+			if err := g.seatPlayer(seatAddr(1), 4, tt.heldAt); err != nil {
+				t.Fatal(err)
+			}
+
+			err := g.seatPlayer(seatAddr(2), 4, tt.claimAt)
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if got := g.rotationMap[4]; got != tt.wantSeated {
+				t.Fatalf("seat 4 held by %s, want %s", got, tt.wantSeated)
+			}
+			for _, addr := range []string{seatAddr(1), seatAddr(2)} {
+				if seated := g.playerStates[addr].HasSeat; seated != (addr == tt.wantSeated) {
+					t.Errorf("%s has seat = %v", addr, seated)
+				}
+			}
+		})
+	}
+}
+
+func TestIsSittingOut(t *testing.T) {
+	tests := []struct {
+		name 		string
+		handNumber 	int
+		state 		PlayerState
+		want 		bool
+	}{
+		{name: "dealt in", handNumber: 3, state: PlayerState{IsActive: true, IsReady: true, InHand: true}},
+		{name: "left out of the hand", handNumber: 3, state: PlayerState{IsActive: true, IsReady: true}, want: true},
+		{name: "ready before the first hand", handNumber: 0, state: PlayerState{IsActive: true, IsReady: true}},
+		{name: "not ready", handNumber: 3, state: PlayerState{IsActive: true, InHand: true}, want: true},
+		{name: "disconnected", handNumber: 3, state: PlayerState{IsReady: true, InHand: true}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGame(seatAddr(0), TableConfig{}, drainBroadcasts())
+			g.handNumber = tt.handNumber
+			if got := g.isSittingOut(&tt.state); got != tt.want {
+				t.Fatalf("sitting out = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
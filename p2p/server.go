@@ -2,6 +2,9 @@ package p2p
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/gob"
 	"fmt"
 	"net"
@@ -13,9 +16,15 @@ import (
 
 const (
 	defaultMaxPlayers = 6
+	defaultMaxWaitList = 4
 	handshakeTimeout = 3 * time.Second
+	handshakeNonceSize = 32
 )
 
+// handshakeDomain is prefixed to a handshake nonce before it is signed, so
+// the proof cannot be passed off as any other signed message.
+const handshakeDomain = "peerpoker-handshake:"
+
 type GameVariant uint8
 
 const (
@@ -38,8 +47,30 @@ type ServerConfig struct {
 	Version 		string 
 	ListenAddr 		string 
 	APIListenAddr 	string 
+	// GRPCListenAddr serves the API over gRPC as well. Empty leaves gRPC off.
+	GRPCListenAddr 	string
 	GameVariant 	GameVariant 
 	MaxPlayers 		int 
+	MaxWaitList 	int
+	Table 			TableConfig
+	// DataDir holds the node's storage: snapshots, the event log, hand
+	// histories, statistics and the node identity. The game is recovered
+	// from it on startup. Empty keeps everything in memory.
+	DataDir 		string
+	// Passphrase encrypts the keystore holding the node identity and our
+	// deck keys. Without it they are stored in plaintext.
+	Passphrase 		string
+	// Auth holds the API tokens and allowed browser origins. Missing tokens
+	// are generated by NewServer.
+	Auth 			AuthConfig
+	// Host is set on the node that opens the table. Its identity alone can
+	// authorise control commands, others need a majority of the players.
+	Host 			bool
+	// HostKey pins the identity key of the table's host. Without it we
+	// follow the host the Seed peer names.
+	HostKey 		ed25519.PublicKey
+	// Seed is the address of the peer we join the table through.
+	Seed 			string
 }
 
 type Server struct {
@@ -48,25 +79,100 @@ type Server struct {
 	peerLock 		sync.RWMutex
 	peers 			map[string]*Peer
 	addPeer 		chan *Peer
+	// joinch carries peers that passed the handshake back to the loop
+	joinch 			chan *verifiedPeer
 	delPeer 		chan *Peer
 	msgch 			chan *Message
 	broadcastch 	chan BroadcastTo
 	gameState 		*Game
+	rejoinPeers 	[]string
+	storage 		Storage
+	keys 			*Keystore
+	identity 		*NodeIdentity
+	stats 			*StatsTracker
+	subscriptions 	[]*Subscription
 }
 
 func NewServer(cfg ServerConfig) *Server {
 	if cfg.MaxPlayers == 0{
 		cfg.MaxPlayers = defaultMaxPlayers
 	}
+	if cfg.MaxWaitList == 0 {
+		cfg.MaxWaitList = defaultMaxWaitList
+	}
+	cfg.Table.MaxSeats = cfg.MaxPlayers
+	cfg.Table.MaxWaitList = cfg.MaxWaitList
+	if err := validateTableConfig(cfg.Table.withDefaults()); err != nil {
+		logrus.Fatalf("Invalid table config: %s", err)
+	}
+	auth, err := cfg.Auth.withTokens()
+	if err != nil {
+		logrus.Fatalf("Failed to set up API tokens: %s", err)
+	}
+	cfg.Auth = auth
 	s := &Server{
 		ServerConfig: 	cfg,
 		peers: 			make(map[string]*Peer),
 		addPeer: 		make(chan *Peer, 10),
+		joinch: 		make(chan *verifiedPeer, 10),
 		delPeer: 		make(chan *Peer, 10),
 		msgch: 			make(chan *Message, 100),
 		broadcastch: 	make(chan BroadcastTo, 100),
 	}
-	s.gameState = NewGame(s.ListenAddr, s.broadcastch)
+	s.gameState = NewGame(s.ListenAddr, cfg.Table, s.broadcastch)
+	s.storage = NewMemoryStorage()
+	if cfg.DataDir != "" {
+		storage, err := OpenDiskStorage(cfg.DataDir)
+		if err != nil {
+			logrus.Errorf("Failed to open data directory %s, keeping everything in memory: %s", cfg.DataDir, err)
+		} else {
+			s.storage = storage
+		}
+	}
+	s.keys = NewKeystore(s.storage, cfg.Passphrase)
+	identity, err := LoadOrCreateIdentity(s.keys)
+	if err != nil {
+		logrus.Fatalf("Failed to load node identity: %s", err)
+	}
+	s.identity = identity
+	logrus.Infof("Node identity %s", identity.ID)
+	if cfg.Host {
+		logrus.Infof("Players can pin this table's host with -host-key %s", hex.EncodeToString(identity.PublicKey))
+	}
+	if cfg.HostKey != nil && len(cfg.HostKey) != ed25519.PublicKeySize {
+		logrus.Fatalf("Host key must be %d bytes, got %d", ed25519.PublicKeySize, len(cfg.HostKey))
+	}
+
+	if history, err := NewHandHistoryStore(s.storage); err != nil {
+		logrus.Errorf("Failed to load hand history, keeping it in memory: %s", err)
+	} else {
+		s.gameState.handHistory = history
+	}
+	if stats, err := NewStatsTracker(s.storage); err != nil {
+		logrus.Errorf("Failed to load player stats, keeping them in memory: %s", err)
+		s.stats, _ = NewStatsTracker(NewMemoryStorage())
+	} else {
+		s.stats = stats
+	}
+	if err := s.stats.CatchUp(s.gameState.handHistory.All()); err != nil {
+		logrus.Errorf("Failed to update player stats from hand history: %s", err)
+	}
+	s.subscriptions = append(s.subscriptions,
+		s.stats.Follow(s.gameState.Events(), s.gameState.handHistory),
+		logEvents(s.gameState.Events()),
+	)
+	if cfg.DataDir != "" {
+		peers, err := s.gameState.EnablePersistence(s.storage, s.keys)
+		if err != nil {
+			logrus.Errorf("Crash recovery disabled: %s", err)
+		}
+		s.rejoinPeers = peers
+	}
+	s.gameState.SetIdentity(identity, cfg.Host)
+	if cfg.HostKey != nil {
+		s.gameState.PinHostKey(cfg.HostKey)
+	}
+	s.gameState.SetPeerRemover(s.removePlayer)
 	tr := NewTCPTransport(s.ListenAddr)
 	s.transport = tr 
 
@@ -74,7 +180,10 @@ func NewServer(cfg ServerConfig) *Server {
 	tr.DelPeer = s.delPeer
 
 	go func(s *Server){
-		apiServer := NewAPIServer(cfg.APIListenAddr, s.gameState, s)
+		apiServer := NewAPIServer(cfg.APIListenAddr, s.gameState, s, cfg.Auth)
+		if cfg.GRPCListenAddr != "" {
+			go NewGRPCServer(cfg.GRPCListenAddr, apiServer).Run()
+		}
 		apiServer.Run()
 		logrus.WithFields(logrus.Fields{
 			"listenAddr": cfg.APIListenAddr,
@@ -85,6 +194,7 @@ func NewServer(cfg ServerConfig) *Server {
 
 func (s *Server) Start() {
 	go s.loop()
+	go s.rejoinTable()
 	logrus.WithFields(logrus.Fields{
 		"p2p-port": s.ListenAddr,
 		"variant": s.GameVariant,
@@ -93,6 +203,67 @@ func (s *Server) Start() {
 	s.transport.ListenAndAccept()
 }
 
+// Close snapshots the game and closes the node's storage.
+func (s *Server) Close() error {
+	for _, sub := range s.subscriptions {
+		sub.Close()
+	}
+	if s.gameState.store != nil {
+		if err := s.gameState.SaveSnapshot(); err != nil {
+			logrus.Errorf("Failed to save snapshot: %s", err)
+		}
+	}
+	return s.storage.Close()
+}
+
+// logEvents logs every event the game publishes at debug level.
+func logEvents(bus *EventBus) *Subscription {
+	sub, _ := bus.Subscribe(SubscribeOptions{Policy: BackpressureDropOldest})
+	go func() {
+		for event := range sub.C {
+			logrus.WithFields(logrus.Fields{
+				"seq": 	event.Seq,
+				"type": event.Type,
+			}).Debugf("Table event: %+v", event.Data)
+		}
+	}()
+	return sub
+}
+
+// removePlayer drops the connection to a player kicked or banned by a
+// control command. A node that is itself removed leaves the table.
+func (s *Server) removePlayer(addr string) {
+	if addr == s.ListenAddr {
+		logrus.Warn("We were removed from the table by a control command")
+		for _, peer := range s.Peers() {
+			s.dropPeer(peer)
+		}
+		return
+	}
+	s.dropPeer(addr)
+}
+
+// dropPeer closes the connection to a peer. Its read loop then removes it
+// from the table.
+func (s *Server) dropPeer(addr string) {
+	peer, ok := s.GetPeer(addr)
+	if !ok {
+		return
+	}
+	logrus.Infof("Disconnecting peer %s", addr)
+	peer.conn.Close()
+}
+
+// rejoinTable reconnects to the players of a table recovered from disk.
+func (s *Server) rejoinTable() {
+	for _, addr := range s.rejoinPeers {
+		logrus.Infof("Rejoining recovered table peer %s", addr)
+		if err := s.Connect(addr); err != nil {
+			logrus.Warnf("Failed to rejoin peer %s: %s", addr, err)
+		}
+	}
+}
+
 func (s *Server) AddPeer(p *Peer) {
 	s.peerLock.Lock()
 	defer s.peerLock.Unlock()
@@ -116,11 +287,26 @@ func (s *Server) Peers() []string {
 	return peers
 }
 
-func (s *Server) SendHandshake(p *Peer) error {
+// SendHandshake sends our handshake with a fresh nonce for the peer to
+// sign. challenge is the nonce of the handshake we reply to, nil if ours
+// opens the connection.
+func (s *Server) SendHandshake(p *Peer, challenge []byte) error {
+	p.nonce = make([]byte, handshakeNonceSize)
+	if _, err := rand.Read(p.nonce); err != nil {
+		return err
+	}
 	hs := &Handshake{
 		GameVariant: s.GameVariant,
 		Version: s.Version,
 		ListenAddr: s.ListenAddr,
+		PublicKey: s.identity.PublicKey,
+		HostKey: s.gameState.HostKey(),
+		Config: s.gameState.Config(),
+		DeckCommitment: s.gameState.DeckCommitment(),
+		Nonce: p.nonce,
+	}
+	if challenge != nil {
+		hs.Proof = s.identity.Sign(handshakeChallenge(challenge, s.ListenAddr))
 	}
 	buf := new(bytes.Buffer)
 	if err := gob.NewEncoder(buf).Encode(hs); err != nil {
@@ -143,9 +329,14 @@ func (s *Server) Connect(addr string) error {
 	peer := &Peer{
 		conn: conn,
 		outbound: true,
+		dialAddr: addr,
+	}
+	if err := s.SendHandshake(peer, nil); err != nil {
+		conn.Close()
+		return err
 	}
 	s.addPeer <- peer
-	return s.SendHandshake(peer)
+	return nil
 } 
 
 func (s *Server) loop() {
@@ -158,7 +349,9 @@ func (s *Server) loop() {
 		case peer := <-s.delPeer:
 			s.handleDelPeer(peer)
 		case peer := <-s.addPeer:
-			if err := s.handleNewPeer(peer); err != nil {
+			go s.handshakePeer(peer)
+		case joined := <-s.joinch:
+			if err := s.handleNewPeer(joined.peer, joined.hs); err != nil {
 				logrus.Errorf("handle new peer error: %s", err)
 			}
 		case msg := <-s.msgch:
@@ -171,25 +364,45 @@ func (s *Server) loop() {
 	}
 }
 
-func (s *Server) handleNewPeer(peer *Peer) error {
+// verifiedPeer is a peer that passed the handshake and proved its identity.
+type verifiedPeer struct {
+	peer 	*Peer
+	hs 		*Handshake
+}
+
+// handshakePeer runs the handshake with a new connection on a goroutine of
+// its own, so a slow or silent peer cannot hold up the loop, and hands the
+// peer to the loop once it has proved who it is.
+func (s *Server) handshakePeer(peer *Peer) {
 	peer.conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
 	hs, err := s.handshake(peer)
 	if err != nil {
 		peer.conn.Close()
-		return fmt.Errorf("handshake with incoming player failed: %s", err)
+		logrus.Errorf("handshake with player failed: %s", err)
+		return
+	}
+	if err := s.proveIdentity(peer, hs); err != nil {
+		peer.conn.Close()
+		logrus.Errorf("identity check with player %s failed: %s", hs.ListenAddr, err)
+		return
 	}
 	peer.conn.SetReadDeadline(time.Time{})
 	peer.listenAddr = hs.ListenAddr
+	s.joinch <- &verifiedPeer{peer: peer, hs: hs}
+}
+
+// handleNewPeer lets a verified peer in. Handshakes run side by side, so
+// the table may have filled up while this one was going on.
+func (s *Server) handleNewPeer(peer *Peer, hs *Handshake) error {
+	if err := s.checkCapacity(); err != nil {
+		peer.conn.Close()
+		return err
+	}
 	s.AddPeer(peer)
 
 	go peer.ReadLoop(s.msgch, s.delPeer)
 
 	if !peer.outbound {
-		if err := s.SendHandshake(peer); err !=  nil {
-			peer.conn.Close()
-			s.handleDelPeer(peer)
-			return fmt.Errorf("failed to send handshake to peer: %s", err)
-		}
 		go func(){
 			if err := s.sendPeerList(peer); err != nil{
 				logrus.Errorf("error sending peer list: %s", err)
@@ -201,7 +414,15 @@ func (s *Server) handleNewPeer(peer *Peer) error {
 		"outbound": peer.outbound,
 		"version": hs.Version,
 	}).Info("handshake successful")
+	// only the peer we chose to join through may tell us the table's rules
+	// and who the host is
+	if peer.outbound && peer.dialAddr == s.Seed {
+		s.gameState.AdoptConfig(hs.Config)
+		s.gameState.FollowHost(hs.HostKey)
+	}
 	s.gameState.AddPlayer(peer.listenAddr)
+	s.gameState.SetPlayerIdentity(peer.listenAddr, hs.PublicKey)
+	s.gameState.SetDeckCommitment(peer.listenAddr, hs.DeckCommitment)
 	return nil
 
  }
@@ -239,9 +460,21 @@ func (s *Server) Broadcast(broadcastMsg BroadcastTo) error {
 	return nil
 }
 
+// checkCapacity refuses peers once the seats and the waiting list are full.
+func (s *Server) checkCapacity() error {
+	s.peerLock.RLock()
+	defer s.peerLock.RUnlock()
+
+	// peers beyond the seats can still join the waiting list
+	if len(s.peers) >= s.MaxPlayers + s.MaxWaitList {
+		return fmt.Errorf("max players exceeded (%d seats, %d waiting)", s.MaxPlayers, s.MaxWaitList)
+	}
+	return nil
+}
+
 func (s *Server) handshake(p *Peer) (*Handshake, error) {
-	if len(s.peers) >= s.MaxPlayers {
-		return nil, fmt.Errorf("max players exceeded (%d)", s.MaxPlayers)
+	if err := s.checkCapacity(); err != nil {
+		return nil, err
 	}
 	hs := &Handshake{}
 	if err := gob.NewDecoder(p.conn).Decode(hs); err != nil {
@@ -253,9 +486,70 @@ func (s *Server) handshake(p *Peer) (*Handshake, error) {
 	if s.Version != hs.Version{
 		return nil, fmt.Errorf("invalid version: want %s but got %s", s.Version, hs.Version)
 	}
+	if len(hs.PublicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("peer %s sent no identity key", hs.ListenAddr)
+	}
+	if len(hs.DeckCommitment) == 0 {
+		return nil, fmt.Errorf("peer %s sent no deck key commitment", hs.ListenAddr)
+	}
+	if len(hs.Nonce) != handshakeNonceSize {
+		return nil, fmt.Errorf("peer %s sent no handshake nonce", hs.ListenAddr)
+	}
+	id := identityID(hs.PublicKey)
+	if s.gameState.IsBanned(id) {
+		return nil, fmt.Errorf("identity %s is banned from the table", id)
+	}
+	if s.HostKey != nil && len(hs.HostKey) > 0 && !s.HostKey.Equal(hs.HostKey) {
+		return nil, fmt.Errorf("peer %s follows host %s, the pinned host is %s", hs.ListenAddr, identityID(hs.HostKey), identityID(s.HostKey))
+	}
+	// one identity is one player, however many addresses it dials from
+	if addr, ok := s.gameState.AddrOf(id); ok && addr != hs.ListenAddr {
+		if _, connected := s.GetPeer(addr); connected || addr == s.ListenAddr {
+			return nil, fmt.Errorf("identity %s is already at the table as %s", id, addr)
+		}
+	}
+	// a peer we dialled answers our nonce in its handshake
+	if p.outbound && !verifyHandshakeProof(hs, p.nonce, hs.Proof) {
+		return nil, fmt.Errorf("peer %s did not prove it holds identity %s", hs.ListenAddr, id)
+	}
 	return hs, nil
 }
 
+// proveIdentity finishes the handshake challenge. A peer we dialled gets
+// the answer to its nonce. A peer that dialled us gets our handshake, which
+// answers its nonce, and has to answer ours before it is let in.
+func (s *Server) proveIdentity(p *Peer, hs *Handshake) error {
+	if p.outbound {
+		proof := &HandshakeProof{Signature: s.identity.Sign(handshakeChallenge(hs.Nonce, s.ListenAddr))}
+		buf := new(bytes.Buffer)
+		if err := gob.NewEncoder(buf).Encode(proof); err != nil {
+			return err
+		}
+		return p.Send(buf.Bytes())
+	}
+	if err := s.SendHandshake(p, hs.Nonce); err != nil {
+		return fmt.Errorf("failed to send handshake: %s", err)
+	}
+	proof := &HandshakeProof{}
+	if err := gob.NewDecoder(p.conn).Decode(proof); err != nil {
+		return err
+	}
+	if !verifyHandshakeProof(hs, p.nonce, proof.Signature) {
+		return fmt.Errorf("peer did not prove it holds identity %s", identityID(hs.PublicKey))
+	}
+	return nil
+}
+
+// handshakeChallenge is what a node signs to prove it holds its identity
+// key: the other node's nonce, bound to the address it joins with.
+func handshakeChallenge(nonce []byte, listenAddr string) []byte {
+	return append([]byte(handshakeDomain+listenAddr), nonce...)
+}
+
+func verifyHandshakeProof(hs *Handshake, nonce, signature []byte) bool {
+	return ed25519.Verify(hs.PublicKey, handshakeChallenge(nonce, hs.ListenAddr), signature)
+}
+
 func (s *Server) sendPeerList(p *Peer) error {
 	peerListMsg := MessagePeerList{
 		Peers: s.Peers(),
@@ -314,12 +608,28 @@ func (s *Server) handleMessage(msg *Message) error {
 			return s.gameState.HandleRPCRequest(msg.From, v)
 		case MessageRPCResponse:
 			s.gameState.HandleRPCResponse(msg.From, v)
-		case MessageRevealKeys:
-			if s.gameState.GetStatus() == GameStatusShowdown {
-				s.gameState.HandleShowdownKeyReveal(msg.From, v)
-			} else {
-				s.gameState.HandleFoldKeyReveal(msg.From, v)
-			}
+		case MessageRevealRequest:
+			return s.gameState.HandleRevealRequest(msg.From, v)
+		case MessageCardsRevealed:
+			return s.gameState.HandleCardsRevealed(msg.From, v)
+		case MessageMuck:
+			return s.gameState.HandleMuck(msg.From)
+		case MessageTakeSeat:
+			return s.gameState.HandleTakeSeat(msg.From, v)
+		case MessageLeaveSeat:
+			return s.gameState.HandleLeaveSeat(msg.From)
+		case MessageJoinWaitList:
+			return s.gameState.HandleJoinWaitList(msg.From, v)
+		case MessageBuyIn:
+			return s.gameState.HandleBuyIn(msg.From, v)
+		case MessageStraddle:
+			return s.gameState.HandleStraddle(msg.From, v)
+		case MessageRunIt:
+			return s.gameState.HandleRunIt(msg.From, v)
+		case MessageRabbitHunt:
+			return s.gameState.HandleRabbitHunt(msg.From, v)
+		case MessageControl:
+			return s.gameState.HandleControl(msg.From, v)
 		default:
 			logrus.Warnf("Received unhandled message type from %s", msg.From)
 	}
@@ -351,6 +661,7 @@ func (s *Server) handleMsgEncDeck(from string, msg MessageEncDeck) error {
 
 func init() {
 	gob.Register(Handshake{})
+	gob.Register(HandshakeProof{})
 	gob.Register(MessagePeerList{})
 	gob.Register(MessagePlayerAction{})
 	gob.Register(MessageReady{})
@@ -360,7 +671,17 @@ func init() {
 	gob.Register(MessageShuffleStatus{})
 	gob.Register(MessageGetRPC{})
 	gob.Register(MessageRPCResponse{})
-	gob.Register(MessageRevealKeys{})
 	gob.Register(MessageShowdownResult{})
 	gob.Register(MessageShuffleStatus{})
+	gob.Register(MessageTakeSeat{})
+	gob.Register(MessageLeaveSeat{})
+	gob.Register(MessageJoinWaitList{})
+	gob.Register(MessageBuyIn{})
+	gob.Register(MessageStraddle{})
+	gob.Register(MessageRunIt{})
+	gob.Register(MessageRevealRequest{})
+	gob.Register(MessageCardsRevealed{})
+	gob.Register(MessageMuck{})
+	gob.Register(MessageRabbitHunt{})
+	gob.Register(MessageControl{})
 }
\ No newline at end of file
@@ -0,0 +1,593 @@
+package p2p
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// showDecisionTimeout is how long a player holding a losing hand at showdown
+// has to decide to show it before it is mucked for them.
+const showDecisionTimeout = 10 * time.Second
+
+func (g *Game) resetShowdown() {
+	g.lastAggressorID = -1
+	g.showOrder = nil
+	g.shownCards = make(map[string][]Card)
+	g.mucked = make(map[string]bool)
+	g.revealedCards = make(map[int]Card)
+	g.showInFlight = false
+	g.showDecisionPending = false
+	g.resolving = false
+}
+
+// InitiateShowdown works out who shows first and starts revealing hands.
+// Nobody publishes their deck keys: each shown card goes around the
+// decryption ring once and only the cards needed to settle the pot are
+// ever revealed.
+func (g *Game) InitiateShowdown() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	logrus.Info("!!! SHOWDOWN REACHED !!!")
+	g.showOrder = g.getShowdownOrder()
+	logrus.Infof("Showdown order: %v", g.showOrder)
+
+	if g.runOut && g.listenAddr == g.rotationMap[g.dealerSeat()] {
+		indices := []int{}
+		seen := make(map[int]bool)
+		for run := 0; run < g.runItTimes; run++ {
+			for _, idx := range g.runBoardIndices(run) {
+				if !seen[idx] && idx < len(g.currentDeck) {
+					seen[idx] = true
+					indices = append(indices, idx)
+				}
+			}
+		}
+		g.startPublicReveal(RevealBoard, indices)
+	}
+	g.advanceShowdown()
+}
+
+// getShowdownOrder lists the players still in the hand in the order they
+// must show: the last player to bet or raise on the final street goes
+// first, otherwise the first player left of the button, then clockwise.
+func (g *Game) getShowdownOrder() []string {
+	start := g.lastAggressorID
+	if !g.isLiveSeat(start) {
+		start = (g.currentDealerID + 1) % max(g.nextRotationID, 1)
+	}
+	order := []string{}
+	seat := start
+	for i := 0; i < g.nextRotationID; i++ {
+		if g.isLiveSeat(seat) {
+			order = append(order, g.rotationMap[seat])
+		}
+		seat = (seat + 1) % g.nextRotationID
+	}
+	return order
+}
+
+func (g *Game) isLiveSeat(seat int) bool {
+	addr, ok := g.rotationMap[seat]
+	if !ok {
+		return false
+	}
+	state := g.playerStates[addr]
+	return state.InHand && state.IsActive && !state.IsFolded
+}
+
+// advanceShowdown moves the showdown along after every show or muck. Once
+// each player has acted and the board is complete the pot is settled.
+func (g *Game) advanceShowdown() {
+	if GameStatus(g.currentStatus.Get()) != GameStatusShowdown || g.showOrder == nil {
+		return
+	}
+	if !g.boardsComplete() {
+		return
+	}
+	for _, addr := range g.showOrder {
+		if g.hasShownHand(addr) || g.mucked[addr] {
+			continue
+		}
+		if addr == g.listenAddr && !g.showInFlight && !g.showDecisionPending {
+			g.decideShowOrMuck()
+		}
+		return
+	}
+	if !g.resolving {
+		g.resolving = true
+		go g.ResolveWinner()
+	}
+}
+
+// decideShowOrMuck shows our hand straight away when it can still win a pot
+// and otherwise gives the player showDecisionTimeout to choose to show it.
+func (g *Game) decideShowOrMuck() {
+	if g.mustShow() {
+		g.showHoleCards()
+		return
+	}
+	g.showDecisionPending = true
+	logrus.Infof("Our hand cannot win, mucking in %s unless we choose to show", showDecisionTimeout)
+	handNumber := g.handNumber
+	time.AfterFunc(showDecisionTimeout, func() {
+		g.lock.Lock()
+		defer g.lock.Unlock()
+		if g.handNumber == handNumber && g.showDecisionPending {
+			g.muckHand()
+		}
+	})
+}
+
+// mustShow reports whether our hand ties or beats every hand shown so far
+// in any pot we are eligible for, on any board. A pot nobody has shown for
+// yet always needs us to show.
+func (g *Game) mustShow() bool {
+	if len(g.myHand) < 2 {
+		return true
+	}
+	boards := g.showdownBoards()
+	for _, pot := range g.showdownPots() {
+		eligible := false
+		for _, addr := range pot.EligiblePlayers {
+			if addr == g.listenAddr {
+				eligible = true
+			}
+		}
+		if !eligible {
+			continue
+		}
+		for _, board := range boards {
+			myRank, _ := EvaluateBestHand(g.myHand, board)
+			bestShown := int32(999999)
+			for _, addr := range pot.EligiblePlayers {
+				if !g.hasShownHand(addr) {
+					continue
+				}
+				rank, _ := EvaluateBestHand(g.shownCards[addr], board)
+				bestShown = min(bestShown, rank)
+			}
+			if myRank <= bestShown {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// showdownPots returns the side pots, or the whole pot when nobody put in
+// more than anyone else could cover.
+func (g *Game) showdownPots() []SidePot {
+	pots := g.calculateSidePots()
+	if len(pots) > 0 {
+		return pots
+	}
+	return []SidePot{{Amount: g.currentPot, EligiblePlayers: g.showOrder}}
+}
+
+func (g *Game) showHoleCards() {
+	g.showDecisionPending = false
+	g.showInFlight = true
+	logrus.Info("Showing our hand")
+	g.logEvent(EventShowdown, g.listenAddr)
+	g.startPublicReveal(RevealHand, g.getMyHoleCardIndices())
+}
+
+func (g *Game) muckHand() {
+	g.showDecisionPending = false
+	g.mucked[g.listenAddr] = true
+	logrus.Info("Mucking our hand")
+	g.logEvent(EventShowdown, g.listenAddr)
+	g.sendToPlayers(MessageMuck{}, g.getOtherPlayers()...)
+	g.advanceShowdown()
+}
+
+// ShowCards reveals our hole cards to the table. At showdown it shows the
+// whole hand when it is our turn. After folding, or after winning without a
+// showdown, it reveals only the chosen cards (0 and/or 1) of our hand.
+func (g *Game) ShowCards(which []int) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	state := g.playerStates[g.listenAddr]
+	if !state.InHand || len(g.myHand) < 2 {
+		return newRuleError(ErrIllegalAction, "no cards to show")
+	}
+	if GameStatus(g.currentStatus.Get()) == GameStatusShowdown && !state.IsFolded {
+		if !g.showDecisionPending {
+			return newRuleError(ErrNotYourTurn, "it is not our turn to show")
+		}
+		g.showHoleCards()
+		return nil
+	}
+	if !state.IsFolded && GameStatus(g.currentStatus.Get()) != GameStatusHandComplete {
+		return newRuleError(ErrIllegalAction, "cards can only be shown after folding or once the hand is over")
+	}
+	if len(which) == 0 {
+		which = []int{0, 1}
+	}
+	holes := g.getMyHoleCardIndices()
+	indices := []int{}
+	for _, i := range which {
+		if i < 0 || i > 1 {
+			return newRuleError(ErrInvalidRequest, "card %d is not in our hand, choose 0 or 1", i)
+		}
+		indices = append(indices, holes[i])
+	}
+	g.startPublicReveal(RevealHand, indices)
+	return nil
+}
+
+// Muck throws away our hand at showdown instead of showing it. A hand that
+// could still win a pot cannot be mucked.
+func (g *Game) Muck() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if !g.showDecisionPending {
+		return newRuleError(ErrNotYourTurn, "it is not our turn to show or muck")
+	}
+	g.muckHand()
+	return nil
+}
+
+func (g *Game) HandleMuck(from string) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	state, ok := g.playerStates[from]
+	if !ok || !state.InHand || state.IsFolded {
+		return fmt.Errorf("player %s has no hand to muck", from)
+	}
+	g.mucked[from] = true
+	logrus.Infof("Player %s mucked", from)
+	g.logEvent(EventShowdown, from)
+	g.advanceShowdown()
+	return nil
+}
+
+// startPublicReveal strips our layer from the given deck positions and
+// sends them around the ring. Every player proves their layer, so whoever
+// finishes the decryption cannot pass off other cards than the ones at
+// these positions. Positions already revealed are not revealed again.
+func (g *Game) startPublicReveal(kind RevealKind, indices []int) {
+	pending := []int{}
+	encrypted := [][]byte{}
+	for _, idx := range indices {
+		if _, seen := g.revealedCards[idx]; seen {
+			continue
+		}
+		pending = append(pending, idx)
+		encrypted = append(encrypted, g.currentDeck[idx])
+	}
+	g.startReveal(kind, g.handNumber, pending, encrypted)
+}
+
+// startReveal sends encrypted cards of a hand around its ring. The players
+// on it hold every other layer of encryption on that hand's deck.
+func (g *Game) startReveal(kind RevealKind, handNumber int, indices []int, encrypted [][]byte) {
+	if len(indices) == 0 {
+		return
+	}
+	layer, err := g.revealLayer(encrypted)
+	if err != nil {
+		logrus.Errorf("Failed to strip our layer for a reveal: %s", err)
+		return
+	}
+	g.forwardPublicReveal(MessageRevealRequest{
+		Owner: 		g.listenAddr,
+		Kind: 		kind,
+		HandNumber: handNumber,
+		Indices: 	indices,
+		Layers: 	[]RevealLayer{layer},
+	})
+}
+
+// revealLayer strips our layer from data with a proof for every card.
+func (g *Game) revealLayer(data [][]byte) (RevealLayer, error) {
+	layer := RevealLayer{
+		Player: g.listenAddr,
+		Data: 	make([][]byte, len(data)),
+		Proofs: make([]DecryptionProof, len(data)),
+	}
+	for i, card := range data {
+		out, proof, err := g.deckKeys.DecryptWithProof(card)
+		if err != nil {
+			return RevealLayer{}, err
+		}
+		layer.Data[i] = out
+		layer.Proofs[i] = proof
+	}
+	return layer, nil
+}
+
+// nextInRevealRing returns who strips the next layer of a reveal after us,
+// worked out from our own view of the hand. The reveal is done once it would
+// go back to its owner.
+func (g *Game) nextInRevealRing(kind RevealKind) string {
+	if kind == RevealRabbit {
+		players := g.finishedHand.players
+		for i, addr := range players {
+			if addr == g.listenAddr {
+				return players[(i+1)%len(players)]
+			}
+		}
+		return ""
+	}
+	return g.rotationMap[g.getNextPlayerID(g.playerStates[g.listenAddr].RotationID)]
+}
+
+// checkRevealIndices makes sure a reveal only asks for cards the table may
+// see: the owner's own hole cards, the boards of an all-in hand being run
+// out, or the undealt board of a finished hand everyone agreed to rabbit
+// hunt.
+func (g *Game) checkRevealIndices(owner string, kind RevealKind, handNumber int, indices []int) error {
+	if len(indices) == 0 {
+		return fmt.Errorf("no positions to reveal")
+	}
+	allowed := map[int]bool{}
+	switch kind {
+	case RevealHand:
+		state, ok := g.playerStates[owner]
+		if !ok || !state.InHand {
+			return fmt.Errorf("%s was not dealt into hand #%d", owner, handNumber)
+		}
+		allowed[state.RotationID*2] = true
+		allowed[state.RotationID*2+1] = true
+	case RevealBoard:
+		if !g.runOut {
+			return fmt.Errorf("the board of hand #%d is not being run out", handNumber)
+		}
+		for run := 0; run < g.allowedRuns(); run++ {
+			for _, idx := range g.runBoardIndices(run) {
+				allowed[idx] = true
+			}
+		}
+	case RevealRabbit:
+		hand := g.finishedHand
+		if hand == nil || hand.handNumber != handNumber || !hand.started {
+			return fmt.Errorf("not everyone agreed to rabbit hunt hand #%d", handNumber)
+		}
+		for idx := hand.boardBase + len(hand.board); idx < hand.boardBase+5; idx++ {
+			allowed[idx] = true
+		}
+	default:
+		return fmt.Errorf("unknown reveal kind %d", kind)
+	}
+	for _, idx := range indices {
+		if !allowed[idx] {
+			return fmt.Errorf("position %d may not be revealed for %s", idx, owner)
+		}
+	}
+	return nil
+}
+
+// allowedRuns is the most boards a run-out may reveal. While the vote is
+// still open it is the smallest number asked for so far, which the result
+// can only go below.
+func (g *Game) allowedRuns() int {
+	if !g.runItPending {
+		return g.runItTimes
+	}
+	runs := g.runItLimit()
+	for _, vote := range g.runItVotes {
+		runs = min(runs, vote)
+	}
+	return runs
+}
+
+// handPlayers lists the players dealt into the current hand, each of whom
+// holds a layer of encryption on its deck.
+func (g *Game) handPlayers() []string {
+	players := []string{}
+	for seat := 0; seat < g.nextRotationID; seat++ {
+		if g.isDealtIn(seat) {
+			players = append(players, g.rotationMap[seat])
+		}
+	}
+	return players
+}
+
+// SetDeckCommitment records the commitment to a peer's deck key from their
+// handshake.
+func (g *Game) SetDeckCommitment(addr string, commitment []byte) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.deckCommitments[addr] = commitment
+}
+
+// DeckCommitment is the commitment to our deck key that peers check our
+// partial decryptions against.
+func (g *Game) DeckCommitment() []byte {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.deckKeys.Commitment()
+}
+
+func (g *Game) deckCommitment(addr string) []byte {
+	if addr == g.listenAddr {
+		return g.deckKeys.Commitment()
+	}
+	return g.deckCommitments[addr]
+}
+
+// revealSource returns the encrypted deck a reveal is made from and the
+// players holding a layer of encryption on it.
+func (g *Game) revealSource(kind RevealKind, handNumber int) ([][]byte, []string, error) {
+	if kind == RevealRabbit {
+		hand := g.finishedHand
+		if hand == nil || hand.handNumber != handNumber {
+			return nil, nil, fmt.Errorf("there is no finished hand #%d to rabbit hunt", handNumber)
+		}
+		return hand.deck, hand.players, nil
+	}
+	if handNumber != g.handNumber {
+		return nil, nil, fmt.Errorf("hand #%d is not the current hand", handNumber)
+	}
+	return g.currentDeck, g.handPlayers(), nil
+}
+
+// checkRevealLayers follows the partial decryptions in a reveal from our own
+// copy of the encrypted deck, checking each against the commitment of the
+// player who made it, and returns the data after the last layer. The owner
+// of the reveal must strip the first layer. When complete is set every
+// player holding a layer on the deck must have stripped theirs.
+func (g *Game) checkRevealLayers(owner string, kind RevealKind, handNumber int, indices []int, layers []RevealLayer, complete bool) ([][]byte, error) {
+	deck, holders, err := g.revealSource(kind, handNumber)
+	if err != nil {
+		return nil, err
+	}
+	if len(layers) == 0 || layers[0].Player != owner {
+		return nil, fmt.Errorf("the reveal was not started by %s", owner)
+	}
+	data := make([][]byte, len(indices))
+	for i, idx := range indices {
+		if idx < 0 || idx >= len(deck) {
+			return nil, fmt.Errorf("position %d is not in the deck", idx)
+		}
+		data[i] = deck[idx]
+	}
+	pending := make(map[string]bool, len(holders))
+	for _, addr := range holders {
+		pending[addr] = true
+	}
+	for _, layer := range layers {
+		if !pending[layer.Player] {
+			return nil, fmt.Errorf("%s holds no layer on the deck of hand #%d or stripped it twice", layer.Player, handNumber)
+		}
+		delete(pending, layer.Player)
+		commitment := g.deckCommitment(layer.Player)
+		if len(commitment) == 0 {
+			return nil, fmt.Errorf("no deck key commitment from %s", layer.Player)
+		}
+		if len(layer.Data) != len(data) || len(layer.Proofs) != len(data) {
+			return nil, fmt.Errorf("the layer from %s does not cover every card", layer.Player)
+		}
+		for i := range data {
+			if !VerifyDecryption(g.deckKeys.Prime, commitment, data[i], layer.Data[i], layer.Proofs[i]) {
+				return nil, fmt.Errorf("the layer from %s on position %d does not match their key", layer.Player, indices[i])
+			}
+		}
+		data = layer.Data
+	}
+	if complete && len(pending) > 0 {
+		return nil, fmt.Errorf("%d player(s) have not stripped their layer", len(pending))
+	}
+	return data, nil
+}
+
+func (g *Game) HandleRevealRequest(from string, msg MessageRevealRequest) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if err := g.checkRevealIndices(msg.Owner, msg.Kind, msg.HandNumber, msg.Indices); err != nil {
+		return fmt.Errorf("refusing to reveal cards for %s: %s", msg.Owner, err)
+	}
+	data, err := g.checkRevealLayers(msg.Owner, msg.Kind, msg.HandNumber, msg.Indices, msg.Layers, false)
+	if err != nil {
+		return fmt.Errorf("bad reveal from %s: %s", msg.Owner, err)
+	}
+	layer, err := g.revealLayer(data)
+	if err != nil {
+		return err
+	}
+	msg.Layers = append(msg.Layers, layer)
+	g.forwardPublicReveal(msg)
+	return nil
+}
+
+// forwardPublicReveal passes a reveal to the next player on the ring. The
+// last player before the owner announces every layer, and each node works
+// out the cards from them.
+func (g *Game) forwardPublicReveal(msg MessageRevealRequest) {
+	if next := g.nextInRevealRing(msg.Kind); next != msg.Owner && next != g.listenAddr {
+		g.sendToPlayers(msg, next)
+		return
+	}
+	revealed := MessageCardsRevealed{
+		Owner: 		msg.Owner,
+		Kind: 		msg.Kind,
+		HandNumber: msg.HandNumber,
+		Indices: 	msg.Indices,
+		Layers: 	msg.Layers,
+	}
+	g.sendToPlayers(revealed, g.getOtherPlayers()...)
+	g.applyCardsRevealed(revealed)
+}
+
+func (g *Game) HandleCardsRevealed(from string, msg MessageCardsRevealed) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.applyCardsRevealed(msg)
+	return nil
+}
+
+func (g *Game) applyCardsRevealed(msg MessageCardsRevealed) {
+	if msg.Owner == g.listenAddr {
+		g.showInFlight = false
+	}
+	if err := g.checkRevealIndices(msg.Owner, msg.Kind, msg.HandNumber, msg.Indices); err != nil {
+		logrus.Errorf("Rejected card reveal by %s: %s", msg.Owner, err)
+		return
+	}
+	data, err := g.checkRevealLayers(msg.Owner, msg.Kind, msg.HandNumber, msg.Indices, msg.Layers, true)
+	if err != nil {
+		logrus.Errorf("Rejected card reveal by %s: %s", msg.Owner, err)
+		return
+	}
+	cards := make([]byte, len(data))
+	for i, plain := range data {
+		if len(plain) > 1 || (len(plain) == 1 && plain[0] >= 52) {
+			logrus.Errorf("Reveal of position %d by %s did not decrypt to a card", msg.Indices[i], msg.Owner)
+			return
+		}
+		if len(plain) == 1 {
+			cards[i] = plain[0]
+		}
+	}
+	if msg.Kind == RevealRabbit {
+		g.applyRabbitHunt(msg.HandNumber, cards)
+		return
+	}
+	seen := map[int]bool{}
+	for _, idx := range msg.Indices {
+		if _, ok := g.revealedCards[idx]; ok || seen[idx] {
+			logrus.Errorf("Rejected card reveal by %s: position %d is already revealed", msg.Owner, idx)
+			return
+		}
+		seen[idx] = true
+	}
+	ownerHoles := map[int]bool{}
+	if state, ok := g.playerStates[msg.Owner]; ok && state.InHand {
+		ownerHoles[state.RotationID*2] = true
+		ownerHoles[state.RotationID*2+1] = true
+	}
+	shown, board := []Card{}, []Card{}
+	for i, idx := range msg.Indices {
+		card := NewCardFromByte(cards[i])
+		g.revealedCards[idx] = card
+		switch {
+		case ownerHoles[idx]:
+			g.shownCards[msg.Owner] = append(g.shownCards[msg.Owner], card)
+			shown = append(shown, card)
+			logrus.Infof("Player %s shows %s", msg.Owner, card)
+		case msg.Kind == RevealBoard:
+			board = append(board, card)
+		}
+	}
+	if len(shown) > 0 {
+		g.emit(TableEventCardsRevealed, CardsRevealedEvent{Kind: "shown", Player: msg.Owner, Cards: cardResponses(shown)})
+	}
+	if len(board) > 0 {
+		g.emit(TableEventCardsRevealed, CardsRevealedEvent{Kind: "board", Cards: cardResponses(board)})
+	}
+	g.logEvent(EventCardsRevealed, msg.Owner)
+	g.advanceShowdown()
+}
+
+func (g *Game) hasShownHand(addr string) bool {
+	return len(g.shownCards[addr]) >= 2
+}
@@ -0,0 +1,137 @@
+package p2p
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestShowdownOrder(t *testing.T) {
+	tests := []struct {
+		name 			string
+		dealer 			int
+		lastAggressor 	int
+		folded 			[]int
+		dealtIn 		[]int
+		want 			[]int
+	}{
+		{name: "left of the button without a bet", dealer: 0, lastAggressor: -1, want: []int{1, 2, 3, 0}},
+		{name: "button wraps around", dealer: 3, lastAggressor: -1, want: []int{0, 1, 2, 3}},
+		{name: "last aggressor shows first", dealer: 0, lastAggressor: 2, want: []int{2, 3, 0, 1}},
+		{name: "folded aggressor falls back to the button", dealer: 0, lastAggressor: 2, folded: []int{2}, want: []int{1, 3, 0}},
+		{name: "players sitting out are skipped", dealer: 0, lastAggressor: -1, dealtIn: []int{0, 2, 3}, want: []int{2, 3, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dealtIn := tt.dealtIn
+			if dealtIn == nil {
+				dealtIn = []int{0, 1, 2, 3}
+			}
+			g := newSeatedTable(t, 4, dealtIn...)
+			g.currentDealerID = tt.dealer
+			g.lastAggressorID = tt.lastAggressor
+			for _, seat := range tt.folded {
+				g.playerStates[seatAddr(seat)].IsFolded = true
+			}
+
+			want := []string{}
+			for _, seat := range tt.want {
+				want = append(want, seatAddr(seat))
+			}
+			if got := g.getShowdownOrder(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("order %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMustShow(t *testing.T) {
+	board := []Card{{Spades, 2}, {Hearts, 7}, {Diamonds, 9}, {Clubs, 11}, {Spades, 13}}
+	// a second run where queens make trips
+	secondBoard := []Card{{Spades, 12}, {Hearts, 4}, {Diamonds, 8}, {Clubs, 5}, {Spades, 3}}
+	aces := []Card{{Hearts, 1}, {Diamonds, 1}}
+	queens := []Card{{Hearts, 12}, {Diamonds, 12}}
+
+	tests := []struct {
+		name 	string
+		mine 	[]Card
+		shown 	[]Card
+		runs 	[][]Card
+		want 	bool
+	}{
+		{name: "first to show", mine: queens, want: true},
+		{name: "beats the shown hand", mine: aces, shown: []Card{{Hearts, 13}, {Diamonds, 3}}, want: true},
+		{name: "loses to the shown hand", mine: queens, shown: aces, want: false},
+		{name: "ties the shown hand", mine: []Card{{Clubs, 9}, {Diamonds, 3}}, shown: []Card{{Hearts, 9}, {Clubs, 3}}, want: true},
+		{name: "wins one of two runs", mine: queens, shown: aces, runs: [][]Card{board, secondBoard}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newSeatedTable(t, 2, 0, 1)
+			g.currentPot = 200
+			g.showOrder = []string{seatAddr(1), seatAddr(0)}
+			g.myHand = tt.mine
+			if tt.shown != nil {
+				g.shownCards[seatAddr(1)] = tt.shown
+			}
+			if tt.runs == nil {
+				g.communityCards = board
+			} else {
+				g.handSeats = 2
+				g.runOut = true
+				g.runItTimes = len(tt.runs)
+				for run, cards := range tt.runs {
+					for i, idx := range g.runBoardIndices(run) {
+						g.revealedCards[idx] = cards[i]
+					}
+				}
+			}
+
+			if got := g.mustShow(); got != tt.want {
+				t.Fatalf("must show = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMuck(t *testing.T) {
+	tests := []struct {
+		name 		string
+		from 		string
+		folded 		bool
+		dealtOut 	bool
+		wantErr 	bool
+	}{
+		{name: "live player mucks", from: seatAddr(1)},
+		{name: "folded player has nothing to muck", from: seatAddr(1), folded: true, wantErr: true},
+		{name: "player sitting out has nothing to muck", from: seatAddr(1), dealtOut: true, wantErr: true},
+		{name: "unknown player", from: ":4000", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newSeatedTable(t, 3, 0, 1, 2)
+			state := g.playerStates[seatAddr(1)]
+			state.IsFolded = tt.folded
+			state.InHand = !tt.dealtOut
+
+			err := g.HandleMuck(tt.from)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if g.mucked[tt.from] != !tt.wantErr {
+				t.Fatalf("mucked = %v", g.mucked[tt.from])
+			}
+		})
+	}
+}
+
+func TestMuckOutOfTurn(t *testing.T) {
+	g := newSeatedTable(t, 2, 0, 1)
+	if err := g.Muck(); !errors.Is(err, ErrNotYourTurn) {
+		t.Fatalf("got error %v, want %v", err, ErrNotYourTurn)
+	}
+	if g.mucked[g.listenAddr] {
+		t.Fatal("hand mucked out of turn")
+	}
+}
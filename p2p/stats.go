@@ -0,0 +1,248 @@
+package p2p
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const statsKey = "players"
+
+// PlayerStats holds the raw counts behind a player's statistics. Rates are
+// worked out from them when asked for, so counts from many sessions add up.
+type PlayerStats struct {
+	Hands 					int `json:"hands"`
+	VPIP 					int `json:"vpip"`
+	PFR 					int `json:"pfr"`
+	ThreeBetChances 		int `json:"three_bet_chances"`
+	ThreeBets 				int `json:"three_bets"`
+	PostflopBetsRaises 		int `json:"postflop_bets_raises"`
+	PostflopCalls 			int `json:"postflop_calls"`
+	SawFlop 				int `json:"saw_flop"`
+	WentToShowdown 			int `json:"went_to_showdown"`
+	WonAtShowdown 			int `json:"won_at_showdown"`
+	NetChips 				int `json:"net_chips"`
+	NetBigBlinds 			float64 `json:"net_big_blinds"`
+}
+
+func percent(n, d int) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(d)
+}
+
+func (p PlayerStats) VPIPPercent() float64 { return percent(p.VPIP, p.Hands) }
+func (p PlayerStats) PFRPercent() float64 { return percent(p.PFR, p.Hands) }
+func (p PlayerStats) ThreeBetPercent() float64 { return percent(p.ThreeBets, p.ThreeBetChances) }
+func (p PlayerStats) WTSDPercent() float64 { return percent(p.WentToShowdown, p.SawFlop) }
+func (p PlayerStats) WSDPercent() float64 { return percent(p.WonAtShowdown, p.WentToShowdown) }
+
+// AggressionFactor is postflop bets and raises per call. A player who never
+// calls has their bets and raises returned as is.
+func (p PlayerStats) AggressionFactor() float64 {
+	if p.PostflopCalls == 0 {
+		return float64(p.PostflopBetsRaises)
+	}
+	return float64(p.PostflopBetsRaises) / float64(p.PostflopCalls)
+}
+
+func (p PlayerStats) BigBlindsPer100() float64 {
+	if p.Hands == 0 {
+		return 0
+	}
+	return p.NetBigBlinds * 100 / float64(p.Hands)
+}
+
+// StatsTracker accumulates statistics from finished hand histories and saves
+// them to the node's storage after every hand so they carry across sessions.
+type StatsTracker struct {
+	lock 		sync.RWMutex
+	store 		Storage
+	LastHandID 	int64 					`json:"last_hand_id"`
+	Players 	map[string]*PlayerStats `json:"players"`
+}
+
+func NewStatsTracker(store Storage) (*StatsTracker, error) {
+	t := &StatsTracker{
+		store: 		store,
+		Players: 	make(map[string]*PlayerStats),
+	}
+	data, err := store.Get(BucketStats, statsKey)
+	if err == ErrNotFound {
+		return t, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, err
+	}
+	if t.Players == nil {
+		t.Players = make(map[string]*PlayerStats)
+	}
+	return t, nil
+}
+
+// CatchUp counts any stored hands newer than the last one the tracker saw,
+// for example hands played while the stats file was missing.
+func (t *StatsTracker) CatchUp(hands []*HandHistory) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	counted := false
+	for _, hand := range hands {
+		if hand.ID > t.LastHandID {
+			t.count(hand)
+			counted = true
+		}
+	}
+	if !counted {
+		return nil
+	}
+	return t.save()
+}
+
+func (t *StatsTracker) Record(hand *HandHistory) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if hand.ID <= t.LastHandID {
+		return nil
+	}
+	t.count(hand)
+	return t.save()
+}
+
+// Follow records every hand the game publishes on bus until the returned
+// subscription is closed. Once the tracker has fallen behind and missed a
+// hand, it catches up from history instead of recording the one it got.
+func (t *StatsTracker) Follow(bus *EventBus, history *HandHistoryStore) *Subscription {
+	sub, _ := bus.Subscribe(SubscribeOptions{
+		Types: 	[]TableEventType{TableEventHandRecorded},
+		Policy: BackpressureDropOldest,
+	})
+	var dropped uint64
+	go Handle(sub, func(_ TableEvent, hand *HandHistory) {
+		if missed := sub.Dropped(); missed != dropped {
+			dropped = missed
+			if err := t.CatchUp(history.All()); err != nil {
+				logrus.Errorf("Failed to catch up stats after hand #%d: %s", hand.HandNumber, err)
+			}
+			return
+		}
+		if err := t.Record(hand); err != nil {
+			logrus.Errorf("Failed to save stats after hand #%d: %s", hand.HandNumber, err)
+		}
+	})
+	return sub
+}
+
+func (t *StatsTracker) Get(addr string) (PlayerStats, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	stats, ok := t.Players[addr]
+	if !ok {
+		return PlayerStats{}, false
+	}
+	return *stats, true
+}
+
+func (t *StatsTracker) save() error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return t.store.Put(BucketStats, statsKey, data)
+}
+
+func (t *StatsTracker) count(hand *HandHistory) {
+	t.LastHandID = hand.ID
+
+	type handFlags struct {
+		vpip, pfr, threeBetChance, threeBet, folded, sawFlop bool
+	}
+	flags := make(map[string]*handFlags, len(hand.Seats))
+	for _, seat := range hand.Seats {
+		flags[seat.Player] = &handFlags{}
+	}
+	stats := func(addr string) *PlayerStats {
+		if _, ok := t.Players[addr]; !ok {
+			t.Players[addr] = &PlayerStats{}
+		}
+		return t.Players[addr]
+	}
+
+	preflopRaises := 0
+	for _, action := range hand.Actions {
+		f, ok := flags[action.Player]
+		if !ok || isBlindPost(action.Kind) {
+			continue
+		}
+		aggressive := action.Kind == HistoryBet || action.Kind == HistoryRaise
+		if parseStreet(action.Street) == GameStatusPreFlop {
+			if preflopRaises == 1 && !f.threeBetChance {
+				f.threeBetChance = true
+				f.threeBet = aggressive
+			}
+			if aggressive {
+				preflopRaises++
+				f.pfr = true
+			}
+			if aggressive || action.Kind == HistoryCall {
+				f.vpip = true
+			}
+		} else {
+			f.sawFlop = true
+			if aggressive {
+				stats(action.Player).PostflopBetsRaises++
+			} else if action.Kind == HistoryCall {
+				stats(action.Player).PostflopCalls++
+			}
+		}
+		if action.Kind == HistoryFold {
+			f.folded = true
+		}
+	}
+
+	live := 0
+	for _, f := range flags {
+		if !f.folded {
+			live++
+		}
+	}
+	for _, seat := range hand.Seats {
+		f := flags[seat.Player]
+		s := stats(seat.Player)
+		s.Hands++
+		if f.vpip {
+			s.VPIP++
+		}
+		if f.pfr {
+			s.PFR++
+		}
+		if f.threeBetChance {
+			s.ThreeBetChances++
+		}
+		if f.threeBet {
+			s.ThreeBets++
+		}
+		// all-in players see the flop without acting on it
+		if f.sawFlop || (!f.folded && len(hand.Board) >= 3) {
+			s.SawFlop++
+			if !f.folded && live >= 2 {
+				s.WentToShowdown++
+				if hand.Collected[seat.Player] > 0 {
+					s.WonAtShowdown++
+				}
+			}
+		}
+		net := seat.FinalStack - seat.Stack
+		s.NetChips += net
+		if hand.BigBlind > 0 {
+			s.NetBigBlinds += float64(net) / float64(hand.BigBlind)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package p2p
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Buckets group the records a node keeps in its storage.
+const (
+	BucketSnapshot 	= "snapshot"
+	BucketEvents 	= "events"
+	BucketHands 	= "hands"
+	BucketStats 	= "stats"
+	BucketIdentity 	= "identity"
+	BucketKeys 		= "keys"
+)
+
+var ErrNotFound = fmt.Errorf("not found")
+
+type Record struct {
+	Key 	string
+	Value 	[]byte
+}
+
+// Storage is a small key-value store for everything a node keeps between
+// runs. List returns a bucket in key order, so numeric keys are zero padded
+// with seqKey to keep them in numeric order.
+type Storage interface {
+	Get(bucket, key string) ([]byte, error)
+	Put(bucket, key string, value []byte) error
+	Delete(bucket, key string) error
+	List(bucket string) ([]Record, error)
+	Close() error
+}
+
+func seqKey(seq uint64) string {
+	return fmt.Sprintf("%020d", seq)
+}
+
+// MemoryStorage keeps everything in memory and loses it on exit.
+type MemoryStorage struct {
+	lock 	sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{buckets: make(map[string]map[string][]byte)}
+}
+
+func (m *MemoryStorage) Get(bucket, key string) ([]byte, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	value, ok := m.buckets[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (m *MemoryStorage) Put(bucket, key string, value []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.buckets[bucket]; !ok {
+		m.buckets[bucket] = make(map[string][]byte)
+	}
+	m.buckets[bucket][key] = append([]byte{}, value...)
+	return nil
+}
+
+func (m *MemoryStorage) Delete(bucket, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.buckets[bucket], key)
+	return nil
+}
+
+func (m *MemoryStorage) List(bucket string) ([]Record, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	records := make([]Record, 0, len(m.buckets[bucket]))
+	for key, value := range m.buckets[bucket] {
+		records = append(records, Record{Key: key, Value: append([]byte{}, value...)})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records, nil
+}
+
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+const (
+	diskStorageFile = "peerpoker.kv"
+	diskLockFile 	= "peerpoker.lock"
+	// a record is a crc32 of the rest of the record, a flags byte, the
+	// bucket, key and value lengths, then the bucket, key and value
+	diskHeaderSize 	= 4 + 1 + 2 + 2 + 4
+	diskFlagDelete 	= 1
+	// compaction runs once dead records take up more than this many bytes
+	// and more than the live ones
+	diskCompactMin 	= 1 << 20
+)
+
+type diskEntry struct {
+	offset 	int64
+	size 	uint32
+}
+
+// DiskStorage is an append-only key-value store in a single file. Every
+// write appends a checksummed record and is synced before it returns, and an
+// in-memory index points at the latest value of each key. A record torn by a
+// crash fails its checksum and is cut off the next time the file is opened.
+// Superseded records are dropped by rewriting the file once they pile up.
+type DiskStorage struct {
+	lock 		sync.RWMutex
+	path 		string
+	readOnly 	bool
+	lockFile 	*os.File
+	file 		*os.File
+	size 		int64
+	deadBytes 	int64
+	index 		map[string]map[string]diskEntry
+}
+
+// OpenDiskStorage opens, or creates, the store in dir. Only one process may
+// have it open for writing at a time: it holds an exclusive lock on a lock
+// file next to the store until it is closed.
+func OpenDiskStorage(dir string) (*DiskStorage, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	lockFile, err := lockStorageDir(filepath.Join(dir, diskLockFile))
+	if err != nil {
+		return nil, err
+	}
+	d, err := openDiskStorage(filepath.Join(dir, diskStorageFile), false)
+	if err != nil {
+		lockFile.Close()
+		return nil, err
+	}
+	d.lockFile = lockFile
+	return d, nil
+}
+
+// OpenDiskStorageReadOnly opens the store in dir for reading, for example to
+// export from it while the node that owns it is running.
+func OpenDiskStorageReadOnly(dir string) (*DiskStorage, error) {
+	return openDiskStorage(filepath.Join(dir, diskStorageFile), true)
+}
+
+func openDiskStorage(path string, readOnly bool) (*DiskStorage, error) {
+	flags := os.O_RDWR | os.O_CREATE
+	if readOnly {
+		flags = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flags, 0600)
+	if err != nil {
+		return nil, err
+	}
+	d := &DiskStorage{
+		path: 		path,
+		readOnly: 	readOnly,
+		file: 		file,
+	}
+	if err := d.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if d.shouldCompact() {
+		if err := d.compact(); err != nil {
+			logrus.Errorf("Failed to compact %s: %s", path, err)
+		}
+	}
+	return d, nil
+}
+
+// load rebuilds the index by reading every record in the file.
+func (d *DiskStorage) load() error {
+	d.index = make(map[string]map[string]diskEntry)
+	d.size = 0
+	d.deadBytes = 0
+
+	info, err := d.file.Stat()
+	if err != nil {
+		return err
+	}
+	reader := io.NewSectionReader(d.file, 0, info.Size())
+	header := make([]byte, diskHeaderSize)
+	for d.size < info.Size() {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			return d.cutTornRecord(info.Size())
+		}
+		bucketLen := int64(binary.BigEndian.Uint16(header[5:7]))
+		keyLen := int64(binary.BigEndian.Uint16(header[7:9]))
+		valueLen := int64(binary.BigEndian.Uint32(header[9:13]))
+		body := make([]byte, bucketLen+keyLen+valueLen)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return d.cutTornRecord(info.Size())
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(header[4:])
+		crc.Write(body)
+		if crc.Sum32() != binary.BigEndian.Uint32(header[0:4]) {
+			return d.cutTornRecord(info.Size())
+		}
+
+		bucket := string(body[:bucketLen])
+		key := string(body[bucketLen : bucketLen+keyLen])
+		recordSize := diskHeaderSize + int64(len(body))
+		if header[4]&diskFlagDelete != 0 {
+			d.drop(bucket, key)
+			d.deadBytes += recordSize
+		} else {
+			d.set(bucket, key, diskEntry{
+				offset: d.size + diskHeaderSize + bucketLen + keyLen,
+				size: 	uint32(valueLen),
+			})
+		}
+		d.size += recordSize
+	}
+	return nil
+}
+
+// cutTornRecord drops whatever follows the last whole record, which can only
+// be a write that a crash interrupted.
+func (d *DiskStorage) cutTornRecord(fileSize int64) error {
+	logrus.Warnf("Dropping %d byte(s) of torn writes at the end of %s", fileSize-d.size, d.path)
+	if d.readOnly {
+		return nil
+	}
+	if err := d.file.Truncate(d.size); err != nil {
+		return err
+	}
+	return d.file.Sync()
+}
+
+func (d *DiskStorage) set(bucket, key string, entry diskEntry) {
+	if _, ok := d.index[bucket]; !ok {
+		d.index[bucket] = make(map[string]diskEntry)
+	}
+	d.drop(bucket, key)
+	d.index[bucket][key] = entry
+}
+
+// drop forgets a key and counts the record holding its value as dead.
+func (d *DiskStorage) drop(bucket, key string) {
+	old, ok := d.index[bucket][key]
+	if !ok {
+		return
+	}
+	d.deadBytes += diskHeaderSize + int64(len(bucket)+len(key)) + int64(old.size)
+	delete(d.index[bucket], key)
+}
+
+func (d *DiskStorage) Get(bucket, key string) ([]byte, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	entry, ok := d.index[bucket][key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return d.read(entry)
+}
+
+func (d *DiskStorage) read(entry diskEntry) ([]byte, error) {
+	value := make([]byte, entry.size)
+	if _, err := d.file.ReadAt(value, entry.offset); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (d *DiskStorage) Put(bucket, key string, value []byte) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if err := d.append(0, bucket, key, value); err != nil {
+		return err
+	}
+	if d.shouldCompact() {
+		if err := d.compact(); err != nil {
+			logrus.Errorf("Failed to compact %s: %s", d.path, err)
+		}
+	}
+	return nil
+}
+
+func (d *DiskStorage) Delete(bucket, key string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if _, ok := d.index[bucket][key]; !ok {
+		return nil
+	}
+	return d.append(diskFlagDelete, bucket, key, nil)
+}
+
+func (d *DiskStorage) List(bucket string) ([]Record, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	records := make([]Record, 0, len(d.index[bucket]))
+	for key, entry := range d.index[bucket] {
+		value, err := d.read(entry)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, Record{Key: key, Value: value})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records, nil
+}
+
+func (d *DiskStorage) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	err := d.file.Close()
+	if d.lockFile != nil {
+		d.lockFile.Close()
+	}
+	return err
+}
+
+// append writes one record at the end of the file and syncs it.
+func (d *DiskStorage) append(flags byte, bucket, key string, value []byte) error {
+	if d.readOnly {
+		return fmt.Errorf("%s is open read-only", d.path)
+	}
+	record, err := encodeDiskRecord(flags, bucket, key, value)
+	if err != nil {
+		return err
+	}
+	if _, err := d.file.WriteAt(record, d.size); err != nil {
+		return err
+	}
+	if err := d.file.Sync(); err != nil {
+		return err
+	}
+	recordSize := int64(len(record))
+	if flags&diskFlagDelete != 0 {
+		d.drop(bucket, key)
+		d.deadBytes += recordSize
+	} else {
+		d.set(bucket, key, diskEntry{
+			offset: d.size + diskHeaderSize + int64(len(bucket)+len(key)),
+			size: 	uint32(len(value)),
+		})
+	}
+	d.size += recordSize
+	return nil
+}
+
+func encodeDiskRecord(flags byte, bucket, key string, value []byte) ([]byte, error) {
+	if len(bucket) > 0xffff || len(key) > 0xffff || int64(len(value)) > 0xffffffff {
+		return nil, fmt.Errorf("record %s/%s is too large", bucket, key)
+	}
+	record := make([]byte, diskHeaderSize, diskHeaderSize+len(bucket)+len(key)+len(value))
+	record[4] = flags
+	binary.BigEndian.PutUint16(record[5:7], uint16(len(bucket)))
+	binary.BigEndian.PutUint16(record[7:9], uint16(len(key)))
+	binary.BigEndian.PutUint32(record[9:13], uint32(len(value)))
+	record = append(record, bucket...)
+	record = append(record, key...)
+	record = append(record, value...)
+	binary.BigEndian.PutUint32(record[0:4], crc32.ChecksumIEEE(record[4:]))
+	return record, nil
+}
+
+func (d *DiskStorage) shouldCompact() bool {
+	return !d.readOnly && d.deadBytes > diskCompactMin && d.deadBytes > d.size-d.deadBytes
+}
+
+// compact writes the live records to a new file and swaps it in.
+func (d *DiskStorage) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	for bucket, keys := range d.index {
+		for key, entry := range keys {
+			value, err := d.read(entry)
+			if err != nil {
+				tmp.Close()
+				return err
+			}
+			record, err := encodeDiskRecord(0, bucket, key, value)
+			if err != nil {
+				tmp.Close()
+				return err
+			}
+			if _, err := tmp.Write(record); err != nil {
+				tmp.Close()
+				return err
+			}
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), d.path); err != nil {
+		tmp.Close()
+		return err
+	}
+	// the rename only survives a crash once the directory is synced
+	if err := syncDir(filepath.Dir(d.path)); err != nil {
+		logrus.Errorf("Failed to sync %s after compaction: %s", filepath.Dir(d.path), err)
+	}
+	d.file.Close()
+	d.file = tmp
+	return d.load()
+}
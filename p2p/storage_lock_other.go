@@ -0,0 +1,21 @@
+//go:build !unix
+
+package p2p
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// lockStorageDir only creates the lock file on platforms without flock;
+// keeping a single writer per data directory is up to the operator there.
+func lockStorageDir(path string) (*os.File, error) {
+	logrus.Warnf("Cannot lock %s on this platform, make sure only one node uses the data directory", path)
+	return os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+}
+
+// syncDir is a no-op where directories cannot be synced.
+func syncDir(dir string) error {
+	return nil
+}
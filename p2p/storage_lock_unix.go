@@ -0,0 +1,37 @@
+//go:build unix
+
+package p2p
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockStorageDir takes an exclusive lock on the lock file at path, failing
+// straight away if another process holds it. The lock goes with the file,
+// so it is released when the file is closed or the process dies.
+func lockStorageDir(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, fmt.Errorf("%s is locked, another node is using this data directory", path)
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
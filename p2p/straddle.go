@@ -0,0 +1,128 @@
+package p2p
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+type StraddleKind byte
+
+const (
+	StraddleNone StraddleKind = iota
+	StraddleUTG
+	StraddleButton
+)
+
+func (k StraddleKind) String() string {
+	switch k {
+	case StraddleNone:
+		return "NONE"
+	case StraddleUTG:
+		return "UTG"
+	case StraddleButton:
+		return "BUTTON"
+	default:
+		return "INVALID"
+	}
+}
+
+func ParseStraddleKind(s string) (StraddleKind, error) {
+	switch strings.ToUpper(s) {
+	case "UTG", "LIVE":
+		return StraddleUTG, nil
+	case "BUTTON", "MISSISSIPPI":
+		return StraddleButton, nil
+	default:
+		return StraddleNone, newRuleError(ErrInvalidRequest, "unknown straddle type %q, want UTG or BUTTON", s)
+	}
+}
+
+// AnnounceStraddle tells the table we want to straddle the next hand. The
+// straddle only goes in if we end up in the matching seat when the blinds
+// move, otherwise the announcement lapses.
+func (g *Game) AnnounceStraddle(kind StraddleKind) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if err := g.validateStraddle(kind); err != nil {
+		return err
+	}
+	g.straddles[g.listenAddr] = kind
+	g.logEvent(EventStraddle, g.listenAddr)
+	g.sendToPlayers(MessageStraddle{Kind: kind}, g.getOtherPlayers()...)
+	return nil
+}
+
+func (g *Game) HandleStraddle(from string, msg MessageStraddle) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if err := g.validateStraddle(msg.Kind); err != nil {
+		return fmt.Errorf("rejected straddle from %s: %s", from, err)
+	}
+	g.straddles[from] = msg.Kind
+	logrus.Infof("Player %s announced a %s straddle for the next hand", from, msg.Kind)
+	g.logEvent(EventStraddle, from)
+	return nil
+}
+
+func (g *Game) validateStraddle(kind StraddleKind) error {
+	switch kind {
+	case StraddleUTG:
+		if !g.config.AllowStraddle {
+			return newRuleError(ErrNotAllowed, "straddles are not allowed at this table")
+		}
+	case StraddleButton:
+		if !g.config.AllowButtonStraddle {
+			return newRuleError(ErrNotAllowed, "button straddles are not allowed at this table")
+		}
+	default:
+		return newRuleError(ErrInvalidRequest, "invalid straddle: %s", kind)
+	}
+	return nil
+}
+
+// postStraddle puts in an announced straddle of twice the big blind after
+// the blinds are posted. A button straddle wins over an UTG straddle. The
+// straddler becomes the last raiser, so they keep the option, and preflop
+// action starts with the player to their left.
+func (g *Game) postStraddle() {
+	defer func() {
+		g.straddles = make(map[string]StraddleKind)
+	}()
+	g.straddleAmount = 0
+	if g.countDealtIn() < 3 || len(g.straddles) == 0 {
+		return
+	}
+
+	seat := -1
+	if addr := g.rotationMap[g.currentDealerID]; g.isDealtIn(g.currentDealerID) && g.straddles[addr] == StraddleButton {
+		seat = g.currentDealerID
+	} else {
+		utgID := g.getNextActivePlayerID(g.bigBlindID)
+		if g.straddles[g.rotationMap[utgID]] == StraddleUTG {
+			seat = utgID
+		}
+	}
+	if seat < 0 {
+		return
+	}
+
+	amount := 2 * g.config.BigBlind
+	addr := g.rotationMap[seat]
+	if g.playerStates[addr].Stack + g.playerStates[addr].CurrentRoundBet <= amount {
+		logrus.Infof("Player %s is too short to straddle", addr)
+		return
+	}
+	stackBefore := g.playerStates[addr].Stack
+	g.updatePlayerState(addr, PlayerActionBet, amount)
+	g.recordAction(addr, HistoryPostStraddle, stackBefore)
+	logrus.Infof("Player %s posted a %s straddle: %d", addr, g.straddles[addr], amount)
+
+	g.straddleAmount = amount
+	g.lastRaiserID = seat
+	g.lastRaiseAmount = amount
+	g.setTurn(g.getNextActivePlayerID(seat))
+}
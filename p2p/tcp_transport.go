@@ -12,6 +12,10 @@ type Peer struct {
 	conn 		net.Conn
 	outbound 	bool 
 	listenAddr 	string 
+	// dialAddr is the address we dialled an outbound peer on
+	dialAddr 	string
+	// nonce is the challenge we sent in our handshake
+	nonce 		[]byte
 	writeLock 	sync.Mutex
 }
 
@@ -30,6 +34,12 @@ func (p *Peer) ReadLoop(msgch chan *Message, delPeerch chan *Peer){
 			logrus.Errorf("Peer %s: decode message error: %s", p.listenAddr, err)
 			break 
 		}
+		// the handshake proved who is on this connection, so it can only
+		// speak for that player
+		if msg.From != p.listenAddr {
+			logrus.Warnf("Peer %s: dropping message claiming to be from %s", p.listenAddr, msg.From)
+			continue
+		}
 		msgch <- msg 
 	}
 	delPeerch <- p
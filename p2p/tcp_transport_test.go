@@ -0,0 +1,46 @@
+package p2p
+
+import (
+	"encoding/gob"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadLoopDropsSpoofedSender(t *testing.T) {
+	local, remote := net.Pipe()
+	peer := &Peer{conn: local, listenAddr: ":3001"}
+	msgch := make(chan *Message, 4)
+	delPeer := make(chan *Peer, 1)
+	go peer.ReadLoop(msgch, delPeer)
+
+	tests := []struct {
+		from 	string
+		want 	bool
+	}{
+		{from: ":3002", want: false},
+		{from: "", want: false},
+		{from: ":3001", want: true},
+	}
+	enc := gob.NewEncoder(remote)
+	for _, tt := range tests {
+		if err := enc.Encode(NewMessage(tt.from, MessageReady{})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remote.Close()
+
+	select {
+	case <-delPeer:
+	case <-time.After(time.Second):
+		t.Fatal("read loop did not finish")
+	}
+	close(msgch)
+	got := []string{}
+	for msg := range msgch {
+		got = append(got, msg.From)
+	}
+	if len(got) != 1 || got[0] != ":3001" {
+		t.Fatalf("passed on messages from %v, want only the one from :3001", got)
+	}
+}